@@ -0,0 +1,293 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// aclReloadInterval is how often the ACL file is checked for changes
+	aclReloadInterval = 2 * time.Second
+	aclSubjectAll     = "all"
+)
+
+// aclRule is a single allow/deny line from the ACL file
+type aclRule struct {
+	pattern  []string
+	allow    bool
+	subjects []string
+	except   []string
+}
+
+// aclRules is an immutable parsed ACL file
+type aclRules struct {
+	rules  []aclRule
+	groups map[string][]string
+}
+
+// ACL enforces per-path access rules loaded from a file.
+//
+// The file is line based. Blank lines and lines starting with # are ignored.
+// Groups are declared with:
+//
+//	group <name> <user>...
+//
+// Rules have the form:
+//
+//	<pattern> <allow|deny> <subject>... [except <subject>...]
+//
+// Patterns are slash separated paths relative to the served root. Each
+// segment may use path.Match wildcards and a "**" segment matches any number
+// of segments. Subjects are user names, group names or "all".
+//
+// Rules are evaluated in order and the first rule that matches both the path
+// and the user decides. Paths without a matching rule are allowed. A path is
+// only visible if it and every one of its parent directories is allowed, so
+// denying a directory always hides its whole subtree.
+//
+// The file is reloaded when it changes. If a reload fails the previous rules
+// stay in effect.
+type ACL struct {
+	path           string
+	reloadInterval time.Duration
+
+	mu        sync.Mutex
+	rules     *aclRules
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// LoadACL loads an ACL from the file at path
+func LoadACL(path string) (*ACL, error) {
+	a := &ACL{
+		path:           path,
+		reloadInterval: aclReloadInterval,
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat ACL file: %w", err)
+	}
+
+	rules, err := parseACLFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	a.rules = rules
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+	a.lastCheck = time.Now()
+
+	return a, nil
+}
+
+// Allowed reports whether user may see urlPath. A nil ACL allows everything.
+func (a *ACL) Allowed(user, urlPath string) bool {
+	if a == nil {
+		return true
+	}
+
+	rules := a.current()
+	segments := splitACLPath(urlPath)
+
+	// Check the path and every parent so a denied directory hides its subtree
+	for i := 0; i <= len(segments); i++ {
+		if !rules.allowed(user, segments[:i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// current returns the active rules, reloading the file if it has changed
+func (a *ACL) current() *aclRules {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.lastCheck) < a.reloadInterval {
+		return a.rules
+	}
+	a.lastCheck = now
+
+	info, err := os.Stat(a.path)
+	if err != nil {
+		slog.Warn("failed to stat ACL file, keeping previous rules", "path", a.path, "error", err)
+		return a.rules
+	}
+
+	if info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return a.rules
+	}
+
+	rules, err := parseACLFile(a.path)
+	if err != nil {
+		slog.Warn("failed to reload ACL file, keeping previous rules", "path", a.path, "error", err)
+		return a.rules
+	}
+
+	a.rules = rules
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+
+	return a.rules
+}
+
+// allowed evaluates the rules for a single path
+func (r *aclRules) allowed(user string, segments []string) bool {
+	for _, rule := range r.rules {
+		if !matchACLPattern(rule.pattern, segments) {
+			continue
+		}
+		if r.matchesAny(user, rule.subjects) && !r.matchesAny(user, rule.except) {
+			return rule.allow
+		}
+	}
+
+	return true
+}
+
+// matchesAny reports whether user is one of subjects or a member of one of them
+func (r *aclRules) matchesAny(user string, subjects []string) bool {
+	for _, subject := range subjects {
+		if subject == aclSubjectAll || subject == user {
+			return true
+		}
+		for _, member := range r.groups[subject] {
+			if member == user {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parseACLFile reads and parses an ACL file
+func parseACLFile(filename string) (*aclRules, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ACL file: %w", err)
+	}
+	defer f.Close()
+
+	rules := &aclRules{groups: make(map[string][]string)}
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if fields[0] == "group" {
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("ACL line %d: group requires a name", lineNum)
+			}
+			rules.groups[fields[1]] = append(rules.groups[fields[1]], fields[2:]...)
+			continue
+		}
+
+		rule, err := parseACLRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("ACL line %d: %w", lineNum, err)
+		}
+		rules.rules = append(rules.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ACL file: %w", err)
+	}
+
+	return rules, nil
+}
+
+// parseACLRule parses the fields of a single rule line
+func parseACLRule(fields []string) (aclRule, error) {
+	if len(fields) < 3 {
+		return aclRule{}, fmt.Errorf("rule must have a pattern, an action and at least one subject")
+	}
+
+	if !strings.HasPrefix(fields[0], "/") {
+		return aclRule{}, fmt.Errorf("pattern %q must start with /", fields[0])
+	}
+
+	rule := aclRule{pattern: splitACLPath(fields[0])}
+	for _, segment := range rule.pattern {
+		if _, err := path.Match(segment, ""); err != nil {
+			return aclRule{}, fmt.Errorf("invalid pattern %q: %w", fields[0], err)
+		}
+	}
+
+	switch fields[1] {
+	case "allow":
+		rule.allow = true
+	case "deny":
+		rule.allow = false
+	default:
+		return aclRule{}, fmt.Errorf("unknown action %q", fields[1])
+	}
+
+	subjects := fields[2:]
+	for i, subject := range subjects {
+		if subject == "except" {
+			rule.except = subjects[i+1:]
+			subjects = subjects[:i]
+			break
+		}
+	}
+	if len(subjects) == 0 {
+		return aclRule{}, fmt.Errorf("rule must have at least one subject")
+	}
+	rule.subjects = subjects
+
+	return rule, nil
+}
+
+// splitACLPath splits a slash separated path into its cleaned segments
+func splitACLPath(p string) []string {
+	cleaned := strings.Trim(path.Clean("/"+p), "/")
+	if cleaned == "" {
+		return nil
+	}
+	return strings.Split(cleaned, "/")
+}
+
+// matchACLPattern matches path segments against pattern segments where "**"
+// matches zero or more segments
+func matchACLPattern(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchACLPattern(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	ok, err := path.Match(pattern[0], segments[0])
+	if err != nil || !ok {
+		return false
+	}
+
+	return matchACLPattern(pattern[1:], segments[1:])
+}
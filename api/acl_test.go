@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeACLFile(t *testing.T, dir, content string) string {
+	t.Helper()
+
+	aclFile := filepath.Join(dir, "acl")
+	if err := os.WriteFile(aclFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write ACL file: %v", err)
+	}
+	return aclFile
+}
+
+func TestACLAllowed(t *testing.T) {
+	aclFile := writeACLFile(t, t.TempDir(), `
+# ops can see everything
+group ops alice
+
+/secrets/** deny all except ops
+/team-*/private deny bob
+/public allow all
+`)
+
+	acl, err := LoadACL(aclFile)
+	if err != nil {
+		t.Fatalf("LoadACL() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		user string
+		path string
+		want bool
+	}{
+		{
+			name: "root is allowed",
+			user: "bob",
+			path: "/",
+			want: true,
+		},
+		{
+			name: "denied directory",
+			user: "bob",
+			path: "/secrets",
+			want: false,
+		},
+		{
+			name: "denied subtree",
+			user: "bob",
+			path: "/secrets/keys/id_rsa",
+			want: false,
+		},
+		{
+			name: "group member is excepted",
+			user: "alice",
+			path: "/secrets/keys",
+			want: true,
+		},
+		{
+			name: "wildcard segment",
+			user: "bob",
+			path: "/team-a/private",
+			want: false,
+		},
+		{
+			name: "children of denied path are denied",
+			user: "bob",
+			path: "/team-a/private/notes.txt",
+			want: false,
+		},
+		{
+			name: "rule for other user does not apply",
+			user: "alice",
+			path: "/team-a/private",
+			want: true,
+		},
+		{
+			name: "unmatched path is allowed",
+			user: "bob",
+			path: "/other",
+			want: true,
+		},
+		{
+			name: "path is cleaned before matching",
+			user: "bob",
+			path: "/public/../secrets",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acl.Allowed(tt.user, tt.path); got != tt.want {
+				t.Errorf("Allowed(%q, %q) = %v, want %v", tt.user, tt.path, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("nil ACL allows everything", func(t *testing.T) {
+		var acl *ACL
+		if !acl.Allowed("bob", "/secrets") {
+			t.Error("nil ACL denied access")
+		}
+	})
+}
+
+func TestLoadACLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "unknown action",
+			content: "/secrets maybe all",
+		},
+		{
+			name:    "missing subject",
+			content: "/secrets deny",
+		},
+		{
+			name:    "relative pattern",
+			content: "secrets deny all",
+		},
+		{
+			name:    "only except subjects",
+			content: "/secrets deny except ops",
+		},
+		{
+			name:    "bad glob",
+			content: "/[ deny all",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aclFile := writeACLFile(t, t.TempDir(), tt.content)
+			if _, err := LoadACL(aclFile); err == nil {
+				t.Error("LoadACL() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestACLReload(t *testing.T) {
+	aclFile := writeACLFile(t, t.TempDir(), "/secrets deny all")
+
+	acl, err := LoadACL(aclFile)
+	if err != nil {
+		t.Fatalf("LoadACL() error = %v", err)
+	}
+	acl.reloadInterval = 0
+
+	if acl.Allowed("bob", "/secrets") {
+		t.Fatal("Allowed() = true before reload, want false")
+	}
+
+	if err := os.WriteFile(aclFile, []byte("/secrets deny all except bob"), 0644); err != nil {
+		t.Fatalf("failed to rewrite ACL file: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(aclFile, future, future); err != nil {
+		t.Fatalf("failed to update ACL mtime: %v", err)
+	}
+
+	if !acl.Allowed("bob", "/secrets") {
+		t.Error("Allowed() = false after reload, want true")
+	}
+
+	// A broken file keeps the previous rules
+	if err := os.WriteFile(aclFile, []byte("/secrets nope"), 0644); err != nil {
+		t.Fatalf("failed to rewrite ACL file: %v", err)
+	}
+	future = future.Add(time.Minute)
+	if err := os.Chtimes(aclFile, future, future); err != nil {
+		t.Fatalf("failed to update ACL mtime: %v", err)
+	}
+
+	if !acl.Allowed("bob", "/secrets") {
+		t.Error("Allowed() = false after failed reload, want previous rules")
+	}
+}
+
+func TestGetFilesWithACL(t *testing.T) {
+	tmpDir := t.TempDir()
+	rootDir := filepath.Join(tmpDir, "root")
+
+	for _, dir := range []string{"secrets", "public"} {
+		if err := os.MkdirAll(filepath.Join(rootDir, dir), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+
+	// A symlink must not expose a denied directory under another name
+	if err := os.Symlink(filepath.Join(rootDir, "secrets"), filepath.Join(rootDir, "public", "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	acl, err := LoadACL(writeACLFile(t, tmpDir, "group ops alice\n/secrets/** deny all except ops\n"))
+	if err != nil {
+		t.Fatalf("LoadACL() error = %v", err)
+	}
+
+	s := &Server{rootDir: rootDir, acl: acl}
+
+	getAs := func(user, urlPath string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, urlPath, nil)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		w := httptest.NewRecorder()
+		s.getFiles(w, req)
+		return w
+	}
+
+	t.Run("denied entries are filtered from listings", func(t *testing.T) {
+		w := getAs("bob", "/api/files/")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+
+		var fileInfo FileInfo
+		if err := json.NewDecoder(w.Body).Decode(&fileInfo); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(fileInfo.Contents) != 1 || fileInfo.Contents[0].Name != "public" {
+			t.Errorf("contents = %+v, want only public", fileInfo.Contents)
+		}
+	})
+
+	t.Run("allowed user sees denied entries", func(t *testing.T) {
+		w := getAs("alice", "/api/files/")
+
+		var fileInfo FileInfo
+		if err := json.NewDecoder(w.Body).Decode(&fileInfo); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(fileInfo.Contents) != 2 {
+			t.Errorf("len(fileInfo.Contents) = %v, want %v", len(fileInfo.Contents), 2)
+		}
+	})
+
+	t.Run("denied path is not found", func(t *testing.T) {
+		if w := getAs("bob", "/api/files/secrets"); w.Code != http.StatusNotFound {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("symlink into denied path is not found", func(t *testing.T) {
+		if w := getAs("bob", "/api/files/public/link"); w.Code != http.StatusNotFound {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusNotFound)
+		}
+	})
+}
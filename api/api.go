@@ -1,7 +1,10 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
@@ -9,6 +12,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -25,19 +30,180 @@ type Server struct {
 	handler        http.Handler
 	rootDir        string
 	sessionManager *SessionManager
+	acl            *ACL
+	userHomes      bool
+	mounts         []*Mount
+	audit          *AuditLog
+	auditForwarder *AuditForwarder
+	metrics        *metrics
+	metricsToken   string
+	tracer         *Tracer
+	tlsCert        *tls.Certificate
+	symlinkPolicy  SymlinkPolicy
+
+	statConcurrency int
+	statBudget      time.Duration
+	listingCache    *listingCache
+	watches         *watchHub
+	archiveMaxBytes int64
+	archiveMaxFiles int
+	thumbnails      *thumbnailer
+	uploads         *uploadManager
+
+	mu         sync.Mutex
+	httpServer *http.Server
+	closeOnce  sync.Once
+	closeErr   error
+}
+
+// Option configures optional Server behavior
+type Option func(*Server) error
+
+// WithACLFile enforces the access rules in the ACL file at path
+func WithACLFile(path string) Option {
+	return func(s *Server) error {
+		acl, err := LoadACL(path)
+		if err != nil {
+			return err
+		}
+		s.acl = acl
+		return nil
+	}
+}
+
+// WithUsersFile replaces the built in users with those in the JSON file at path
+func WithUsersFile(path string) Option {
+	return func(s *Server) error {
+		users, err := LoadUsersFile(path)
+		if err != nil {
+			return err
+		}
+		s.sessionManager.SetUsers(users)
+		return nil
+	}
+}
+
+// WithUserHomes jails each user to their configured home directory. Users with
+// the admin role still see the whole tree.
+func WithUserHomes() Option {
+	return func(s *Server) error {
+		s.userHomes = true
+		return nil
+	}
+}
+
+// WithAuditLog records authentication and file access events in the audit
+// log at path, chaining records with key
+func WithAuditLog(path string, key []byte) Option {
+	return func(s *Server) error {
+		audit, err := OpenAuditLog(path, key)
+		if err != nil {
+			return err
+		}
+		s.audit = audit
+		return nil
+	}
+}
+
+// WithAuditForwarder ships audit log records to a remote collector. It
+// requires WithAuditLog.
+func WithAuditForwarder(cfg AuditForwarderConfig) Option {
+	return func(s *Server) error {
+		f, err := NewAuditForwarder(cfg)
+		if err != nil {
+			return err
+		}
+		s.auditForwarder = f
+		return nil
+	}
+}
+
+// WithMetricsToken serves Prometheus metrics at /metrics to requests bearing
+// token. Metrics can also be served on an internal listener with
+// MetricsHandler.
+func WithMetricsToken(token string) Option {
+	return func(s *Server) error {
+		if token == "" {
+			return fmt.Errorf("metrics token must not be empty")
+		}
+		s.metricsToken = token
+		return nil
+	}
+}
+
+// WithTLSCertificate serves HTTPS using the certificate and key in the PEM
+// files certFile and keyFile
+func WithTLSCertificate(certFile, keyFile string) Option {
+	return func(s *Server) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		s.tlsCert = &cert
+		return nil
+	}
+}
+
+// WithTracing exports request traces to an OpenTelemetry collector
+func WithTracing(cfg TracingConfig) Option {
+	return func(s *Server) error {
+		t, err := NewTracer(cfg)
+		if err != nil {
+			return err
+		}
+		s.tracer = t
+		return nil
+	}
+}
+
+// contextKey is the type for request context keys set by this package
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	requestInfoContextKey
+)
+
+// userFromContext returns the authenticated user stored by requireAuth
+func userFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userContextKey).(string)
+	return user
 }
 
 // FileInfo represents information about a file or directory
 type FileInfo struct {
-	Name     string      `json:"name"`
-	Type     string      `json:"type"`
-	Size     int64       `json:"size"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Size     int64  `json:"size"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+
+	// Link is set for entries of type symlink
+	Link *LinkInfo `json:"link,omitempty"`
+	// Error is set for entries whose information couldn't be read
+	Error *EntryError `json:"error,omitempty"`
+
+	// Optional fields, only set when requested with fields=
+	ModTime  *time.Time `json:"modTime,omitempty"`
+	Mode     string     `json:"mode,omitempty"`
+	Owner    string     `json:"owner,omitempty"`
+	Group    string     `json:"group,omitempty"`
+	Links    uint64     `json:"links,omitempty"`
+	Hidden   bool       `json:"hidden,omitempty"`
+	MimeType string     `json:"mimeType,omitempty"`
+
 	Contents []*FileInfo `json:"contents,omitempty"`
+	// Page is set for directories when the listing was paginated
+	Page *PageInfo `json:"page,omitempty"`
+
+	// info is kept so entries can be ordered and described after filtering
+	info os.FileInfo
+	// validators identify the state the response was read from
+	validators validators
 }
 
 // NewServer creates a directory browser server.
 // It serves webassets from the provided filesystem.
-func NewServer(webassets fs.FS) (*Server, error) {
+func NewServer(webassets fs.FS, opts ...Option) (*Server, error) {
 	// Get the current working directory as the root directory
 	rootDir, err := os.Getwd()
 	if err != nil {
@@ -46,33 +212,86 @@ func NewServer(webassets fs.FS) (*Server, error) {
 
 	mux := http.NewServeMux()
 	s := &Server{
-		handler:        mux,
 		rootDir:        rootDir,
 		sessionManager: NewSessionManager(),
+		metrics:        newMetrics(),
+	}
+	s.watches = newWatchHub(s.describeWatchedEntry)
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			// Release what the options before it opened
+			s.Close()
+			return nil, err
+		}
+	}
+
+	if s.auditForwarder != nil {
+		if s.audit == nil {
+			s.auditForwarder.Close()
+			return nil, fmt.Errorf("audit forwarding requires an audit log")
+		}
+		s.audit.SetForwarder(s.auditForwarder)
+	}
+
+	roots := []string{s.rootDir}
+	if len(s.mounts) > 0 {
+		roots = roots[:0]
+		for _, m := range s.mounts {
+			roots = append(roots, m.Root)
+		}
+	}
+	if err := s.thumbnails.checkCacheDir(roots); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.uploads.checkStateDir(roots); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.uploads.start(s.reopenUploadDir)
+
+	// handle registers a route with request metrics
+	handle := func(route string, handler http.Handler) {
+		mux.Handle(route, s.instrument(route, handler))
 	}
 
 	// API routes
-	mux.Handle("/api/hello", http.HandlerFunc(s.hello))
-	mux.Handle("/api/login", http.HandlerFunc(s.login))
-	mux.Handle("/api/logout", http.HandlerFunc(s.logout))
-	mux.Handle("/api/files/", http.HandlerFunc(s.requireAuth(s.getFiles)))
+	handle("/api/hello", http.HandlerFunc(s.hello))
+	handle("/healthz", http.HandlerFunc(s.healthz))
+	handle("/readyz", http.HandlerFunc(s.readyz))
+	handle("/api/login", http.HandlerFunc(s.login))
+	handle("/api/logout", http.HandlerFunc(s.logout))
+	handle("/api/audit", http.HandlerFunc(s.requireAuth(s.requireRole(RoleAuditor, s.getAudit))))
+	handle("/api/files/", http.HandlerFunc(s.requireAuth(s.audited(AuditActionList, "/api/files", s.getFiles))))
+	handle("/api/preview/", http.HandlerFunc(s.requireAuth(s.audited(AuditActionPreview, "/api/preview", s.previewFile))))
+	handle("/api/thumbnail/", http.HandlerFunc(s.requireAuth(s.audited(AuditActionThumbnail, "/api/thumbnail", s.getThumbnail))))
+	handle("/api/download/", http.HandlerFunc(s.requireAuth(s.audited(AuditActionDownload, "/api/download", s.downloadFile))))
+	handle("/api/archive/", http.HandlerFunc(s.requireAuth(s.audited(AuditActionArchive, "/api/archive", s.downloadArchive))))
+	handle("/api/upload/", http.HandlerFunc(s.requireAuth(s.audited(AuditActionUpload, "/api/upload", s.uploadFiles))))
+	handle("/api/uploads/", http.HandlerFunc(s.requireAuth(s.audited(AuditActionUpload, "/api/uploads", s.resumeUpload))))
+	handle("/api/watch/", http.HandlerFunc(s.requireAuth(s.audited(AuditActionWatch, "/api/watch", s.watchFiles))))
+	// Unknown API routes get an error rather than the web app
+	handle("/api/", http.HandlerFunc(notFound))
+
+	if s.metricsToken != "" {
+		handle("/metrics", http.HandlerFunc(requireBearerToken(s.metricsToken, s.serveMetrics)))
+	}
 
 	// web assets
 	hfs := http.FS(webassets)
 	files := http.FileServer(hfs)
-	mux.Handle("/assets/", files)
-	mux.Handle("/favicon.ico", files)
+	handle("/assets/", files)
+	handle("/favicon.ico", files)
 
 	// fall back to index.html for all unknown routes
-	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFileFS(w, r, webassets, "index.html")
 	}))
 
-	return s, nil
-}
+	s.handler = s.traceRequests(s.accessLog(mux))
 
-func (s *Server) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, s.handler)
+	return s, nil
 }
 
 // hello is an example API endpoint
@@ -83,65 +302,239 @@ func (s *Server) hello(w http.ResponseWriter, r *http.Request) {
 // getFiles handles GET requests to /api/files/<path>
 func (s *Server) getFiles(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodGet)
 		return
 	}
 
-	// Extract the path from the URL
-	urlPath := strings.TrimPrefix(r.URL.Path, "/api/files")
-	if urlPath == "" {
-		urlPath = "/"
+	target, ok := s.resolveTarget(w, r, strings.TrimPrefix(r.URL.Path, "/api/files"))
+	if !ok {
+		return
 	}
 
-	// Validate the path
-	if err := s.validatePath(urlPath); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// The root of a server with named mounts lists the mounts
+	if target.mount == nil {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.listMounts(target.user)); err != nil {
+			requestLogger(r).Error("failed to write response", "error", err)
+		}
 		return
 	}
+	mount, user, userRoot, fullPath := target.mount, target.user, target.root, target.fullPath
 
-	// Resolve the full path
-	fullPath, err := s.resolvePath(urlPath)
+	// Parse the optional fields to include and the page to return
+	fields, err := parseFileFields(r.URL.Query().Get("fields"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeRequestError(w, r, err, ErrorCodeInvalidParameter, http.StatusBadRequest)
+		return
+	}
+	page, err := parsePageQuery(r.URL.Query())
+	if err != nil {
+		writeRequestError(w, r, err, ErrorCodeInvalidParameter, http.StatusBadRequest)
 		return
 	}
 
 	// Check if the path exists
+	_, sp := startSpan(r.Context(), "os.Stat")
+	sp.setAttribute("file.path", fullPath)
 	info, err := os.Stat(fullPath)
+	sp.finish(err)
 	if err != nil {
 		if os.IsNotExist(err) {
-			http.Error(w, "File or directory does not exist", http.StatusNotFound)
+			writeError(w, r, ErrorCodeNotFound, "File or directory does not exist", http.StatusNotFound)
 			return
 		}
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		internalError(w, r, "failed to stat path", err)
+		return
+	}
+
+	opts := listOptions{
+		root:    userRoot,
+		fields:  fields,
+		visible: s.visibleEntries(mount, user, fullPath),
+		page:    page,
+	}
+
+	// Stream large listings without building them in memory
+	if wantsNDJSON(r) {
+		params := r.URL.Query()
+		if params.Has("sort") || params.Has("order") || params.Has("limit") || params.Has("cursor") {
+			writeError(w, r, ErrorCodeInvalidParameter, "sort, order, limit and cursor are not supported when streaming", http.StatusBadRequest)
+			return
+		}
+
+		_, sp = startSpan(r.Context(), "streamFileInfo")
+		sp.setAttribute("file.path", fullPath)
+		s.streamFileInfo(w, r, fullPath, info, opts)
+		sp.finish(nil)
 		return
 	}
 
 	// Read directory information
-	fileInfo, err := s.readFileInfo(fullPath, info)
+	_, sp = startSpan(r.Context(), "readFileInfo")
+	sp.setAttribute("file.path", fullPath)
+	fileInfo, err := s.readFileInfo(r.Context(), fullPath, info, opts)
+	if err == nil {
+		sp.setAttribute("file.entries", len(fileInfo.Contents))
+	}
+	sp.finish(err)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		internalError(w, r, "failed to read file info", err)
+		return
+	}
+
+	// Let clients that already have this listing skip downloading it again
+	if checkNotModified(w, r, fileInfo.validators.etag(r.URL.Query()), fileInfo.validators.modTime) {
 		return
 	}
 
 	// Return JSON response
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(fileInfo); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		requestLogger(r).Error("failed to write response", "error", err)
 		return
 	}
 }
 
+// previewFile handles GET requests to /api/preview/<path>, returning the start
+// of a text file or a window of its lines
+func (s *Server) previewFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	query, err := parsePreviewQuery(r.URL.Query())
+	if err != nil {
+		writeRequestError(w, r, err, ErrorCodeInvalidParameter, http.StatusBadRequest)
+		return
+	}
+
+	target, ok := s.resolveTarget(w, r, strings.TrimPrefix(r.URL.Path, "/api/preview"))
+	if !ok {
+		return
+	}
+	if target.mount == nil {
+		writeError(w, r, ErrorCodeNotAFile, "Only files can be previewed", http.StatusBadRequest)
+		return
+	}
+
+	f, info, ok := openRegularFile(w, r, target.fullPath)
+	if !ok {
+		return
+	}
+	defer f.Close()
+
+	v := newValidators(info, nil)
+	if checkNotModified(w, r, v.etag(r.URL.Query()), v.modTime) {
+		return
+	}
+
+	_, sp := startSpan(r.Context(), "readPreview")
+	sp.setAttribute("file.path", target.fullPath)
+	preview, err := readPreview(f, info, query)
+	sp.finish(err)
+	if err != nil {
+		switch {
+		case errors.Is(err, errBinaryFile):
+			writeRequestError(w, r, err, ErrorCodeBinaryFile, http.StatusUnprocessableEntity)
+		case errors.As(err, new(*apiError)):
+			writeRequestError(w, r, err, ErrorCodeInvalidParameter, http.StatusBadRequest)
+		default:
+			internalError(w, r, "failed to read file", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(preview); err != nil {
+		requestLogger(r).Error("failed to write response", "error", err)
+	}
+}
+
+// requestTarget is the file or directory a request refers to
+type requestTarget struct {
+	// mount is nil for the root of a server with named mounts
+	mount *Mount
+	user  string
+	// root is the directory the user's paths are confined to
+	root     string
+	fullPath string
+}
+
+// resolveTarget validates urlPath and resolves it for the request's user,
+// checking the ACL. It replies with an error and returns false if the path
+// can't be served.
+func (s *Server) resolveTarget(w http.ResponseWriter, r *http.Request, urlPath string) (*requestTarget, bool) {
+	if urlPath == "" {
+		urlPath = "/"
+	}
+
+	// Validate the path
+	_, sp := startSpan(r.Context(), "validatePath")
+	err := s.validatePath(urlPath)
+	sp.finish(err)
+	if err != nil {
+		writeRequestError(w, r, err, ErrorCodeInvalidPath, http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Find the mount and directory this user's paths are relative to
+	user := userFromContext(r.Context())
+	_, sp = startSpan(r.Context(), "locate")
+	mount, userRoot, relPath, err := s.locate(user, urlPath)
+	sp.finish(err)
+	if err != nil {
+		if errors.Is(err, errMountNotFound) {
+			writeError(w, r, ErrorCodeNotFound, "File or directory does not exist", http.StatusNotFound)
+			return nil, false
+		}
+		writeError(w, r, ErrorCodeForbidden, err.Error(), http.StatusForbidden)
+		return nil, false
+	}
+	if mount == nil {
+		return &requestTarget{user: user}, true
+	}
+
+	// Resolve the full path
+	_, sp = startSpan(r.Context(), "resolvePath")
+	fullPath, err := s.resolveRequestPath(userRoot, relPath)
+	sp.finish(err)
+	if err != nil {
+		switch {
+		case errors.Is(err, errSymlinkHidden):
+			writeError(w, r, ErrorCodeNotFound, "File or directory does not exist", http.StatusNotFound)
+		case errors.Is(err, errSymlinkDenied), errors.Is(err, errSymlinkOutsideRoot):
+			writeRequestError(w, r, err, ErrorCodeForbidden, http.StatusForbidden)
+		default:
+			writeRequestError(w, r, err, ErrorCodeBadRequest, http.StatusBadRequest)
+		}
+		return nil, false
+	}
+
+	// Hidden paths are reported as missing so their names don't leak
+	requestedPath := filepath.Join(userRoot, filepath.Clean("/"+relPath))
+	if !s.allowed(mount, user, requestedPath, fullPath) || isUploadTempName(relPath) {
+		writeError(w, r, ErrorCodeNotFound, "File or directory does not exist", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &requestTarget{mount: mount, user: user, root: userRoot, fullPath: fullPath}, true
+}
+
 // validatePath validates the path according to security requirements
 func (s *Server) validatePath(path string) error {
 	// Check length
 	if len(path) > maxPathLength {
-		return fmt.Errorf("path exceeds maximum length of %d characters", maxPathLength)
+		return &apiError{
+			code:    ErrorCodePathTooLong,
+			message: fmt.Sprintf("path exceeds maximum length of %d characters", maxPathLength),
+			details: map[string]any{"maxLength": maxPathLength},
+		}
 	}
 
 	// Check against whitelist regex
 	if !pathWhitelistRegex.MatchString(path) {
-		return fmt.Errorf("path contains invalid characters")
+		return &apiError{code: ErrorCodeInvalidPath, message: "path contains invalid characters"}
 	}
 
 	return nil
@@ -149,11 +542,16 @@ func (s *Server) validatePath(path string) error {
 
 // resolvePath resolves the path and ensures it's within the root directory
 func (s *Server) resolvePath(urlPath string) (string, error) {
+	return resolvePathIn(s.rootDir, urlPath)
+}
+
+// resolvePathIn resolves the path and ensures it's within rootDir
+func resolvePathIn(rootDir, urlPath string) (string, error) {
 	// Clean the path to remove any .. or . components
 	cleanPath := filepath.Clean(urlPath)
 
 	// Join with root directory
-	fullPath := filepath.Join(s.rootDir, cleanPath)
+	fullPath := filepath.Join(rootDir, cleanPath)
 
 	// Get absolute paths for comparison
 	fullPathAbs, err := filepath.Abs(fullPath)
@@ -161,7 +559,7 @@ func (s *Server) resolvePath(urlPath string) (string, error) {
 		return "", fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
-	rootDirAbs, err := filepath.Abs(s.rootDir)
+	rootDirAbs, err := filepath.Abs(rootDir)
 	if err != nil {
 		return "", fmt.Errorf("failed to get absolute root directory: %w", err)
 	}
@@ -174,7 +572,7 @@ func (s *Server) resolvePath(urlPath string) (string, error) {
 
 	// If the relative path starts with "..", it's outside the root directory
 	if strings.HasPrefix(relPath, "..") {
-		return "", fmt.Errorf("path traversal attempt detected")
+		return "", &apiError{code: ErrorCodePathTraversal, message: "path traversal attempt detected"}
 	}
 
 	// Resolve any symlinks if the path exists
@@ -196,58 +594,151 @@ func (s *Server) resolvePath(urlPath string) (string, error) {
 
 	// If the relative path starts with "..", it's outside the root directory
 	if strings.HasPrefix(relPathResolved, "..") {
-		return "", fmt.Errorf("path traversal attempt detected")
+		return "", errSymlinkOutsideRoot
 	}
 
 	return resolvedPath, nil
 }
 
-// readFileInfo reads information about a file or directory
-func (s *Server) readFileInfo(path string, info os.FileInfo) (*FileInfo, error) {
+// listOptions controls what readFileInfo includes
+type listOptions struct {
+	// root is the directory that requests for the listed paths are confined
+	// to, used to tell whether symbolic links can be followed
+	root   string
+	fields fileFields
+	// visible reports whether an entry may be listed, nil lists everything
+	visible func(name string) bool
+	// page filters, orders and limits the entries, nil lists them all in
+	// directory order
+	page *pageQuery
+}
+
+// readFileInfo reads information about a file or directory and, for a
+// directory, each of its entries. Optional fields are filled in if requested.
+func (s *Server) readFileInfo(ctx context.Context, path string, info os.FileInfo, opts listOptions) (*FileInfo, error) {
+	start := time.Now()
+	listed := -1
+	defer func() {
+		s.metrics.observeRead(listed, time.Since(start))
+	}()
+
 	fileInfo := &FileInfo{
 		Name: info.Name(),
 		Size: info.Size(),
 	}
+	names := newOwnerNames()
+	fillMetadata(fileInfo, path, info, opts.fields, names)
 
 	if info.IsDir() {
 		fileInfo.Type = "directory"
 		fileInfo.Size = 0
 
-		// Read directory contents
-		entries, err := os.ReadDir(path)
+		contents, err := s.listDirectory(ctx, path, info, opts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read directory: %w", err)
+			return nil, err
+		}
+		fileInfo.Contents = contents
+		listed = len(fileInfo.Contents)
+		fileInfo.validators = newValidators(info, fileInfo.Contents)
+
+		if opts.page != nil {
+			fileInfo.Contents, fileInfo.Page = opts.page.apply(fileInfo.Contents)
 		}
 
-		fileInfo.Contents = make([]*FileInfo, 0, len(entries))
-		for _, entry := range entries {
-			entryInfo, err := entry.Info()
-			if err != nil {
-				// Skip entries we can't read
-				continue
-			}
-
-			entryFileInfo := &FileInfo{
-				Name: entry.Name(),
-				Size: entryInfo.Size(),
-			}
-
-			if entry.IsDir() {
-				entryFileInfo.Type = "directory"
-				entryFileInfo.Size = 0
-			} else {
-				entryFileInfo.Type = "file"
-			}
-
-			fileInfo.Contents = append(fileInfo.Contents, entryFileInfo)
+		// Only describe the entries that are returned, this can be slow
+		for _, entry := range fileInfo.Contents {
+			describeEntry(entry, path, opts, names)
 		}
 	} else {
 		fileInfo.Type = "file"
+		fileInfo.validators = newValidators(info, nil)
 	}
 
 	return fileInfo, nil
 }
 
+// readDirectory reads a directory and stats the entries visible allows to be
+// listed, or every entry if visible is nil
+func (s *Server) readDirectory(ctx context.Context, path string, visible func(name string) bool) ([]*FileInfo, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	entries = visibleDirEntries(entries, listOptions{visible: visible})
+	ctx, cancel := s.withStatBudget(ctx)
+	defer cancel()
+	results := s.statEntries(ctx, entries)
+
+	contents := make([]*FileInfo, 0, len(entries))
+	for i, entry := range entries {
+		if entryFileInfo := s.newEntry(entry, results[i]); entryFileInfo != nil {
+			contents = append(contents, entryFileInfo)
+		}
+	}
+	return contents, nil
+}
+
+// visibleDirEntries removes the entries opts doesn't allow to be listed
+func visibleDirEntries(entries []os.DirEntry, opts listOptions) []os.DirEntry {
+	if opts.visible == nil {
+		return entries
+	}
+
+	visible := entries[:0]
+	for _, entry := range entries {
+		if opts.visible(entry.Name()) {
+			visible = append(visible, entry)
+		}
+	}
+	return visible
+}
+
+// newEntry returns the basic information for a directory entry, or nil if it
+// isn't listed. Entries that couldn't be read are listed with an error and
+// the type reported by the directory.
+func (s *Server) newEntry(entry os.DirEntry, stat statResult) *FileInfo {
+	entryFileInfo := &FileInfo{Name: entry.Name()}
+
+	mode := entry.Type()
+	if stat.err != nil {
+		entryFileInfo.Error = newEntryError(stat.err)
+	} else {
+		mode = stat.info.Mode()
+		entryFileInfo.Size = stat.info.Size()
+		entryFileInfo.info = stat.info
+	}
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		if s.symlinkPolicy == SymlinkHide {
+			return nil
+		}
+		entryFileInfo.Type = "symlink"
+	case entry.IsDir():
+		entryFileInfo.Type = "directory"
+		entryFileInfo.Size = 0
+	default:
+		entryFileInfo.Type = "file"
+	}
+
+	return entryFileInfo
+}
+
+// describeEntry fills in the link and optional fields of an entry of the
+// directory at dirPath
+func describeEntry(entry *FileInfo, dirPath string, opts listOptions, names *ownerNames) {
+	if entry.info == nil {
+		return
+	}
+
+	entryPath := filepath.Join(dirPath, entry.Name)
+	if entry.Type == "symlink" {
+		entry.Link = describeLink(opts.root, entryPath)
+	}
+	fillMetadata(entry, entryPath, entry.info, opts.fields, names)
+}
+
 // LoginRequest represents a login request
 type LoginRequest struct {
 	Username string `json:"username"`
@@ -257,34 +748,56 @@ type LoginRequest struct {
 // login handles POST requests to /api/login
 func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 
 	// Require Content-Type: application/json for CSRF protection
 	contentType := r.Header.Get("Content-Type")
 	if contentType != "application/json" {
-		http.Error(w, "Content-Type must be application/json", http.StatusBadRequest)
+		writeError(w, r, ErrorCodeInvalidContentType, "Content-Type must be application/json", http.StatusBadRequest)
 		return
 	}
 
 	var req LoginRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeErrorDetails(w, r, ErrorCodeBodyTooLarge, "Request body too large", http.StatusRequestEntityTooLarge,
+				map[string]any{"maxBytes": maxBytesErr.Limit})
+			return
+		}
+		writeError(w, r, ErrorCodeInvalidBody, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	// Create session
 	token, err := s.sessionManager.CreateSession(req.Username, req.Password)
 	if err != nil {
+		s.auditRequest(r, AuditEvent{
+			Action: AuditActionLogin,
+			User:   req.Username,
+			Result: AuditResultFailure,
+			Reason: err.Error(),
+		})
+		s.metrics.observeLogin(AuditResultFailure)
 		if err == ErrInvalidCredentials {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			writeError(w, r, ErrorCodeInvalidCredentials, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		internalError(w, r, "failed to create session", err)
 		return
 	}
 
+	s.metrics.observeLogin(AuditResultSuccess)
+	s.auditRequest(r, AuditEvent{
+		Action:    AuditActionLogin,
+		User:      req.Username,
+		SessionID: sessionID(token),
+		Result:    AuditResultSuccess,
+	})
+
 	// Set secure cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookieName,
@@ -302,15 +815,22 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 // logout handles POST requests to /api/logout
 func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 
 	// Get session cookie
 	cookie, err := r.Cookie(sessionCookieName)
 	if err == nil {
-		// Delete session
+		// Look up the user for the audit log before deleting the session
+		user, _ := s.sessionManager.ValidateSession(cookie.Value)
 		s.sessionManager.DeleteSession(cookie.Value)
+
+		s.auditRequest(r, AuditEvent{
+			Action: AuditActionLogout,
+			User:   user,
+			Result: AuditResultSuccess,
+		})
 	}
 
 	// Clear cookie
@@ -330,21 +850,81 @@ func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
 // requireAuth is a middleware that requires authentication
 func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, sp := startSpan(r.Context(), "requireAuth")
+
 		// Get session cookie
 		cookie, err := r.Cookie(sessionCookieName)
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			sp.finish(err)
+			s.auditRequest(r, AuditEvent{
+				Action: AuditActionAuth,
+				Path:   r.URL.Path,
+				Result: AuditResultFailure,
+				Reason: "no session cookie",
+			})
+			writeError(w, r, ErrorCodeUnauthenticated, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
 		// Validate session
-		_, err = s.sessionManager.ValidateSession(cookie.Value)
+		_, validateSpan := startSpan(ctx, "ValidateSession")
+		user, err := s.sessionManager.ValidateSession(cookie.Value)
+		validateSpan.finish(err)
+		sp.finish(err)
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			s.auditRequest(r, AuditEvent{
+				Action: AuditActionAuth,
+				Path:   r.URL.Path,
+				Result: AuditResultFailure,
+				Reason: err.Error(),
+			})
+			writeError(w, r, ErrorCodeUnauthenticated, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if info := requestInfoFromContext(r.Context()); info != nil {
+			info.user = user
+		}
+		spanFromContext(r.Context()).setAttribute("enduser.id", user)
+
+		// Call next handler with the user attached to the request
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	}
+}
+
+// requireRole is a middleware that requires the authenticated user to have
+// role. It must run after requireAuth.
+func (s *Server) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, exists := s.sessionManager.User(userFromContext(r.Context()))
+		if !exists || !user.HasRole(role) {
+			writeError(w, r, ErrorCodeForbidden, "Forbidden", http.StatusForbidden)
 			return
 		}
 
-		// Call next handler
 		next(w, r)
 	}
 }
+
+// statusRecorder captures the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -165,7 +166,7 @@ func TestReadFileInfo(t *testing.T) {
 			t.Fatalf("failed to stat test file: %v", err)
 		}
 
-		fileInfo, err := s.readFileInfo(testFile, info)
+		fileInfo, err := s.readFileInfo(context.Background(), testFile, info, listOptions{})
 		if err != nil {
 			t.Errorf("readFileInfo() error = %v", err)
 			return
@@ -188,7 +189,7 @@ func TestReadFileInfo(t *testing.T) {
 			t.Fatalf("failed to stat test dir: %v", err)
 		}
 
-		fileInfo, err := s.readFileInfo(tmpDir, info)
+		fileInfo, err := s.readFileInfo(context.Background(), tmpDir, info, listOptions{})
 		if err != nil {
 			t.Errorf("readFileInfo() error = %v", err)
 			return
@@ -283,3 +284,77 @@ func TestGetFilesEndpoint(t *testing.T) {
 		}
 	})
 }
+
+func TestGetFilesWithUserHomes(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, dir := range []string{"homes/carol/drop", "homes/dave", "shared"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+
+	// A symlink out of the home directory must not be followed
+	if err := os.Symlink(filepath.Join(tmpDir, "shared"), filepath.Join(tmpDir, "homes", "carol", "escape")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	sm := NewSessionManager()
+	sm.SetUsers(map[string]*User{
+		"carol": {Username: "carol", Home: "homes/carol"},
+		"erin":  {Username: "erin"},
+		"root":  {Username: "root", Roles: []string{RoleAdmin}},
+	})
+	s := &Server{rootDir: tmpDir, sessionManager: sm, userHomes: true}
+
+	getAs := func(user, urlPath string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, urlPath, nil)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		w := httptest.NewRecorder()
+		s.getFiles(w, req)
+		return w
+	}
+
+	t.Run("root is the home directory", func(t *testing.T) {
+		w := getAs("carol", "/api/files/")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+
+		var fileInfo FileInfo
+		if err := json.NewDecoder(w.Body).Decode(&fileInfo); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if fileInfo.Name != "carol" || len(fileInfo.Contents) != 2 {
+			t.Errorf("fileInfo = %+v, want carol home listing", fileInfo)
+		}
+	})
+
+	t.Run("parent directories are not reachable", func(t *testing.T) {
+		w := getAs("carol", "/api/files/../dave")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("symlinks out of the home are refused", func(t *testing.T) {
+		w := getAs("carol", "/api/files/escape")
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("user without a home is forbidden", func(t *testing.T) {
+		w := getAs("erin", "/api/files/")
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("admin sees the whole tree", func(t *testing.T) {
+		w := getAs("root", "/api/files/shared")
+		if w.Code != http.StatusOK {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+	})
+}
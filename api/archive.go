@@ -0,0 +1,409 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultArchiveMaxBytes = 1 << 30
+	defaultArchiveMaxFiles = 10000
+
+	archiveFormatZip   = "zip"
+	archiveFormatTarGz = "tar.gz"
+
+	// archiveErrorTrailer is sent after an archive that was cut short, since
+	// the status has already been sent by then
+	archiveErrorTrailer = "X-Archive-Error"
+)
+
+var (
+	// errArchiveFileChanged is returned when a file shrinks or is replaced
+	// between planning an archive and writing it
+	errArchiveFileChanged = &apiError{code: ErrorCodeFileChanged, message: "a file changed while it was being archived"}
+)
+
+// WithArchiveLimits bounds the total size of the files in a directory archive
+// and how many files and directories it may contain. Larger directories are
+// refused before anything is sent.
+func WithArchiveLimits(maxBytes int64, maxFiles int) Option {
+	return func(s *Server) error {
+		if maxBytes < 1 || maxFiles < 1 {
+			return errors.New("archive size and file limits must be positive")
+		}
+		s.archiveMaxBytes = maxBytes
+		s.archiveMaxFiles = maxFiles
+		return nil
+	}
+}
+
+// archiveLimits returns the most bytes and entries an archive may contain
+func (s *Server) archiveLimits() (int64, int) {
+	maxBytes, maxFiles := s.archiveMaxBytes, s.archiveMaxFiles
+	if maxBytes <= 0 {
+		maxBytes = defaultArchiveMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultArchiveMaxFiles
+	}
+	return maxBytes, maxFiles
+}
+
+// archiveEntry is a file or directory to add to an archive
+type archiveEntry struct {
+	// name is the slash separated path in the archive, ending in / for
+	// directories
+	name string
+	// path is where the file is read from, with symbolic links resolved
+	path string
+	info os.FileInfo
+}
+
+// archivePlan lists everything an archive will contain, in order
+type archivePlan struct {
+	entries []archiveEntry
+	bytes   int64
+}
+
+// downloadArchive handles GET requests to /api/archive/<path>, streaming a
+// directory and everything under it as a zip or gzipped tar file
+func (s *Server) downloadArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = archiveFormatZip
+	}
+	if format != archiveFormatZip && format != archiveFormatTarGz {
+		writeRequestError(w, r, invalidParameter("format", "format must be %s or %s", archiveFormatZip, archiveFormatTarGz),
+			ErrorCodeInvalidParameter, http.StatusBadRequest)
+		return
+	}
+
+	urlPath := strings.TrimPrefix(r.URL.Path, "/api/archive")
+	target, ok := s.resolveTarget(w, r, urlPath)
+	if !ok {
+		return
+	}
+	if target.mount == nil {
+		writeError(w, r, ErrorCodeNotADirectory, "Only directories can be archived", http.StatusBadRequest)
+		return
+	}
+
+	info, err := os.Stat(target.fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, r, ErrorCodeNotFound, "File or directory does not exist", http.StatusNotFound)
+			return
+		}
+		internalError(w, r, "failed to stat path", err)
+		return
+	}
+	if !info.IsDir() {
+		writeError(w, r, ErrorCodeNotADirectory, "Only directories can be archived", http.StatusBadRequest)
+		return
+	}
+
+	// The archive is named after the directory as the user sees it
+	name := path.Base(path.Clean("/" + urlPath))
+	if name == "/" {
+		name = "files"
+	}
+
+	// Walk the whole tree first so a directory that's too large is refused
+	// with an error rather than a truncated archive
+	_, sp := startSpan(r.Context(), "planArchive")
+	sp.setAttribute("file.path", target.fullPath)
+	plan, err := s.planArchive(r.Context(), target, name, info)
+	if err == nil {
+		sp.setAttribute("archive.entries", len(plan.entries))
+		sp.setAttribute("archive.bytes", plan.bytes)
+	}
+	sp.finish(err)
+	if err != nil {
+		var apiErr *apiError
+		switch {
+		case r.Context().Err() != nil:
+			// The client went away
+		case errors.As(err, &apiErr):
+			writeRequestError(w, r, err, ErrorCodeInternal, http.StatusUnprocessableEntity)
+		case os.IsPermission(err):
+			writeError(w, r, ErrorCodeForbidden, "Permission denied", http.StatusForbidden)
+		default:
+			internalError(w, r, "failed to read directory", err)
+		}
+		return
+	}
+
+	// Large archives take longer than the server's timeouts to send
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	filename := name + "." + format
+	setDownloadHeaders(w, filename)
+	h := w.Header()
+	if format == archiveFormatZip {
+		h.Set("Content-Type", "application/zip")
+	} else {
+		h.Set("Content-Type", "application/gzip")
+	}
+	h.Set("Cache-Control", "no-store")
+	h.Set("Trailer", archiveErrorTrailer)
+	w.WriteHeader(http.StatusOK)
+
+	_, sp = startSpan(r.Context(), "writeArchive")
+	sp.setAttribute("archive.format", format)
+	err = writeArchive(r.Context(), w, format, plan)
+	sp.finish(err)
+	if err != nil {
+		// The archive is left without its trailing index or checksum so that
+		// it can't be mistaken for a complete one
+		if r.Context().Err() != nil {
+			requestLogger(r).Info("archive download canceled", "path", target.fullPath)
+		} else {
+			requestLogger(r).Error("failed to write archive", "path", target.fullPath, "error", err)
+		}
+		code := ErrorCodeInternal
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			code = apiErr.code
+		}
+		h.Set(archiveErrorTrailer, code)
+	}
+}
+
+// planArchive walks the directory at target.fullPath and lists what its
+// archive will contain under the directory name. Entries are filtered like a
+// listing: the ACL and symlink policy apply, links are only followed inside
+// the user's root and anything other than files and directories is skipped.
+func (s *Server) planArchive(ctx context.Context, target *requestTarget, name string, info os.FileInfo) (*archivePlan, error) {
+	maxBytes, maxFiles := s.archiveLimits()
+	tooLarge := &apiError{
+		code:    ErrorCodeArchiveTooLarge,
+		message: fmt.Sprintf("directory has more than %d files or %d bytes", maxFiles, maxBytes),
+		details: map[string]any{"maxFiles": maxFiles, "maxBytes": maxBytes},
+	}
+
+	plan := &archivePlan{}
+	// ancestors holds the resolved directories being walked, so links can't
+	// make the walk go round in circles
+	ancestors := make(map[string]bool)
+
+	var walk func(dirPath, realPath, archiveName string, info os.FileInfo) error
+	walk = func(dirPath, realPath, archiveName string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if ancestors[realPath] {
+			return nil
+		}
+		ancestors[realPath] = true
+		defer delete(ancestors, realPath)
+
+		plan.entries = append(plan.entries, archiveEntry{name: archiveName + "/", path: realPath, info: info})
+		if len(plan.entries) > maxFiles {
+			return tooLarge
+		}
+
+		entries, err := os.ReadDir(realPath)
+		if err != nil {
+			return err
+		}
+		visible := s.visibleEntries(target.mount, target.user, dirPath)
+
+		for _, entry := range entries {
+			if visible != nil && !visible(entry.Name()) {
+				continue
+			}
+			entryPath := filepath.Join(dirPath, entry.Name())
+			entryRealPath := filepath.Join(realPath, entry.Name())
+			entryName := archiveName + "/" + entry.Name()
+
+			entryInfo, err := entry.Info()
+			if os.IsNotExist(err) {
+				// Removed since the directory was read
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if entryInfo.Mode()&os.ModeSymlink != 0 {
+				if s.symlinkPolicy == SymlinkDeny || s.symlinkPolicy == SymlinkHide {
+					continue
+				}
+				if !describeLink(target.root, entryRealPath).InsideRoot {
+					continue
+				}
+				if entryRealPath, err = filepath.EvalSymlinks(entryRealPath); err != nil {
+					continue
+				}
+				if entryInfo, err = os.Stat(entryRealPath); err != nil {
+					continue
+				}
+			}
+			// Below a followed link the ACL applies to where it points too
+			if entryRealPath != entryPath && !s.allowed(target.mount, target.user, entryPath, entryRealPath) {
+				continue
+			}
+
+			switch {
+			case entryInfo.IsDir():
+				if err := walk(entryPath, entryRealPath, entryName, entryInfo); err != nil {
+					return err
+				}
+			case entryInfo.Mode().IsRegular():
+				plan.entries = append(plan.entries, archiveEntry{name: entryName, path: entryRealPath, info: entryInfo})
+				plan.bytes += entryInfo.Size()
+				if len(plan.entries) > maxFiles || plan.bytes > maxBytes {
+					return tooLarge
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk(target.fullPath, target.fullPath, name, info); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// archiveWriter adds entries to an archive in one format
+type archiveWriter interface {
+	// add starts an entry, returning where a file's contents are written
+	add(entry archiveEntry) (io.Writer, error)
+	Close() error
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) add(entry archiveEntry) (io.Writer, error) {
+	hdr, err := zip.FileInfoHeader(entry.info)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = entry.name
+	if entry.info.IsDir() {
+		hdr.Method = zip.Store
+	} else {
+		hdr.Method = zip.Deflate
+	}
+	return a.zw.CreateHeader(hdr)
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarGzArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarGzArchive) add(entry archiveEntry) (io.Writer, error) {
+	hdr, err := tar.FileInfoHeader(entry.info, "")
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = entry.name
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	return a.tw, nil
+}
+
+func (a *tarGzArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// writeArchive writes the entries of plan to w. It stops as soon as ctx is
+// done, and on any error returns without finishing the archive.
+func writeArchive(ctx context.Context, w io.Writer, format string, plan *archivePlan) error {
+	var archive archiveWriter
+	if format == archiveFormatZip {
+		archive = &zipArchive{zw: zip.NewWriter(w)}
+	} else {
+		gz := gzip.NewWriter(w)
+		archive = &tarGzArchive{gz: gz, tw: tar.NewWriter(gz)}
+	}
+
+	for _, entry := range plan.entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dst, err := archive.add(entry)
+		if err != nil {
+			return err
+		}
+		if entry.info.IsDir() {
+			continue
+		}
+		if err := copyArchiveFile(ctx, dst, entry); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// copyArchiveFile copies as many bytes of a file as the archive was planned
+// with, since the size may already have been written
+func copyArchiveFile(ctx context.Context, dst io.Writer, entry archiveEntry) error {
+	f, err := os.Open(entry.path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", entry.path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", entry.path, err)
+	}
+	if !info.Mode().IsRegular() || info.Size() < entry.info.Size() {
+		return errArchiveFileChanged
+	}
+
+	if _, err := io.CopyN(dst, &contextReader{ctx: ctx, r: f}, entry.info.Size()); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errArchiveFileChanged
+		}
+		return err
+	}
+	return nil
+}
+
+// contextReader stops reading once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
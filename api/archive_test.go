@@ -0,0 +1,215 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// zipNames returns the names of the entries in a zip file
+func zipNames(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to open zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// tarGzNames returns the names of the entries in a gzipped tar file
+func tarGzNames(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to open gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestDownloadArchive(t *testing.T) {
+	root := newSymlinkTree(t)
+
+	archive := func(s *Server, urlPath string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.downloadArchive(w, httptest.NewRequest(http.MethodGet, urlPath, nil))
+		return w
+	}
+
+	followed := []string{
+		"files/",
+		"files/docs/",
+		"files/docs/readme.txt",
+		"files/to-docs/",
+		"files/to-docs/readme.txt",
+		"files/to-readme",
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		w := archive(&Server{rootDir: root}, "/api/archive/")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/zip" {
+			t.Errorf("Content-Type = %q, want application/zip", got)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != "attachment; filename=files.zip" {
+			t.Errorf("Content-Disposition = %q, want attachment; filename=files.zip", got)
+		}
+		if got := zipNames(t, w.Body.Bytes()); !slices.Equal(got, followed) {
+			t.Errorf("entries = %v, want %v", got, followed)
+		}
+		if got := w.Result().Trailer.Get(archiveErrorTrailer); got != "" {
+			t.Errorf("%s = %q, want none", archiveErrorTrailer, got)
+		}
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		w := archive(&Server{rootDir: root}, "/api/archive/docs?format=tar.gz")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+		}
+		want := []string{"docs/", "docs/readme.txt"}
+		if got := tarGzNames(t, w.Body.Bytes()); !slices.Equal(got, want) {
+			t.Errorf("entries = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("links aren't followed when denied", func(t *testing.T) {
+		w := archive(&Server{rootDir: root, symlinkPolicy: SymlinkDeny}, "/api/archive/")
+		want := []string{"files/", "files/docs/", "files/docs/readme.txt"}
+		if got := zipNames(t, w.Body.Bytes()); !slices.Equal(got, want) {
+			t.Errorf("entries = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("denied paths are left out", func(t *testing.T) {
+		acl, err := LoadACL(writeACLFile(t, t.TempDir(), "/docs/** deny all\n"))
+		if err != nil {
+			t.Fatalf("LoadACL() error = %v", err)
+		}
+		w := archive(&Server{rootDir: root, acl: acl}, "/api/archive/")
+		// The link's target is denied too
+		want := []string{"files/"}
+		if got := zipNames(t, w.Body.Bytes()); !slices.Equal(got, want) {
+			t.Errorf("entries = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		for _, limits := range [][2]int{{1, 100}, {100, 2}} {
+			s := &Server{rootDir: root}
+			if err := WithArchiveLimits(int64(limits[0]), limits[1])(s); err != nil {
+				t.Fatalf("WithArchiveLimits() error = %v", err)
+			}
+			w := archive(s, "/api/archive/")
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status code = %v, want %v", w.Code, http.StatusUnprocessableEntity)
+			}
+			if code := decodeError(t, w).Code; code != ErrorCodeArchiveTooLarge {
+				t.Errorf("error code = %v, want %v", code, ErrorCodeArchiveTooLarge)
+			}
+		}
+	})
+
+	tests := []struct {
+		name       string
+		urlPath    string
+		wantStatus int
+		wantCode   string
+	}{
+		{"file", "/api/archive/docs/readme.txt", http.StatusBadRequest, ErrorCodeNotADirectory},
+		{"missing", "/api/archive/missing", http.StatusNotFound, ErrorCodeNotFound},
+		{"link outside root", "/api/archive/escape", http.StatusForbidden, ErrorCodeSymlinkOutsideRoot},
+		{"unknown format", "/api/archive/?format=rar", http.StatusBadRequest, ErrorCodeInvalidParameter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := archive(&Server{rootDir: root}, tt.urlPath)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status code = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if code := decodeError(t, w).Code; code != tt.wantCode {
+				t.Errorf("error code = %v, want %v", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWriteArchiveAborts(t *testing.T) {
+	root := t.TempDir()
+	logPath := filepath.Join(root, "app.log")
+	if err := os.WriteFile(logPath, []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	s := &Server{rootDir: root}
+	info, err := os.Stat(root)
+	if err != nil {
+		t.Fatalf("failed to stat root: %v", err)
+	}
+	target := &requestTarget{mount: &Mount{Root: root}, root: root, fullPath: root}
+
+	for _, format := range []string{archiveFormatZip, archiveFormatTarGz} {
+		t.Run(format+" canceled", func(t *testing.T) {
+			plan, err := s.planArchive(context.Background(), target, "logs", info)
+			if err != nil {
+				t.Fatalf("planArchive() error = %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			var buf bytes.Buffer
+			if err := writeArchive(ctx, &buf, format, plan); !errors.Is(err, context.Canceled) {
+				t.Errorf("writeArchive() error = %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+
+	t.Run("file changed", func(t *testing.T) {
+		plan, err := s.planArchive(context.Background(), target, "logs", info)
+		if err != nil {
+			t.Fatalf("planArchive() error = %v", err)
+		}
+		if err := os.WriteFile(logPath, []byte("line 1\n"), 0644); err != nil {
+			t.Fatalf("failed to truncate file: %v", err)
+		}
+
+		var buf bytes.Buffer
+		if err := writeArchive(context.Background(), &buf, archiveFormatZip, plan); !errors.Is(err, errArchiveFileChanged) {
+			t.Errorf("writeArchive() error = %v, want %v", err, errArchiveFileChanged)
+		}
+		// Without its central directory the zip can't be opened
+		if _, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+			t.Error("zip.NewReader() error = nil, want the incomplete archive to be rejected")
+		}
+	})
+}
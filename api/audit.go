@@ -0,0 +1,482 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	AuditActionLogin     = "login"
+	AuditActionLogout    = "logout"
+	AuditActionAuth      = "auth"
+	AuditActionList      = "list"
+	AuditActionWatch     = "watch"
+	AuditActionDownload  = "download"
+	AuditActionArchive   = "archive"
+	AuditActionPreview   = "preview"
+	AuditActionThumbnail = "thumbnail"
+	AuditActionUpload    = "upload"
+
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+
+	// auditHeadSuffix is appended to the log path to name the head file
+	auditHeadSuffix = ".head"
+
+	// MinAuditKeyLength is the shortest key the audit log accepts
+	MinAuditKeyLength = 32
+	// maxAuditFieldLength caps the user, path and reason of a record, which
+	// come from requests
+	maxAuditFieldLength = 1024
+	// maxAuditRecordSize is the longest line the log may hold, including its
+	// newline. Capped fields keep records well below it even when every
+	// character is escaped.
+	maxAuditRecordSize = 64 << 10
+)
+
+var (
+	ErrAuditLogTampered = errors.New("audit log has been tampered with")
+)
+
+// AuditEvent is a single record in the audit log
+type AuditEvent struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	User      string    `json:"user,omitempty"`
+	SourceIP  string    `json:"sourceIp,omitempty"`
+	SessionID string    `json:"sessionId,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Result    string    `json:"result"`
+	Reason    string    `json:"reason,omitempty"`
+	PrevHash  string    `json:"prevHash"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+// auditHead is the last record written, kept beside the log so that
+// truncation can be detected
+type auditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// auditHeadFile is the head as it's stored. The MAC stops someone who cuts
+// the log short from pointing the head at an earlier record, whose sequence
+// number and hash can be read from the log.
+type auditHeadFile struct {
+	auditHead
+	MAC string `json:"mac"`
+}
+
+// AuditLog is an append-only JSON lines log of security relevant events.
+//
+// Each record carries the hash of the previous record and its own hash over
+// its contents, forming a chain. Hashes are HMAC-SHA256 keyed with a secret
+// that must be kept away from the log, so someone who can edit the log but
+// doesn't have the key can't edit or remove a record and recompute the chain.
+// The sequence number and hash of the last record are also written to a head
+// file beside the log so that removing records from the end is detected as
+// well.
+type AuditLog struct {
+	path string
+	key  []byte
+
+	mu   sync.Mutex
+	file *os.File
+	head auditHead
+	// size is the length of the log up to the end of the last complete record
+	size int64
+	// forwarder receives a copy of each record once it has been written
+	forwarder *AuditForwarder
+}
+
+// OpenAuditLog opens the audit log at path, creating it if needed, and
+// continues its hash chain with key
+func OpenAuditLog(path string, key []byte) (*AuditLog, error) {
+	if len(key) < MinAuditKeyLength {
+		return nil, fmt.Errorf("audit log key must be at least %d bytes", MinAuditKeyLength)
+	}
+
+	last, err := readAuditTail(path)
+	if err != nil {
+		return nil, err
+	}
+	head := auditHead{Seq: last.Seq, Hash: last.Hash}
+
+	saved, err := readAuditHead(path+auditHeadSuffix, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// The head file may lag by one record if the server stopped between
+	// writing a record and updating the head
+	switch {
+	case saved == nil:
+		if last.Seq > 1 {
+			return nil, fmt.Errorf("%w: head file is missing", ErrAuditLogTampered)
+		}
+	case *saved == head:
+	case last.Seq == saved.Seq+1 && last.PrevHash == saved.Hash:
+	default:
+		return nil, fmt.Errorf("%w: last record %d does not match head record %d", ErrAuditLogTampered, last.Seq, saved.Seq)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat audit log: %w", err)
+	}
+
+	return &AuditLog{
+		path: path,
+		key:  key,
+		file: f,
+		head: head,
+		size: info.Size(),
+	}, nil
+}
+
+// Record appends an event to the log. The sequence number and hashes are
+// filled in by the log. A nil AuditLog discards events.
+func (a *AuditLog) Record(event AuditEvent) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC()
+	event.Seq = a.head.Seq + 1
+	event.PrevHash = a.head.Hash
+	// A record too long to read back would stop the log from being opened
+	event.User = truncateAuditField(event.User)
+	event.Path = truncateAuditField(event.Path)
+	event.Reason = truncateAuditField(event.Reason)
+
+	hash, err := hashAuditEvent(a.key, event)
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	if len(line) >= maxAuditRecordSize {
+		return fmt.Errorf("audit event is longer than %d bytes", maxAuditRecordSize)
+	}
+
+	n, err := a.file.Write(append(line, '\n'))
+	a.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	a.head = auditHead{Seq: event.Seq, Hash: event.Hash}
+	a.forwarder.Enqueue(event)
+
+	return writeAuditHead(a.path+auditHeadSuffix, a.key, a.head)
+}
+
+// SetForwarder sends a copy of every record written from now on to f, which
+// also reads records it missed from the log
+func (a *AuditLog) SetForwarder(f *AuditForwarder) {
+	a.mu.Lock()
+	a.forwarder = f
+	f.attach(a, a.head.Seq)
+	a.mu.Unlock()
+}
+
+// Close closes the log
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// VerifyAuditLog checks the hash chain of the audit log at path against its
+// head file using key, and returns the number of records verified
+func VerifyAuditLog(path string, key []byte) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var last auditHead
+	count := 0
+
+	scanner := newAuditScanner(f)
+	for scanner.Scan() {
+		event, err := parseAuditEvent(scanner.Bytes())
+		if err != nil {
+			return count, fmt.Errorf("%w: record %d: %v", ErrAuditLogTampered, count+1, err)
+		}
+
+		if event.Seq != last.Seq+1 {
+			return count, fmt.Errorf("%w: record %d has sequence number %d", ErrAuditLogTampered, count+1, event.Seq)
+		}
+		if event.PrevHash != last.Hash {
+			return count, fmt.Errorf("%w: record %d does not link to the previous record", ErrAuditLogTampered, event.Seq)
+		}
+
+		hash, err := hashAuditEvent(key, event)
+		if err != nil {
+			return count, err
+		}
+		if !hmac.Equal([]byte(hash), []byte(event.Hash)) {
+			return count, fmt.Errorf("%w: record %d has been modified", ErrAuditLogTampered, event.Seq)
+		}
+
+		last = auditHead{Seq: event.Seq, Hash: event.Hash}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	head, err := readAuditHead(path+auditHeadSuffix, key)
+	if err != nil {
+		return count, err
+	}
+	if head == nil {
+		return count, fmt.Errorf("%w: head file is missing", ErrAuditLogTampered)
+	}
+	if last.Seq < head.Seq {
+		return count, fmt.Errorf("%w: log ends at record %d but head is record %d", ErrAuditLogTampered, last.Seq, head.Seq)
+	}
+	if last.Seq > head.Seq+1 {
+		return count, fmt.Errorf("%w: log has records after head record %d", ErrAuditLogTampered, head.Seq)
+	}
+	if last.Seq == head.Seq && last.Hash != head.Hash {
+		return count, fmt.Errorf("%w: last record does not match head", ErrAuditLogTampered)
+	}
+
+	return count, nil
+}
+
+// hashAuditEvent returns the HMAC of an event's contents with key, excluding
+// its own hash
+func hashAuditEvent(key []byte, event AuditEvent) (string, error) {
+	event.Hash = ""
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// macAuditHead returns the HMAC of a head with key
+func macAuditHead(key []byte, head auditHead) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "head\x00%d\x00%s", head.Seq, head.Hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// truncateAuditField shortens s to maxAuditFieldLength bytes without
+// splitting a character
+func truncateAuditField(s string) string {
+	if len(s) <= maxAuditFieldLength {
+		return s
+	}
+	end := maxAuditFieldLength
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
+
+// newAuditScanner returns a scanner over the lines of an audit log that
+// accepts records up to maxAuditRecordSize
+func newAuditScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxAuditRecordSize)
+	return scanner
+}
+
+// parseAuditEvent decodes a single log line, rejecting unknown fields so
+// that added data can't hide from the hash
+func parseAuditEvent(line []byte) (AuditEvent, error) {
+	var event AuditEvent
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&event); err != nil {
+		return AuditEvent{}, err
+	}
+	return event, nil
+}
+
+// readAuditTail returns the last record in the log at path
+func readAuditTail(path string) (AuditEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return AuditEvent{}, nil
+		}
+		return AuditEvent{}, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var last AuditEvent
+	scanner := newAuditScanner(f)
+	for scanner.Scan() {
+		event, err := parseAuditEvent(scanner.Bytes())
+		if err != nil {
+			return AuditEvent{}, fmt.Errorf("%w: %v", ErrAuditLogTampered, err)
+		}
+		last = event
+	}
+	if err := scanner.Err(); err != nil {
+		return AuditEvent{}, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	return last, nil
+}
+
+// readAuditHead reads a head file and checks its MAC with key, returning nil
+// if it doesn't exist
+func readAuditHead(path string, key []byte) (*auditHead, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read audit head: %w", err)
+	}
+
+	var saved auditHeadFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%w: invalid head file: %v", ErrAuditLogTampered, err)
+	}
+	if !hmac.Equal([]byte(saved.MAC), []byte(macAuditHead(key, saved.auditHead))) {
+		return nil, fmt.Errorf("%w: head file has been modified", ErrAuditLogTampered)
+	}
+	return &saved.auditHead, nil
+}
+
+// writeAuditHead atomically replaces the head file, adding its MAC with key
+func writeAuditHead(path string, key []byte, head auditHead) error {
+	data, err := json.Marshal(auditHeadFile{auditHead: head, MAC: macAuditHead(key, head)})
+	if err != nil {
+		return fmt.Errorf("failed to encode audit head: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write audit head: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write audit head: %w", err)
+	}
+	return nil
+}
+
+// sessionID derives a stable identifier for a session token that can be
+// logged without exposing the token itself
+func sessionID(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// sourceIP returns the IP address of the client that sent the request
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditRequest records an event for a request
+func (s *Server) auditRequest(r *http.Request, event AuditEvent) {
+	if s.audit == nil {
+		return
+	}
+
+	event.SourceIP = sourceIP(r)
+	if event.SessionID == "" {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			event.SessionID = sessionID(cookie.Value)
+		}
+	}
+	if event.User == "" {
+		event.User = userFromContext(r.Context())
+	}
+
+	if err := s.audit.Record(event); err != nil {
+		requestLogger(r).Error("failed to record audit event", "action", event.Action, "error", err)
+	}
+}
+
+// audited records an event with the outcome of each request handled by next.
+// The event path is the request path with prefix removed.
+func (s *Server) audited(action, prefix string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.audit == nil {
+			next(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		event := AuditEvent{
+			Action: action,
+			Path:   strings.TrimPrefix(r.URL.Path, prefix),
+			Result: AuditResultSuccess,
+		}
+		if rec.status >= http.StatusBadRequest {
+			event.Result = AuditResultFailure
+			event.Reason = http.StatusText(rec.status)
+		}
+		s.auditRequest(r, event)
+	}
+}
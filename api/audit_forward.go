@@ -0,0 +1,587 @@
+package api
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuditFormatSyslog = "syslog"
+	AuditFormatCEF    = "cef"
+	AuditFormatJSON   = "json"
+
+	defaultAuditSpoolSize     = 64 * 1024 * 1024 // 64 MB
+	defaultAuditRetryInterval = time.Second
+	maxAuditRetryInterval     = time.Minute
+	auditForwardTimeout       = 10 * time.Second
+
+	// auditOffsetSuffix is appended to the spool path to name the file
+	// recording how much of the spool has been delivered
+	auditOffsetSuffix = ".offset"
+	// auditSeqSuffix is appended to the spool path to name the file recording
+	// the sequence number of the last event delivered
+	auditSeqSuffix = ".seq"
+	// auditRefillBatch is how many events are read from the audit log at a
+	// time when refilling the spool
+	auditRefillBatch = 1000
+
+	// syslog facility authpriv and severities
+	syslogFacilityAuthPriv = 10
+	syslogSeverityWarning  = 4
+	syslogSeverityNotice   = 5
+	syslogAppName          = "fs4"
+	// syslogEnterpriseID is the IANA reserved example number for SD-IDs
+	syslogEnterpriseID = "32473"
+)
+
+// AuditForwarderConfig configures an AuditForwarder
+type AuditForwarderConfig struct {
+	// Addr is the host:port of the collector
+	Addr string
+	// Format is one of AuditFormatSyslog, AuditFormatCEF or AuditFormatJSON
+	Format string
+	// TLSConfig is used to connect to the collector. It must not be nil.
+	TLSConfig *tls.Config
+	// SpoolPath is the file that buffers events until they're delivered
+	SpoolPath string
+	// MaxSpoolSize bounds the spool file. Events that don't fit are read
+	// from the audit log once there's room, or dropped if the forwarder
+	// isn't attached to one.
+	MaxSpoolSize int64
+	// RetryInterval is the first delay before reconnecting after a failure.
+	// It doubles on each consecutive failure up to a minute.
+	RetryInterval time.Duration
+}
+
+// AuditForwarder ships audit events to a remote collector over TLS.
+//
+// Events are appended to an on-disk spool and delivered by a background
+// goroutine, so a slow or unavailable collector never blocks the request that
+// produced the event. Delivery resumes from the spool after a restart. When
+// the spool fills up, or events were recorded while the forwarder wasn't
+// running, the missing events are copied from the audit log once everything
+// spooled has been delivered.
+type AuditForwarder struct {
+	cfg      AuditForwarderConfig
+	hostname string
+
+	mu      sync.Mutex
+	spool   *os.File
+	size    int64
+	offset  int64
+	dropped uint64
+
+	// source is the audit log events are refilled from
+	source *AuditLog
+	// deliveredSeq is the last event delivered, or before forwarding began
+	deliveredSeq uint64
+	// spooledSeq is the last event written to the spool
+	spooledSeq uint64
+	// latestSeq is the last event recorded in the audit log
+	latestSeq uint64
+	// behind is set while the audit log has events after spooledSeq that
+	// aren't in the spool. New events are left for refill until it's
+	// caught up.
+	behind bool
+	// resumed is set if an earlier run recorded deliveredSeq
+	resumed bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewAuditForwarder opens the spool and starts delivering events
+func NewAuditForwarder(cfg AuditForwarderConfig) (*AuditForwarder, error) {
+	switch cfg.Format {
+	case AuditFormatSyslog, AuditFormatCEF, AuditFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown audit forwarding format %q", cfg.Format)
+	}
+	if cfg.TLSConfig == nil {
+		return nil, fmt.Errorf("audit forwarding requires a TLS config")
+	}
+	if cfg.SpoolPath == "" {
+		return nil, fmt.Errorf("audit forwarding requires a spool path")
+	}
+	if cfg.MaxSpoolSize <= 0 {
+		cfg.MaxSpoolSize = defaultAuditSpoolSize
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaultAuditRetryInterval
+	}
+
+	spool, err := os.OpenFile(cfg.SpoolPath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit spool: %w", err)
+	}
+
+	info, err := spool.Stat()
+	if err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("failed to stat audit spool: %w", err)
+	}
+
+	offset, _, err := readAuditCounter(cfg.SpoolPath+auditOffsetSuffix, "spool offset")
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+	if offset > info.Size() {
+		offset = 0
+	}
+	deliveredSeq, hasDeliveredSeq, err := readAuditCounter(cfg.SpoolPath+auditSeqSuffix, "delivered sequence number")
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	f := &AuditForwarder{
+		cfg:          cfg,
+		hostname:     hostname,
+		spool:        spool,
+		size:         info.Size(),
+		offset:       offset,
+		deliveredSeq: uint64(deliveredSeq),
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	f.resumed = hasDeliveredSeq
+	go f.run()
+
+	return f, nil
+}
+
+// attach makes the forwarder refill the spool from source, whose last record
+// is head. The caller must hold source's mutex.
+func (f *AuditForwarder) attach(source *AuditLog, head uint64) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	f.source = source
+	f.latestSeq = head
+	if f.resumed {
+		// Catch up on events recorded while the forwarder wasn't running
+		f.behind = true
+	} else {
+		// Nothing was forwarded before, so the log's history isn't sent
+		f.deliveredSeq = head
+		f.spooledSeq = head
+		if err := writeAuditCounter(f.cfg.SpoolPath+auditSeqSuffix, "delivered sequence number", int64(head)); err != nil {
+			slog.Error("failed to record audit delivery progress", "error", err)
+		}
+	}
+	f.mu.Unlock()
+
+	f.notify()
+}
+
+// Enqueue adds an event to the spool. It never waits on the collector.
+func (f *AuditForwarder) Enqueue(event AuditEvent) {
+	if f == nil {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("failed to encode audit event for forwarding", "seq", event.Seq, "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	f.latestSeq = max(f.latestSeq, event.Seq)
+	if f.source != nil && (f.behind || event.Seq <= f.spooledSeq) {
+		// refill copies it from the audit log, or already has
+		f.mu.Unlock()
+		f.notify()
+		return
+	}
+	if f.size+int64(len(line)) > f.cfg.MaxSpoolSize {
+		if f.source != nil {
+			f.behind = true
+			f.mu.Unlock()
+			slog.Warn("audit spool is full, events will be read from the audit log once there's room", "seq", event.Seq)
+			return
+		}
+		f.dropped++
+		dropped := f.dropped
+		f.mu.Unlock()
+		slog.Error("audit spool is full, dropped event", "seq", event.Seq, "dropped_total", dropped)
+		return
+	}
+	n, err := f.spool.Write(line)
+	f.size += int64(n)
+	if err == nil {
+		f.spooledSeq = event.Seq
+	}
+	f.mu.Unlock()
+
+	if err != nil {
+		slog.Error("failed to spool audit event", "seq", event.Seq, "error", err)
+		return
+	}
+
+	f.notify()
+}
+
+// notify wakes the delivery goroutine
+func (f *AuditForwarder) notify() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops delivery. Undelivered events stay in the spool.
+func (f *AuditForwarder) Close() error {
+	if f == nil {
+		return nil
+	}
+
+	close(f.stop)
+	<-f.done
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.spool.Close()
+}
+
+// run delivers spooled events until Close is called
+func (f *AuditForwarder) run() {
+	defer close(f.done)
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	retry := f.cfg.RetryInterval
+	for {
+		var err error
+		conn, err = f.deliver(conn)
+		if err != nil {
+			slog.Warn("failed to forward audit events, retrying", "addr", f.cfg.Addr, "retry_in", retry, "error", err)
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+
+			select {
+			case <-f.stop:
+				return
+			case <-time.After(retry):
+			}
+			retry = min(retry*2, maxAuditRetryInterval)
+			continue
+		}
+		retry = f.cfg.RetryInterval
+
+		refilled, err := f.refill()
+		if err != nil {
+			slog.Error("failed to refill audit spool from the audit log", "error", err)
+		}
+		if refilled {
+			continue
+		}
+
+		select {
+		case <-f.stop:
+			// Deliver anything recorded since the last pass before exiting,
+			// the rest stays in the spool for the next start
+			if conn, err = f.deliver(conn); err != nil {
+				slog.Warn("failed to forward audit events before closing", "addr", f.cfg.Addr, "error", err)
+			}
+			return
+		case <-f.wake:
+		}
+	}
+}
+
+// deliver sends everything in the spool, connecting first if needed, and
+// returns the connection for reuse
+func (f *AuditForwarder) deliver(conn net.Conn) (net.Conn, error) {
+	f.mu.Lock()
+	offset, size := f.offset, f.size
+	f.mu.Unlock()
+
+	if offset == size {
+		return conn, nil
+	}
+
+	if conn == nil {
+		dialer := &net.Dialer{Timeout: auditForwardTimeout}
+		var err error
+		conn, err = tls.DialWithDialer(dialer, "tcp", f.cfg.Addr, f.cfg.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var seq uint64
+	reader := bufio.NewReader(io.NewSectionReader(f.spool, offset, size-offset))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return conn, fmt.Errorf("failed to read audit spool: %w", err)
+		}
+
+		var event AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			// A corrupt line can never be delivered, skip past it
+			slog.Error("skipping unreadable audit spool entry", "error", err)
+		} else {
+			conn.SetWriteDeadline(time.Now().Add(auditForwardTimeout))
+			if _, err := conn.Write(f.format(&event)); err != nil {
+				// Keep what was delivered, the rest is retried
+				if advanceErr := f.advance(offset, seq); advanceErr != nil {
+					slog.Error("failed to record audit delivery progress", "error", advanceErr)
+				}
+				return conn, err
+			}
+			seq = event.Seq
+		}
+
+		offset += int64(len(line))
+	}
+
+	return conn, f.advance(offset, seq)
+}
+
+// advance records that the spool has been delivered up to offset, ending with
+// the event seq, and empties the spool once everything in it has been
+// delivered
+func (f *AuditForwarder) advance(offset int64, seq uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if offset == f.size {
+		if err := f.spool.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate audit spool: %w", err)
+		}
+		f.size = 0
+		offset = 0
+	}
+	f.offset = offset
+
+	if err := writeAuditCounter(f.cfg.SpoolPath+auditOffsetSuffix, "spool offset", offset); err != nil {
+		return err
+	}
+	if seq <= f.deliveredSeq {
+		return nil
+	}
+	f.deliveredSeq = seq
+	return writeAuditCounter(f.cfg.SpoolPath+auditSeqSuffix, "delivered sequence number", int64(seq))
+}
+
+// refill copies events the spool had no room for from the audit log once the
+// spool has been delivered, and reports whether it spooled any
+func (f *AuditForwarder) refill() (bool, error) {
+	f.mu.Lock()
+	source, after := f.source, f.deliveredSeq
+	ready := source != nil && f.behind && f.size == 0
+	f.mu.Unlock()
+	if !ready {
+		return false, nil
+	}
+
+	// The log can't be read while holding f.mu, which Record waits for
+	events, more, err := source.Query(AuditQuery{After: after, Limit: auditRefillBatch})
+	if err != nil {
+		return false, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.spooledSeq = after
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return false, fmt.Errorf("failed to encode audit event: %w", err)
+		}
+		line = append(line, '\n')
+		if f.size+int64(len(line)) > f.cfg.MaxSpoolSize {
+			// The rest is read once this has been delivered
+			return true, nil
+		}
+		n, err := f.spool.Write(line)
+		f.size += int64(n)
+		if err != nil {
+			return f.size > 0, fmt.Errorf("failed to spool audit event: %w", err)
+		}
+		f.spooledSeq = event.Seq
+	}
+
+	// Events recorded after the log was read are still missing
+	if !more && f.spooledSeq >= f.latestSeq {
+		f.behind = false
+	}
+	return len(events) > 0, nil
+}
+
+// format renders an event in the configured wire format
+func (f *AuditForwarder) format(event *AuditEvent) []byte {
+	switch f.cfg.Format {
+	case AuditFormatSyslog:
+		msg := formatSyslog(event, f.hostname)
+		// RFC 5425 octet counting framing
+		return []byte(strconv.Itoa(len(msg)) + " " + msg)
+	case AuditFormatCEF:
+		return []byte(formatCEF(event) + "\n")
+	default:
+		line, _ := json.Marshal(event)
+		return append(line, '\n')
+	}
+}
+
+// formatSyslog renders an event as an RFC 5424 message
+func formatSyslog(event *AuditEvent, hostname string) string {
+	severity := syslogSeverityNotice
+	if event.Result != AuditResultSuccess {
+		severity = syslogSeverityWarning
+	}
+
+	var sd strings.Builder
+	sd.WriteString("[audit@" + syslogEnterpriseID)
+	params := []struct{ name, value string }{
+		{"seq", strconv.FormatUint(event.Seq, 10)},
+		{"user", event.User},
+		{"src", event.SourceIP},
+		{"session", event.SessionID},
+		{"path", event.Path},
+		{"result", event.Result},
+		{"reason", event.Reason},
+		{"hash", event.Hash},
+	}
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		sd.WriteString(" " + p.name + `="` + escapeSDParam(p.value) + `"`)
+	}
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s %s",
+		syslogFacilityAuthPriv*8+severity,
+		event.Time.UTC().Format(time.RFC3339Nano),
+		hostname,
+		syslogAppName,
+		os.Getpid(),
+		event.Action,
+		sd.String(),
+		event.Action,
+		event.Result,
+	)
+}
+
+// escapeSDParam escapes a structured data parameter value per RFC 5424
+func escapeSDParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// formatCEF renders an event in ArcSight Common Event Format
+func formatCEF(event *AuditEvent) string {
+	severity := 3
+	if event.Result != AuditResultSuccess {
+		severity = 7
+	}
+
+	header := []string{
+		"CEF:0",
+		"goteleport-interview",
+		syslogAppName,
+		"1",
+		escapeCEFHeader(event.Action),
+		escapeCEFHeader(event.Action + " " + event.Result),
+		strconv.Itoa(severity),
+	}
+
+	extensions := []struct{ key, value string }{
+		{"rt", strconv.FormatInt(event.Time.UnixMilli(), 10)},
+		{"act", event.Action},
+		{"outcome", event.Result},
+		{"suser", event.User},
+		{"src", event.SourceIP},
+		{"filePath", event.Path},
+		{"reason", event.Reason},
+		{"cs1Label", "sessionId"},
+		{"cs1", event.SessionID},
+		{"cn1Label", "seq"},
+		{"cn1", strconv.FormatUint(event.Seq, 10)},
+	}
+
+	var ext []string
+	for _, e := range extensions {
+		if e.value == "" {
+			continue
+		}
+		ext = append(ext, e.key+"="+escapeCEFExtension(e.value))
+	}
+
+	return strings.Join(header, "|") + "|" + strings.Join(ext, " ")
+}
+
+// escapeCEFHeader escapes a CEF header field
+func escapeCEFHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(value)
+}
+
+// escapeCEFExtension escapes a CEF extension value
+func escapeCEFExtension(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
+
+// readAuditCounter reads the number called name from the file at path. It
+// returns false if the file doesn't exist.
+func readAuditCounter(path, name string) (int64, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to read audit %s: %w", name, err)
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || n < 0 {
+		return 0, false, fmt.Errorf("invalid audit %s in %s", name, path)
+	}
+	return n, true, nil
+}
+
+// writeAuditCounter atomically replaces the file at path with the number n
+// called name
+func writeAuditCounter(path, name string, n int64) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strconv.FormatInt(n, 10)), 0600); err != nil {
+		return fmt.Errorf("failed to write audit %s: %w", name, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write audit %s: %w", name, err)
+	}
+	return nil
+}
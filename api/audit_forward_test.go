@@ -0,0 +1,355 @@
+package api
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCertificate creates a self-signed certificate for localhost
+func newTestCertificate(t *testing.T, notAfter time.Time) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// syslogListener is a local TLS collector that reads octet counted frames
+type syslogListener struct {
+	listener net.Listener
+	messages chan string
+}
+
+func newSyslogListener(t *testing.T, addr string, cert tls.Certificate) *syslogListener {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sl := &syslogListener{listener: listener, messages: make(chan string, 100)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sl.read(conn)
+		}
+	}()
+
+	return sl
+}
+
+func (sl *syslogListener) read(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			return
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(reader, msg); err != nil {
+			return
+		}
+		sl.messages <- string(msg)
+	}
+}
+
+func (sl *syslogListener) next(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case msg := <-sl.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for syslog message")
+		return ""
+	}
+}
+
+func TestAuditForwarderSyslog(t *testing.T) {
+	cert, pool := newTestCertificate(t, time.Now().Add(time.Hour))
+	collector := newSyslogListener(t, "127.0.0.1:0", cert)
+
+	forwarder, err := NewAuditForwarder(AuditForwarderConfig{
+		Addr:      collector.listener.Addr().String(),
+		Format:    AuditFormatSyslog,
+		TLSConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"},
+		SpoolPath: filepath.Join(t.TempDir(), "spool"),
+	})
+	if err != nil {
+		t.Fatalf("NewAuditForwarder() error = %v", err)
+	}
+	defer forwarder.Close()
+
+	forwarder.Enqueue(AuditEvent{Seq: 1, Time: time.Now(), Action: AuditActionLogin, User: "alice", Result: AuditResultSuccess})
+	forwarder.Enqueue(AuditEvent{Seq: 2, Time: time.Now(), Action: AuditActionList, User: "bob", Path: `/a"b]`, Result: AuditResultFailure})
+
+	msg := collector.next(t)
+	if !strings.HasPrefix(msg, "<85>1 ") {
+		t.Errorf("first message = %q, want authpriv.notice RFC 5424 header", msg)
+	}
+	if !strings.Contains(msg, ` login [audit@32473 seq="1" user="alice"`) {
+		t.Errorf("first message = %q, want login structured data", msg)
+	}
+
+	msg = collector.next(t)
+	if !strings.HasPrefix(msg, "<84>1 ") {
+		t.Errorf("second message = %q, want authpriv.warning RFC 5424 header", msg)
+	}
+	if !strings.Contains(msg, `path="/a\"b\]"`) {
+		t.Errorf("second message = %q, want escaped path", msg)
+	}
+}
+
+func TestAuditForwarderOutage(t *testing.T) {
+	cert, pool := newTestCertificate(t, time.Now().Add(time.Hour))
+
+	// Reserve an address with nothing listening on it
+	reserved, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := reserved.Addr().String()
+	reserved.Close()
+
+	spoolPath := filepath.Join(t.TempDir(), "spool")
+	cfg := AuditForwarderConfig{
+		Addr:          addr,
+		Format:        AuditFormatSyslog,
+		TLSConfig:     &tls.Config{RootCAs: pool, ServerName: "localhost"},
+		SpoolPath:     spoolPath,
+		RetryInterval: 10 * time.Millisecond,
+	}
+
+	forwarder, err := NewAuditForwarder(cfg)
+	if err != nil {
+		t.Fatalf("NewAuditForwarder() error = %v", err)
+	}
+
+	start := time.Now()
+	for i := 1; i <= 3; i++ {
+		forwarder.Enqueue(AuditEvent{Seq: uint64(i), Time: time.Now(), Action: AuditActionLogin, Result: AuditResultSuccess})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Enqueue() blocked for %v while the collector was down", elapsed)
+	}
+
+	// Events survive a restart while the collector is still down
+	if err := forwarder.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	forwarder, err = NewAuditForwarder(cfg)
+	if err != nil {
+		t.Fatalf("NewAuditForwarder() error = %v", err)
+	}
+	defer forwarder.Close()
+
+	collector := newSyslogListener(t, addr, cert)
+	for i := 1; i <= 3; i++ {
+		msg := collector.next(t)
+		if !strings.Contains(msg, `seq="`+strconv.Itoa(i)+`"`) {
+			t.Errorf("message %d = %q, want seq %d", i, msg, i)
+		}
+	}
+}
+
+func TestAuditForwarderSpoolLimit(t *testing.T) {
+	_, pool := newTestCertificate(t, time.Now().Add(time.Hour))
+
+	forwarder, err := NewAuditForwarder(AuditForwarderConfig{
+		Addr:          "127.0.0.1:1",
+		Format:        AuditFormatJSON,
+		TLSConfig:     &tls.Config{RootCAs: pool},
+		SpoolPath:     filepath.Join(t.TempDir(), "spool"),
+		MaxSpoolSize:  300,
+		RetryInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewAuditForwarder() error = %v", err)
+	}
+	defer forwarder.Close()
+
+	for i := 1; i <= 10; i++ {
+		forwarder.Enqueue(AuditEvent{Seq: uint64(i), Action: AuditActionLogin, Result: AuditResultSuccess})
+	}
+
+	forwarder.mu.Lock()
+	defer forwarder.mu.Unlock()
+	if forwarder.size > 300 {
+		t.Errorf("spool size = %v, want at most 300", forwarder.size)
+	}
+	if forwarder.dropped == 0 {
+		t.Error("no events were dropped from a full spool")
+	}
+}
+
+func TestFormatCEF(t *testing.T) {
+	event := &AuditEvent{
+		Seq:       7,
+		Time:      time.UnixMilli(1700000000000),
+		Action:    AuditActionList,
+		User:      "alice",
+		SourceIP:  "10.0.0.1",
+		SessionID: "abc",
+		Path:      `/a=b\c`,
+		Result:    AuditResultFailure,
+	}
+
+	got := formatCEF(event)
+	want := `CEF:0|goteleport-interview|fs4|1|list|list failure|7|rt=1700000000000 act=list outcome=failure suser=alice src=10.0.0.1 filePath=/a\=b\\c cs1Label=sessionId cs1=abc cn1Label=seq cn1=7`
+	if got != want {
+		t.Errorf("formatCEF() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNewAuditForwarderErrors(t *testing.T) {
+	spoolPath := filepath.Join(t.TempDir(), "spool")
+
+	tests := []struct {
+		name string
+		cfg  AuditForwarderConfig
+	}{
+		{
+			name: "unknown format",
+			cfg:  AuditForwarderConfig{Format: "xml", TLSConfig: &tls.Config{}, SpoolPath: spoolPath},
+		},
+		{
+			name: "missing TLS config",
+			cfg:  AuditForwarderConfig{Format: AuditFormatJSON, SpoolPath: spoolPath},
+		},
+		{
+			name: "missing spool",
+			cfg:  AuditForwarderConfig{Format: AuditFormatJSON, TLSConfig: &tls.Config{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAuditForwarder(tt.cfg); err == nil {
+				t.Error("NewAuditForwarder() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestAuditForwarderRefill(t *testing.T) {
+	cert, pool := newTestCertificate(t, time.Now().Add(time.Hour))
+	audit, _ := openTestAuditLog(t)
+
+	// Reserve an address with nothing listening on it
+	reserved, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := reserved.Addr().String()
+	reserved.Close()
+
+	// The spool only has room for a few events
+	cfg := AuditForwarderConfig{
+		Addr:          addr,
+		Format:        AuditFormatSyslog,
+		TLSConfig:     &tls.Config{RootCAs: pool, ServerName: "localhost"},
+		SpoolPath:     filepath.Join(t.TempDir(), "spool"),
+		MaxSpoolSize:  1000,
+		RetryInterval: 10 * time.Millisecond,
+	}
+	forwarder, err := NewAuditForwarder(cfg)
+	if err != nil {
+		t.Fatalf("NewAuditForwarder() error = %v", err)
+	}
+	audit.SetForwarder(forwarder)
+
+	record := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if err := audit.Record(AuditEvent{Action: AuditActionLogin, User: "alice", Result: AuditResultSuccess}); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+		}
+	}
+
+	record(10)
+	forwarder.mu.Lock()
+	behind := forwarder.behind
+	forwarder.mu.Unlock()
+	if !behind {
+		t.Fatal("forwarder isn't behind after the spool filled up")
+	}
+
+	// Events recorded while the forwarder is stopped are caught up on too
+	if err := forwarder.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	audit.SetForwarder(nil)
+	record(2)
+	forwarder, err = NewAuditForwarder(cfg)
+	if err != nil {
+		t.Fatalf("NewAuditForwarder() error = %v", err)
+	}
+	defer forwarder.Close()
+	audit.SetForwarder(forwarder)
+	record(1)
+
+	collector := newSyslogListener(t, addr, cert)
+	for i := 1; i <= 13; i++ {
+		msg := collector.next(t)
+		if !strings.Contains(msg, `seq="`+strconv.Itoa(i)+`"`) {
+			t.Fatalf("message %d = %q, want seq %d", i, msg, i)
+		}
+	}
+	select {
+	case msg := <-collector.messages:
+		t.Errorf("unexpected message %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
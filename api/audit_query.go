@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 1000
+)
+
+// AuditQuery selects events from the audit log. Zero values match everything.
+type AuditQuery struct {
+	User       string
+	Action     string
+	PathPrefix string
+	Result     string
+	Since      time.Time
+	Until      time.Time
+	// After skips events up to and including this sequence number
+	After uint64
+	Limit int
+}
+
+// matches reports whether event is selected by the query
+func (q *AuditQuery) matches(event *AuditEvent) bool {
+	switch {
+	case event.Seq <= q.After:
+		return false
+	case q.User != "" && event.User != q.User:
+		return false
+	case q.Action != "" && event.Action != q.Action:
+		return false
+	case q.PathPrefix != "" && !strings.HasPrefix(event.Path, q.PathPrefix):
+		return false
+	case q.Result != "" && event.Result != q.Result:
+		return false
+	case !q.Since.IsZero() && event.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !event.Time.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// Query returns up to q.Limit events matching q in log order and whether more
+// matching events follow
+func (a *AuditLog) Query(q AuditQuery) ([]AuditEvent, bool, error) {
+	// Only read complete records, a write may be in progress past this point
+	a.mu.Lock()
+	size := a.size
+	a.mu.Unlock()
+
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	events := make([]AuditEvent, 0)
+	scanner := newAuditScanner(io.LimitReader(f, size))
+	for scanner.Scan() {
+		event, err := parseAuditEvent(scanner.Bytes())
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to parse audit log: %w", err)
+		}
+		if !q.matches(&event) {
+			continue
+		}
+		if len(events) == q.Limit {
+			return events, true, nil
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	return events, false, nil
+}
+
+// AuditQueryResponse is a page of audit events
+type AuditQueryResponse struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// parseAuditQuery reads an AuditQuery from request query parameters
+func parseAuditQuery(r *http.Request) (AuditQuery, error) {
+	params := r.URL.Query()
+	q := AuditQuery{
+		User:       params.Get("user"),
+		Action:     params.Get("action"),
+		PathPrefix: params.Get("path"),
+		Result:     params.Get("result"),
+		Limit:      defaultAuditQueryLimit,
+	}
+
+	if since := params.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return AuditQuery{}, invalidParameter("since", "since must be an RFC 3339 time")
+		}
+		q.Since = t
+	}
+
+	if until := params.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return AuditQuery{}, invalidParameter("until", "until must be an RFC 3339 time")
+		}
+		q.Until = t
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxAuditQueryLimit {
+			return AuditQuery{}, invalidParameter("limit", "limit must be between 1 and %d", maxAuditQueryLimit)
+		}
+		q.Limit = n
+	}
+
+	// Cursors are the sequence number of the last event on the previous page
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return AuditQuery{}, invalidParameter("cursor", "invalid cursor")
+		}
+		q.After = after
+	}
+
+	return q, nil
+}
+
+// getAudit handles GET requests to /api/audit
+func (s *Server) getAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	if s.audit == nil {
+		writeError(w, r, ErrorCodeNotFound, "Audit log is not enabled", http.StatusNotFound)
+		return
+	}
+
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		writeRequestError(w, r, err, ErrorCodeInvalidParameter, http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeErrorDetails(w, r, ErrorCodeInvalidParameter, "format must be json or csv", http.StatusBadRequest,
+			map[string]any{"parameter": "format"})
+		return
+	}
+
+	events, more, err := s.audit.Query(q)
+	if err != nil {
+		internalError(w, r, "failed to query audit log", err)
+		return
+	}
+
+	var nextCursor string
+	if more {
+		nextCursor = strconv.FormatUint(events[len(events)-1].Seq, 10)
+		w.Header().Set("X-Next-Cursor", nextCursor)
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		if err := writeAuditCSV(w, events); err != nil {
+			requestLogger(r).Error("failed to write response", "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(AuditQueryResponse{Events: events, NextCursor: nextCursor}); err != nil {
+		requestLogger(r).Error("failed to write response", "error", err)
+		return
+	}
+}
+
+// writeAuditCSV writes events as CSV with a header row
+func writeAuditCSV(w io.Writer, events []AuditEvent) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"seq", "time", "action", "user", "sourceIp", "sessionId", "path", "result", "reason", "prevHash", "hash"}); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		record := []string{
+			strconv.FormatUint(event.Seq, 10),
+			event.Time.Format(time.RFC3339Nano),
+			event.Action,
+			csvSafe(event.User),
+			event.SourceIP,
+			event.SessionID,
+			csvSafe(event.Path),
+			event.Result,
+			csvSafe(event.Reason),
+			event.PrevHash,
+			event.Hash,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvSafe stops client supplied values such as login names from being
+// interpreted as formulas when the export is opened in a spreadsheet
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLogQuery(t *testing.T) {
+	audit, _ := openTestAuditLog(t)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []AuditEvent{
+		{Action: AuditActionLogin, User: "alice", Result: AuditResultSuccess},
+		{Action: AuditActionList, User: "alice", Path: "/logs/app", Result: AuditResultSuccess},
+		{Action: AuditActionList, User: "bob", Path: "/logs", Result: AuditResultFailure},
+		{Action: AuditActionLogin, User: "bob", Result: AuditResultFailure},
+		{Action: AuditActionList, User: "alice", Path: "/builds", Result: AuditResultSuccess},
+	}
+	for i, event := range records {
+		event.Time = start.Add(time.Duration(i) * time.Hour)
+		if err := audit.Record(event); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    AuditQuery
+		wantSeqs []uint64
+		wantMore bool
+	}{
+		{
+			name:     "all events",
+			query:    AuditQuery{Limit: 10},
+			wantSeqs: []uint64{1, 2, 3, 4, 5},
+		},
+		{
+			name:     "by user",
+			query:    AuditQuery{User: "bob", Limit: 10},
+			wantSeqs: []uint64{3, 4},
+		},
+		{
+			name:     "by action and result",
+			query:    AuditQuery{Action: AuditActionList, Result: AuditResultSuccess, Limit: 10},
+			wantSeqs: []uint64{2, 5},
+		},
+		{
+			name:     "by path prefix",
+			query:    AuditQuery{PathPrefix: "/logs", Limit: 10},
+			wantSeqs: []uint64{2, 3},
+		},
+		{
+			name:     "by time range",
+			query:    AuditQuery{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour), Limit: 10},
+			wantSeqs: []uint64{2, 3},
+		},
+		{
+			name:     "first page",
+			query:    AuditQuery{Limit: 2},
+			wantSeqs: []uint64{1, 2},
+			wantMore: true,
+		},
+		{
+			name:     "page after cursor",
+			query:    AuditQuery{After: 2, Limit: 2},
+			wantSeqs: []uint64{3, 4},
+			wantMore: true,
+		},
+		{
+			name:     "last page",
+			query:    AuditQuery{After: 4, Limit: 2},
+			wantSeqs: []uint64{5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, more, err := audit.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			if more != tt.wantMore {
+				t.Errorf("Query() more = %v, want %v", more, tt.wantMore)
+			}
+
+			seqs := make([]uint64, 0, len(events))
+			for _, event := range events {
+				seqs = append(seqs, event.Seq)
+			}
+			if len(seqs) != len(tt.wantSeqs) {
+				t.Fatalf("Query() seqs = %v, want %v", seqs, tt.wantSeqs)
+			}
+			for i := range seqs {
+				if seqs[i] != tt.wantSeqs[i] {
+					t.Fatalf("Query() seqs = %v, want %v", seqs, tt.wantSeqs)
+				}
+			}
+		})
+	}
+}
+
+func TestGetAuditEndpoint(t *testing.T) {
+	audit, _ := openTestAuditLog(t)
+	for _, user := range []string{"alice", "=cmd|' /C calc'!A0", "bob"} {
+		if err := audit.Record(AuditEvent{Action: AuditActionLogin, User: user, Result: AuditResultFailure}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	sm := NewSessionManager()
+	sm.SetUsers(map[string]*User{
+		"alice": {Username: "alice"},
+		"audra": {Username: "audra", Roles: []string{RoleAuditor}},
+	})
+	s := &Server{sessionManager: sm, audit: audit}
+	handler := s.requireRole(RoleAuditor, s.getAudit)
+
+	getAs := func(user, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	t.Run("requires auditor role", func(t *testing.T) {
+		if w := getAs("alice", "/api/audit"); w.Code != http.StatusForbidden {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("JSON pages", func(t *testing.T) {
+		w := getAs("audra", "/api/audit?limit=2")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+
+		var page AuditQueryResponse
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(page.Events) != 2 || page.NextCursor == "" {
+			t.Fatalf("page = %+v, want 2 events and a cursor", page)
+		}
+
+		w = getAs("audra", "/api/audit?limit=2&cursor="+page.NextCursor)
+		page = AuditQueryResponse{}
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(page.Events) != 1 || page.Events[0].User != "bob" || page.NextCursor != "" {
+			t.Errorf("page = %+v, want only bob and no cursor", page)
+		}
+	})
+
+	t.Run("CSV export", func(t *testing.T) {
+		w := getAs("audra", "/api/audit?format=csv")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Content-Type"); got != "text/csv" {
+			t.Errorf("Content-Type = %v, want text/csv", got)
+		}
+
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("failed to parse CSV: %v", err)
+		}
+		if len(rows) != 4 || rows[0][0] != "seq" {
+			t.Fatalf("rows = %v, want header and 3 events", rows)
+		}
+		if rows[2][3] != "'=cmd|' /C calc'!A0" {
+			t.Errorf("formula user = %q, want it escaped", rows[2][3])
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"limit=0", "limit=x", "since=yesterday", "cursor=abc", "format=xml"} {
+			if w := getAs("audra", "/api/audit?"+query); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: status code = %v, want %v", query, w.Code, http.StatusBadRequest)
+			}
+		}
+	})
+}
+
+func TestAuditLogQueryLongRecord(t *testing.T) {
+	audit, _ := openTestAuditLog(t)
+
+	long := "/" + strings.Repeat("<", 100_000)
+	for _, path := range []string{long, "/logs"} {
+		if err := audit.Record(AuditEvent{Action: AuditActionList, User: "alice", Path: path, Result: AuditResultSuccess}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	events, _, err := audit.Query(AuditQuery{Limit: 10})
+	if err != nil || len(events) != 2 {
+		t.Errorf("Query() = %d events, %v, want 2, nil", len(events), err)
+	}
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testAuditKey keys the hash chain of test audit logs
+var testAuditKey = []byte("0123456789abcdef0123456789abcdef")
+
+func openTestAuditLog(t *testing.T) (*AuditLog, string) {
+	t.Helper()
+
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(logPath, testAuditKey)
+	if err != nil {
+		t.Fatalf("OpenAuditLog() error = %v", err)
+	}
+	t.Cleanup(func() { audit.Close() })
+
+	return audit, logPath
+}
+
+func readAuditEvents(t *testing.T, logPath string) []AuditEvent {
+	t.Helper()
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("failed to decode audit event: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestAuditLogChain(t *testing.T) {
+	audit, logPath := openTestAuditLog(t)
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		if err := audit.Record(AuditEvent{Action: AuditActionLogin, User: user, Result: AuditResultSuccess}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	events := readAuditEvents(t, logPath)
+	if len(events) != 3 {
+		t.Fatalf("len(events) = %v, want %v", len(events), 3)
+	}
+	for i, event := range events {
+		if event.Seq != uint64(i+1) {
+			t.Errorf("events[%d].Seq = %v, want %v", i, event.Seq, i+1)
+		}
+		if i > 0 && event.PrevHash != events[i-1].Hash {
+			t.Errorf("events[%d].PrevHash does not link to previous record", i)
+		}
+	}
+
+	count, err := VerifyAuditLog(logPath, testAuditKey)
+	if err != nil || count != 3 {
+		t.Errorf("VerifyAuditLog() = %v, %v, want 3, nil", count, err)
+	}
+
+	t.Run("chain continues after reopening", func(t *testing.T) {
+		audit.Close()
+
+		reopened, err := OpenAuditLog(logPath, testAuditKey)
+		if err != nil {
+			t.Fatalf("OpenAuditLog() error = %v", err)
+		}
+		defer reopened.Close()
+
+		if err := reopened.Record(AuditEvent{Action: AuditActionLogout, User: "bob", Result: AuditResultSuccess}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+
+		count, err := VerifyAuditLog(logPath, testAuditKey)
+		if err != nil || count != 4 {
+			t.Errorf("VerifyAuditLog() = %v, %v, want 4, nil", count, err)
+		}
+	})
+}
+
+func TestAuditLogLongFields(t *testing.T) {
+	audit, logPath := openTestAuditLog(t)
+
+	// Escaping makes each < six bytes long in the record
+	long := strings.Repeat("<", 100_000)
+	if err := audit.Record(AuditEvent{Action: AuditActionAuth, User: long, Path: "/" + long, Reason: long, Result: AuditResultFailure}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	audit.Close()
+
+	events := readAuditEvents(t, logPath)
+	if len(events) != 1 || len(events[0].Path) != maxAuditFieldLength {
+		t.Fatalf("events = %d, want one with the path cut to %d bytes", len(events), maxAuditFieldLength)
+	}
+
+	reopened, err := OpenAuditLog(logPath, testAuditKey)
+	if err != nil {
+		t.Fatalf("OpenAuditLog() error = %v", err)
+	}
+	reopened.Close()
+	if count, err := VerifyAuditLog(logPath, testAuditKey); err != nil || count != 1 {
+		t.Errorf("VerifyAuditLog() = %v, %v, want 1, nil", count, err)
+	}
+}
+
+func TestOpenAuditLogShortKey(t *testing.T) {
+	if _, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"), []byte("short")); err == nil {
+		t.Error("OpenAuditLog() error = nil, want the short key to be refused")
+	}
+}
+
+func TestVerifyAuditLogTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+	}{
+		{
+			name: "edited record",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"user":"bob"`), []byte(`"user":"eve"`), 1)
+				return lines
+			},
+		},
+		{
+			name: "removed record",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			},
+		},
+		{
+			name: "truncated log",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[:2]
+			},
+		},
+		{
+			name: "removed first record",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[1:]
+			},
+		},
+		{
+			name: "added field",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[0] = bytes.Replace(lines[0], []byte(`{`), []byte(`{"note":"x",`), 1)
+				return lines
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit, logPath := openTestAuditLog(t)
+			for _, user := range []string{"alice", "bob", "carol"} {
+				if err := audit.Record(AuditEvent{Action: AuditActionLogin, User: user, Result: AuditResultSuccess}); err != nil {
+					t.Fatalf("Record() error = %v", err)
+				}
+			}
+			audit.Close()
+
+			data, err := os.ReadFile(logPath)
+			if err != nil {
+				t.Fatalf("failed to read audit log: %v", err)
+			}
+			lines := tt.tamper(bytes.Split(bytes.TrimSpace(data), []byte("\n")))
+			if err := os.WriteFile(logPath, append(bytes.Join(lines, []byte("\n")), '\n'), 0600); err != nil {
+				t.Fatalf("failed to write audit log: %v", err)
+			}
+
+			if _, err := VerifyAuditLog(logPath, testAuditKey); !errors.Is(err, ErrAuditLogTampered) {
+				t.Errorf("VerifyAuditLog() error = %v, want %v", err, ErrAuditLogTampered)
+			}
+		})
+	}
+
+	t.Run("wrong key", func(t *testing.T) {
+		audit, logPath := openTestAuditLog(t)
+		if err := audit.Record(AuditEvent{Action: AuditActionLogin, User: "alice", Result: AuditResultSuccess}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		audit.Close()
+
+		// A log rewritten without the key can't be made to verify
+		otherKey := []byte(strings.Repeat("x", MinAuditKeyLength))
+		if _, err := VerifyAuditLog(logPath, otherKey); !errors.Is(err, ErrAuditLogTampered) {
+			t.Errorf("VerifyAuditLog() error = %v, want %v", err, ErrAuditLogTampered)
+		}
+	})
+
+	t.Run("reopening a truncated log fails", func(t *testing.T) {
+		audit, logPath := openTestAuditLog(t)
+		for _, user := range []string{"alice", "bob"} {
+			if err := audit.Record(AuditEvent{Action: AuditActionLogin, User: user, Result: AuditResultSuccess}); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+		}
+		audit.Close()
+
+		if err := os.Truncate(logPath, 0); err != nil {
+			t.Fatalf("failed to truncate audit log: %v", err)
+		}
+
+		if _, err := OpenAuditLog(logPath, testAuditKey); !errors.Is(err, ErrAuditLogTampered) {
+			t.Errorf("OpenAuditLog() error = %v, want %v", err, ErrAuditLogTampered)
+		}
+	})
+
+	t.Run("truncated log with rewritten head", func(t *testing.T) {
+		audit, logPath := openTestAuditLog(t)
+		for _, user := range []string{"alice", "bob", "carol"} {
+			if err := audit.Record(AuditEvent{Action: AuditActionLogin, User: user, Result: AuditResultSuccess}); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+		}
+		audit.Close()
+
+		data, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("failed to read audit log: %v", err)
+		}
+		lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+		var kept AuditEvent
+		if err := json.Unmarshal(lines[1], &kept); err != nil {
+			t.Fatalf("failed to decode audit record: %v", err)
+		}
+
+		// Everything needed to point the head at an earlier record can be
+		// read from the log, but not the key for its MAC
+		if err := os.WriteFile(logPath, append(bytes.Join(lines[:2], []byte("\n")), '\n'), 0600); err != nil {
+			t.Fatalf("failed to write audit log: %v", err)
+		}
+		head, err := json.Marshal(auditHead{Seq: kept.Seq, Hash: kept.Hash})
+		if err != nil {
+			t.Fatalf("failed to encode audit head: %v", err)
+		}
+		if err := os.WriteFile(logPath+auditHeadSuffix, head, 0600); err != nil {
+			t.Fatalf("failed to write audit head: %v", err)
+		}
+
+		if _, err := VerifyAuditLog(logPath, testAuditKey); !errors.Is(err, ErrAuditLogTampered) {
+			t.Errorf("VerifyAuditLog() error = %v, want %v", err, ErrAuditLogTampered)
+		}
+		if _, err := OpenAuditLog(logPath, testAuditKey); !errors.Is(err, ErrAuditLogTampered) {
+			t.Errorf("OpenAuditLog() error = %v, want %v", err, ErrAuditLogTampered)
+		}
+	})
+}
+
+func TestAuditedEndpoints(t *testing.T) {
+	audit, logPath := openTestAuditLog(t)
+
+	tmpDir := t.TempDir()
+	sm := NewSessionManager()
+	s := &Server{rootDir: tmpDir, sessionManager: sm, audit: audit}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(LoginRequest{Username: "alice", Password: password})
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.login(w, req)
+		return w
+	}
+
+	login("wrongpassword")
+	w := login("password")
+	token := w.Result().Cookies()[0].Value
+
+	listFiles := s.requireAuth(s.audited(AuditActionList, "/api/files", s.getFiles))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/missing", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	listFiles(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/files/", nil)
+	listFiles(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/logout", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	s.logout(httptest.NewRecorder(), req)
+
+	want := []AuditEvent{
+		{Action: AuditActionLogin, User: "alice", Result: AuditResultFailure},
+		{Action: AuditActionLogin, User: "alice", Result: AuditResultSuccess, SessionID: sessionID(token)},
+		{Action: AuditActionList, User: "alice", Result: AuditResultFailure, SessionID: sessionID(token), Path: "/missing"},
+		{Action: AuditActionAuth, Result: AuditResultFailure, Path: "/api/files/"},
+		{Action: AuditActionLogout, User: "alice", Result: AuditResultSuccess, SessionID: sessionID(token)},
+	}
+
+	events := readAuditEvents(t, logPath)
+	if len(events) != len(want) {
+		t.Fatalf("len(events) = %v, want %v", len(events), len(want))
+	}
+	for i, event := range events {
+		if event.Action != want[i].Action || event.User != want[i].User || event.Result != want[i].Result ||
+			event.SessionID != want[i].SessionID || event.Path != want[i].Path {
+			t.Errorf("events[%d] = %+v, want %+v", i, event, want[i])
+		}
+		if event.SourceIP == "" {
+			t.Errorf("events[%d].SourceIP is empty", i)
+		}
+		if strings.Contains(event.SessionID, token) {
+			t.Errorf("events[%d] contains the raw session token", i)
+		}
+	}
+}
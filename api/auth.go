@@ -4,8 +4,10 @@ import (
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
@@ -30,10 +32,29 @@ var (
 	ErrSessionExpired     = errors.New("session expired")
 )
 
+const (
+	// RoleAdmin users are never jailed to a home directory
+	RoleAdmin = "admin"
+	// RoleAuditor users can query the audit log
+	RoleAuditor = "auditor"
+)
+
 // User represents a user in the system
 type User struct {
-	Username     string
-	PasswordHash string // Argon2ID hash in encoded format
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash"`  // Argon2ID hash in encoded format
+	Home         string   `json:"home,omitempty"` // Home directory relative to the served root
+	Roles        []string `json:"roles,omitempty"`
+}
+
+// HasRole reports whether the user has been granted role
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
 }
 
 // Session represents an active user session
@@ -74,6 +95,62 @@ func NewSessionManager() *SessionManager {
 	return sm
 }
 
+// LoadUsersFile reads users from a JSON file containing an array of users
+func LoadUsersFile(path string) (map[string]*User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	var list []*User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse users file: %w", err)
+	}
+
+	users := make(map[string]*User, len(list))
+	for _, user := range list {
+		if user.Username == "" || user.PasswordHash == "" {
+			return nil, fmt.Errorf("users file entries require a username and password_hash")
+		}
+		if _, exists := users[user.Username]; exists {
+			return nil, fmt.Errorf("duplicate user %q in users file", user.Username)
+		}
+		users[user.Username] = user
+	}
+
+	return users, nil
+}
+
+// SetUsers replaces the set of users that can log in
+func (sm *SessionManager) SetUsers(users map[string]*User) {
+	sm.mu.Lock()
+	sm.users = users
+	sm.mu.Unlock()
+}
+
+// User returns the user with the given username
+func (sm *SessionManager) User(username string) (*User, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	user, exists := sm.users[username]
+	return user, exists
+}
+
+// Ready returns an error if the user or session store isn't loaded
+func (sm *SessionManager) Ready() error {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if sm.sessions == nil {
+		return errors.New("session store is not initialized")
+	}
+	if len(sm.users) == 0 {
+		return errors.New("no users are loaded")
+	}
+	return nil
+}
+
 // generateSalt generates a random salt
 func generateSalt() []byte {
 	salt := make([]byte, argon2SaltLength)
@@ -214,6 +291,23 @@ func (sm *SessionManager) ValidateSession(token string) (string, error) {
 	return session.UserID, nil
 }
 
+// SessionUser returns the user of a live session without counting as
+// activity, so that long running requests can check their session still holds
+func (sm *SessionManager) SessionUser(token string) (string, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	session, exists := sm.sessions[token]
+	if !exists {
+		return "", ErrSessionNotFound
+	}
+	now := time.Now()
+	if now.After(session.InactivityExpiry) || now.After(session.MaxExpiry) {
+		return "", ErrSessionExpired
+	}
+	return session.UserID, nil
+}
+
 // DeleteSession deletes a session
 func (sm *SessionManager) DeleteSession(token string) {
 	sm.mu.Lock()
@@ -221,6 +315,21 @@ func (sm *SessionManager) DeleteSession(token string) {
 	sm.mu.Unlock()
 }
 
+// ActiveSessions returns the number of sessions that have not expired
+func (sm *SessionManager) ActiveSessions() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	now := time.Now()
+	count := 0
+	for _, session := range sm.sessions {
+		if now.Before(session.InactivityExpiry) && now.Before(session.MaxExpiry) {
+			count++
+		}
+	}
+	return count
+}
+
 // CleanupExpiredSessions removes all expired sessions
 func (sm *SessionManager) CleanupExpiredSessions() {
 	sm.mu.Lock()
@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -159,6 +162,24 @@ func TestLoginEndpoint(t *testing.T) {
 		}
 	})
 
+	t.Run("login with oversized body", func(t *testing.T) {
+		reqBody := LoginRequest{
+			Username: "alice",
+			Password: strings.Repeat("a", maxLoginBodySize),
+		}
+		body, _ := json.Marshal(reqBody)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		s.login(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("login() status = %v, want %v", w.Code, http.StatusRequestEntityTooLarge)
+		}
+	})
+
 	t.Run("login with wrong method", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/api/login", nil)
 		w := httptest.NewRecorder()
@@ -349,3 +370,55 @@ func TestPasswordHashing(t *testing.T) {
 		t.Error("verifyPassword() succeeded for incorrect password")
 	}
 }
+
+func TestLoadUsersFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	writeUsers := func(t *testing.T, content string) string {
+		t.Helper()
+		usersFile := filepath.Join(tmpDir, "users.json")
+		if err := os.WriteFile(usersFile, []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write users file: %v", err)
+		}
+		return usersFile
+	}
+
+	t.Run("valid users file", func(t *testing.T) {
+		users, err := LoadUsersFile(writeUsers(t, `[
+			{"username": "carol", "password_hash": "a:b", "home": "contractors/carol"},
+			{"username": "dave", "password_hash": "c:d", "roles": ["admin"]}
+		]`))
+		if err != nil {
+			t.Fatalf("LoadUsersFile() error = %v", err)
+		}
+
+		if users["carol"].Home != "contractors/carol" {
+			t.Errorf("carol home = %v, want %v", users["carol"].Home, "contractors/carol")
+		}
+		if !users["dave"].HasRole(RoleAdmin) {
+			t.Error("dave is not an admin")
+		}
+		if users["carol"].HasRole(RoleAdmin) {
+			t.Error("carol is an admin")
+		}
+	})
+
+	t.Run("missing password hash", func(t *testing.T) {
+		if _, err := LoadUsersFile(writeUsers(t, `[{"username": "carol"}]`)); err == nil {
+			t.Error("LoadUsersFile() error = nil, want error")
+		}
+	})
+
+	t.Run("duplicate user", func(t *testing.T) {
+		content := `[{"username": "carol", "password_hash": "a:b"}, {"username": "carol", "password_hash": "a:b"}]`
+		if _, err := LoadUsersFile(writeUsers(t, content)); err == nil {
+			t.Error("LoadUsersFile() error = nil, want error")
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		if _, err := LoadUsersFile(writeUsers(t, `{`)); err == nil {
+			t.Error("LoadUsersFile() error = nil, want error")
+		}
+	})
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultListingCacheTTL = 5 * time.Second
+)
+
+// ListingCacheConfig configures the cache of directory listings
+type ListingCacheConfig struct {
+	// Size is the most directories kept in the cache
+	Size int
+	// TTL is how long a listing is used for when its directory can't be
+	// watched for changes
+	TTL time.Duration
+}
+
+// WithListingCache caches directory listings, keyed by resolved path, so hot
+// directories aren't read and stat'ed on every request. Cached listings are
+// invalidated by inotify where it's available and expire after cfg.TTL
+// elsewhere.
+func WithListingCache(cfg ListingCacheConfig) Option {
+	return func(s *Server) error {
+		c, err := newListingCache(cfg)
+		if err != nil {
+			return err
+		}
+		s.listingCache = c
+		return nil
+	}
+}
+
+// listingCache is an LRU cache of directory listings. A nil *listingCache
+// caches nothing.
+type listingCache struct {
+	size    int
+	ttl     time.Duration
+	watcher dirWatcher
+	now     func() time.Time
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	invalidations uint64
+}
+
+// cacheEntry is a directory's slot in the cache
+type cacheEntry struct {
+	path string
+	// listing is nil until the directory is read and after it changes
+	listing *cachedListing
+	// gen is incremented when the directory changes, so a listing read
+	// while it changed isn't stored
+	gen     uint64
+	watched bool
+	expires time.Time
+}
+
+// cachedListing holds every entry of a directory, before filtering for the
+// user. Entries are shared between requests and must not be modified.
+type cachedListing struct {
+	// modTime is the directory's modification time when it was read
+	modTime time.Time
+	entries []*FileInfo
+}
+
+// cacheTicket is handed out on a miss and allows storing the listing read for
+// it if the directory hasn't changed in the meantime
+type cacheTicket struct {
+	entry *cacheEntry
+	gen   uint64
+}
+
+// listingCacheStats is a snapshot of the cache counters
+type listingCacheStats struct {
+	entries       int
+	capacity      int
+	watched       int
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	invalidations uint64
+}
+
+func newListingCache(cfg ListingCacheConfig) (*listingCache, error) {
+	if cfg.Size < 1 {
+		return nil, errors.New("listing cache size must be positive")
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultListingCacheTTL
+	}
+
+	c := &listingCache{
+		size:  cfg.Size,
+		ttl:   cfg.TTL,
+		now:   time.Now,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}
+
+	watcher, err := newDirWatcher(c.handleEvent, c.invalidateAll)
+	if err != nil {
+		slog.Warn("directory watching unavailable, cached listings expire after the TTL", "ttl", cfg.TTL, "error", err)
+	} else {
+		c.watcher = watcher
+	}
+
+	return c, nil
+}
+
+// get returns the cached listing of path if it's current. modTime is the
+// directory's modification time, which catches changes that weren't
+// reported, for example on network filesystems. On a miss the returned
+// ticket is passed to put.
+func (c *listingCache) get(path string, modTime time.Time) (*cachedListing, cacheTicket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var entry *cacheEntry
+	if elem, ok := c.items[path]; ok {
+		c.lru.MoveToFront(elem)
+		entry = elem.Value.(*cacheEntry)
+		listing := entry.listing
+		if listing != nil && listing.modTime.Equal(modTime) && (entry.watched || c.now().Before(entry.expires)) {
+			c.hits++
+			return listing, cacheTicket{}
+		}
+		entry.listing = nil
+	} else {
+		entry = &cacheEntry{path: path}
+		c.items[path] = c.lru.PushFront(entry)
+		c.evict()
+	}
+	c.misses++
+
+	// Watch before the directory is read so no change is missed
+	if !entry.watched && c.watcher != nil {
+		if err := c.watcher.add(path); err != nil {
+			slog.Debug("failed to watch directory, its listing expires after the TTL", "path", path, "error", err)
+		} else {
+			entry.watched = true
+		}
+	}
+
+	return nil, cacheTicket{entry: entry, gen: entry.gen}
+}
+
+// put stores a listing read after a miss, unless the directory changed or
+// left the cache while it was being read
+func (c *listingCache) put(t cacheTicket, listing *cachedListing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[t.entry.path]
+	if !ok || elem.Value.(*cacheEntry) != t.entry || t.entry.gen != t.gen {
+		return
+	}
+	t.entry.listing = listing
+	t.entry.expires = c.now().Add(c.ttl)
+}
+
+// handleEvent invalidates the listing of a directory that changed
+func (c *listingCache) handleEvent(ev dirEvent) {
+	gone := ev.op == watchOpGone
+	if gone && !ev.unwatched {
+		// The directory moved away, so the path no longer refers to it
+		c.watcher.remove(ev.dir)
+	}
+	c.invalidate(ev.dir, gone)
+}
+
+// invalidate drops the listing of a directory that changed. unwatched is set
+// when the directory's watch was removed because it's gone.
+func (c *listingCache) invalidate(path string, unwatched bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[path]
+	if !ok {
+		return
+	}
+	entry := elem.Value.(*cacheEntry)
+	if entry.listing != nil {
+		c.invalidations++
+	}
+	entry.listing = nil
+	entry.gen++
+	if unwatched {
+		entry.watched = false
+	}
+}
+
+// invalidateAll drops every listing, used when change events were lost
+func (c *listingCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, elem := range c.items {
+		entry := elem.Value.(*cacheEntry)
+		if entry.listing != nil {
+			c.invalidations++
+		}
+		entry.listing = nil
+		entry.gen++
+	}
+}
+
+// evict removes the least recently used directories over the size limit.
+// c.mu must be held.
+func (c *listingCache) evict() {
+	for c.lru.Len() > c.size {
+		entry := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.items, entry.path)
+		if entry.watched {
+			c.watcher.remove(entry.path)
+		}
+		c.evictions++
+	}
+}
+
+func (c *listingCache) stats() listingCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := listingCacheStats{
+		entries:       c.lru.Len(),
+		capacity:      c.size,
+		hits:          c.hits,
+		misses:        c.misses,
+		evictions:     c.evictions,
+		invalidations: c.invalidations,
+	}
+	for _, elem := range c.items {
+		if elem.Value.(*cacheEntry).watched {
+			stats.watched++
+		}
+	}
+	return stats
+}
+
+// Close stops watching directories
+func (c *listingCache) Close() error {
+	if c == nil || c.watcher == nil {
+		return nil
+	}
+	return c.watcher.Close()
+}
+
+// visible returns copies of the entries visible allows, so the caller can
+// describe them without changing the cache
+func (l *cachedListing) visible(visible func(name string) bool) []*FileInfo {
+	entries := make([]*FileInfo, 0, len(l.entries))
+	for _, entry := range l.entries {
+		if visible != nil && !visible(entry.Name) {
+			continue
+		}
+		entryCopy := *entry
+		entries = append(entries, &entryCopy)
+	}
+	return entries
+}
+
+// cacheable reports whether every entry was read, a listing with timed out or
+// failed entries is worth retrying
+func cacheable(entries []*FileInfo) bool {
+	for _, entry := range entries {
+		if entry.Error != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// listDirectory returns the entries of a directory that opts allows to be
+// listed, from the cache if it's current
+func (s *Server) listDirectory(ctx context.Context, path string, info os.FileInfo, opts listOptions) ([]*FileInfo, error) {
+	if s.listingCache == nil {
+		return s.readDirectory(ctx, path, opts.visible)
+	}
+
+	listing, ticket := s.listingCache.get(path, info.ModTime())
+	if listing == nil {
+		// Read every entry so the listing can be shared between users
+		entries, err := s.readDirectory(ctx, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		listing = &cachedListing{modTime: info.ModTime(), entries: entries}
+		if cacheable(entries) {
+			s.listingCache.put(ticket, listing)
+		}
+	}
+
+	return listing.visible(opts.visible), nil
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeWatcher records the directories a cache watches
+type fakeWatcher struct {
+	watched []string
+}
+
+func (w *fakeWatcher) add(path string) error {
+	w.watched = append(w.watched, path)
+	return nil
+}
+
+func (w *fakeWatcher) remove(path string) {
+	w.watched = slices.DeleteFunc(w.watched, func(p string) bool { return p == path })
+}
+
+func (w *fakeWatcher) Close() error {
+	return nil
+}
+
+// newTestListingCache returns a cache with a clock the test controls
+func newTestListingCache(size int, watcher dirWatcher, now *time.Time) *listingCache {
+	return &listingCache{
+		size:    size,
+		ttl:     time.Minute,
+		watcher: watcher,
+		now:     func() time.Time { return *now },
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func TestListingCache(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	listing := &cachedListing{modTime: modTime, entries: []*FileInfo{{Name: "a.txt", Type: "file"}}}
+
+	t.Run("hit after put", func(t *testing.T) {
+		now := time.Now()
+		c := newTestListingCache(2, &fakeWatcher{}, &now)
+
+		got, ticket := c.get("/dir", modTime)
+		if got != nil {
+			t.Fatalf("get() on empty cache = %v, want miss", got)
+		}
+		c.put(ticket, listing)
+
+		if got, _ := c.get("/dir", modTime); got != listing {
+			t.Errorf("get() = %v, want cached listing", got)
+		}
+		if got, _ := c.get("/dir", modTime.Add(time.Second)); got != nil {
+			t.Errorf("get() with a newer mtime = %v, want miss", got)
+		}
+		if stats := c.stats(); stats.hits != 1 || stats.misses != 2 {
+			t.Errorf("stats() = %+v, want 1 hit and 2 misses", stats)
+		}
+	})
+
+	t.Run("change while reading", func(t *testing.T) {
+		now := time.Now()
+		c := newTestListingCache(2, &fakeWatcher{}, &now)
+
+		_, ticket := c.get("/dir", modTime)
+		c.invalidate("/dir", false)
+		c.put(ticket, listing)
+
+		if got, _ := c.get("/dir", modTime); got != nil {
+			t.Errorf("get() = %v, want miss for a listing read while the directory changed", got)
+		}
+	})
+
+	t.Run("invalidated", func(t *testing.T) {
+		now := time.Now()
+		c := newTestListingCache(2, &fakeWatcher{}, &now)
+
+		_, ticket := c.get("/dir", modTime)
+		c.put(ticket, listing)
+		c.invalidate("/dir", false)
+
+		if got, _ := c.get("/dir", modTime); got != nil {
+			t.Errorf("get() = %v, want miss after invalidation", got)
+		}
+		if stats := c.stats(); stats.invalidations != 1 {
+			t.Errorf("stats().invalidations = %v, want 1", stats.invalidations)
+		}
+	})
+
+	t.Run("expires without a watch", func(t *testing.T) {
+		now := time.Now()
+		c := newTestListingCache(2, nil, &now)
+
+		_, ticket := c.get("/dir", modTime)
+		c.put(ticket, listing)
+		if got, _ := c.get("/dir", modTime); got != listing {
+			t.Fatalf("get() = %v, want cached listing", got)
+		}
+
+		now = now.Add(2 * time.Minute)
+		if got, _ := c.get("/dir", modTime); got != nil {
+			t.Errorf("get() after the TTL = %v, want miss", got)
+		}
+	})
+
+	t.Run("watched listings don't expire", func(t *testing.T) {
+		now := time.Now()
+		c := newTestListingCache(2, &fakeWatcher{}, &now)
+
+		_, ticket := c.get("/dir", modTime)
+		c.put(ticket, listing)
+
+		now = now.Add(time.Hour)
+		if got, _ := c.get("/dir", modTime); got != listing {
+			t.Errorf("get() = %v, want cached listing", got)
+		}
+	})
+
+	t.Run("least recently used is evicted", func(t *testing.T) {
+		now := time.Now()
+		watcher := &fakeWatcher{}
+		c := newTestListingCache(2, watcher, &now)
+
+		for _, dir := range []string{"/a", "/b", "/a", "/c"} {
+			if _, ticket := c.get(dir, modTime); ticket.entry != nil {
+				c.put(ticket, listing)
+			}
+		}
+
+		if got, _ := c.get("/a", modTime); got != listing {
+			t.Errorf("get(/a) = %v, want cached listing", got)
+		}
+		if !slices.Equal(watcher.watched, []string{"/a", "/c"}) {
+			t.Errorf("watched = %v, want [/a /c]", watcher.watched)
+		}
+		if stats := c.stats(); stats.entries != 2 || stats.evictions != 1 {
+			t.Errorf("stats() = %+v, want 2 entries and 1 eviction", stats)
+		}
+	})
+}
+
+func TestGetFilesCached(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("test content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	cache, err := newListingCache(ListingCacheConfig{Size: 10})
+	if err != nil {
+		t.Fatalf("newListingCache() error = %v", err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	s := &Server{rootDir: tmpDir, listingCache: cache}
+
+	get := func(urlPath string) FileInfo {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.getFiles(w, httptest.NewRequest(http.MethodGet, urlPath, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+		var fileInfo FileInfo
+		if err := json.NewDecoder(w.Body).Decode(&fileInfo); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return fileInfo
+	}
+
+	get("/api/files/")
+	withMode := get("/api/files/?fields=mode")
+	if withMode.Contents[0].Mode == "" {
+		t.Errorf("Mode = %q, want it filled in from the cached listing", withMode.Contents[0].Mode)
+	}
+
+	// Describing entries for one request mustn't change the cached listing
+	plain := get("/api/files/")
+	if plain.Contents[0].Mode != "" {
+		t.Errorf("Mode = %q, want it omitted", plain.Contents[0].Mode)
+	}
+
+	if stats := cache.stats(); stats.hits != 2 || stats.misses != 1 {
+		t.Errorf("stats() = %+v, want 2 hits and 1 miss", stats)
+	}
+
+	// Adding an entry changes the directory's mtime even without a watch
+	if err := os.WriteFile(filepath.Join(tmpDir, "new.txt"), []byte("x"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if got := get("/api/files/"); len(got.Contents) != 2 {
+		t.Errorf("len(Contents) = %v, want %v", len(got.Contents), 2)
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// validators identify the state of a file or directory listing so clients
+// can skip downloading it again when it hasn't changed
+type validators struct {
+	// version is a hash of everything the response is built from
+	version string
+	// modTime is the latest modification time of the file or directory and
+	// its entries
+	modTime time.Time
+}
+
+// newValidators returns the validators for a file, or for a directory and
+// every entry that's listed in it before paging
+func newValidators(info os.FileInfo, entries []*FileInfo) validators {
+	h := sha256.New()
+	modTime := info.ModTime()
+	writeState(h, info.Name(), "", info.Size(), info)
+
+	for _, entry := range entries {
+		writeState(h, entry.Name, entry.Type, entry.Size, entry.info)
+		if entry.Error != nil {
+			io.WriteString(h, entry.Error.Code)
+		}
+		if entry.info != nil && entry.info.ModTime().After(modTime) {
+			modTime = entry.info.ModTime()
+		}
+	}
+
+	return validators{version: hex.EncodeToString(h.Sum(nil)), modTime: modTime}
+}
+
+// writeState writes what's known about a file to h. A directory's mtime
+// changes when entries are added, removed or renamed, an entry's when its
+// contents change.
+func writeState(h hash.Hash, name, typ string, size int64, info os.FileInfo) {
+	fmt.Fprintf(h, "%q %s %d", name, typ, size)
+	if info != nil {
+		fmt.Fprintf(h, " %o %d", info.Mode(), info.ModTime().UnixNano())
+	}
+	io.WriteString(h, "\n")
+}
+
+// etag returns the entity tag of the representation selected by query. It's
+// weak because the JSON encoding isn't guaranteed to be byte for byte stable.
+func (v validators) etag(query url.Values) string {
+	h := sha256.New()
+	io.WriteString(h, v.version)
+	io.WriteString(h, query.Encode())
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// checkNotModified sets the validators on the response and, if the request's
+// conditions show the client already has this version, replies with 304 and
+// returns true
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	h := w.Header()
+	// Responses depend on the user, so shared caches mustn't store them
+	h.Set("Cache-Control", "private, no-cache")
+	h.Set("ETag", etag)
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	if !notModified(r, etag, modTime) {
+		return false
+	}
+
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since as described in
+// RFC 9110 section 13.2.2. If-Modified-Since is ignored when If-None-Match is
+// present because the entity tag is more precise.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modTime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have second precision
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// etagMatches reports whether an If-None-Match list matches etag, using weak
+// comparison
+func etagMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetFilesConditional(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("test content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(testFile, past, past); err != nil {
+		t.Fatalf("failed to set times: %v", err)
+	}
+
+	s := &Server{rootDir: tmpDir}
+	get := func(urlPath string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, urlPath, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		s.getFiles(w, req)
+		return w
+	}
+
+	t.Run("directory", func(t *testing.T) {
+		w := get("/api/files/", nil)
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" || w.Header().Get("Last-Modified") == "" {
+			t.Fatalf("got %v with ETag %q and Last-Modified %q, want 200 with validators",
+				w.Code, etag, w.Header().Get("Last-Modified"))
+		}
+
+		w = get("/api/files/", http.Header{"If-None-Match": {etag}})
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("unchanged: got %v with %d bytes, want %v and no body", w.Code, w.Body.Len(), http.StatusNotModified)
+		}
+
+		w = get("/api/files/?fields=mode", http.Header{"If-None-Match": {etag}})
+		if w.Code != http.StatusOK {
+			t.Errorf("different fields: status code = %v, want %v", w.Code, http.StatusOK)
+		}
+
+		if err := os.WriteFile(filepath.Join(tmpDir, "new.txt"), []byte("x"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		w = get("/api/files/", http.Header{"If-None-Match": {etag}})
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+			t.Errorf("entry added: got %v with ETag %q, want 200 with a new ETag", w.Code, w.Header().Get("ETag"))
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		w := get("/api/files/test.txt", nil)
+		lastModified := w.Header().Get("Last-Modified")
+		if want := past.UTC().Format(http.TimeFormat); lastModified != want {
+			t.Fatalf("Last-Modified = %q, want %q", lastModified, want)
+		}
+
+		w = get("/api/files/test.txt", http.Header{"If-Modified-Since": {lastModified}})
+		if w.Code != http.StatusNotModified {
+			t.Errorf("unchanged: status code = %v, want %v", w.Code, http.StatusNotModified)
+		}
+
+		// If-None-Match takes precedence over If-Modified-Since
+		w = get("/api/files/test.txt", http.Header{"If-None-Match": {`W/"stale"`}, "If-Modified-Since": {lastModified}})
+		if w.Code != http.StatusOK {
+			t.Errorf("stale ETag: status code = %v, want %v", w.Code, http.StatusOK)
+		}
+
+		if err := os.WriteFile(testFile, []byte("changed content"), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		w = get("/api/files/test.txt", http.Header{"If-Modified-Since": {lastModified}})
+		if w.Code != http.StatusOK {
+			t.Errorf("modified: status code = %v, want %v", w.Code, http.StatusOK)
+		}
+	})
+}
+
+func TestEtagMatches(t *testing.T) {
+	const etag = `W/"abc"`
+
+	tests := []struct {
+		list string
+		want bool
+	}{
+		{`W/"abc"`, true},
+		{`"abc"`, true},
+		{`"other", W/"abc"`, true},
+		{`*`, true},
+		{`"other"`, false},
+		{`W/"ab"`, false},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.list, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// downloadContentType is sent for every download so browsers never render
+// user content as a page from this origin
+const downloadContentType = "application/octet-stream"
+
+// downloadFile handles GET requests to /api/download/<path>, serving the
+// contents of a file with support for range requests
+func (s *Server) downloadFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	target, ok := s.resolveTarget(w, r, strings.TrimPrefix(r.URL.Path, "/api/download"))
+	if !ok {
+		return
+	}
+	if target.mount == nil {
+		writeError(w, r, ErrorCodeNotAFile, "Only files can be downloaded", http.StatusBadRequest)
+		return
+	}
+
+	f, info, ok := openRegularFile(w, r, target.fullPath)
+	if !ok {
+		return
+	}
+	defer f.Close()
+
+	setDownloadHeaders(w, info.Name())
+	// Validators let clients resume an interrupted download with If-Range
+	h := w.Header()
+	h.Set("ETag", `"`+newValidators(info, nil).version[:32]+`"`)
+	h.Set("Cache-Control", "private, no-cache")
+
+	_, sp := startSpan(r.Context(), "http.ServeContent")
+	sp.setAttribute("file.path", target.fullPath)
+	sp.setAttribute("file.size", info.Size())
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	sp.finish(nil)
+}
+
+// openRegularFile opens a file for reading, replying with an error and
+// returning false if it doesn't exist or isn't a regular file
+func openRegularFile(w http.ResponseWriter, r *http.Request, path string) (*os.File, os.FileInfo, bool) {
+	// Opening a FIFO blocks until something writes to it, so other kinds of
+	// file are refused before they're opened
+	info, err := os.Stat(path)
+	if err != nil {
+		writeOpenError(w, r, err)
+		return nil, nil, false
+	}
+	if !info.Mode().IsRegular() {
+		writeError(w, r, ErrorCodeNotAFile, "Path is not a file", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	// The path may have been swapped for a FIFO since, which O_NONBLOCK
+	// opens without waiting. Reads from regular files ignore it.
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		writeOpenError(w, r, err)
+		return nil, nil, false
+	}
+
+	// Stat the open file so it can't be swapped for something else
+	info, err = f.Stat()
+	if err != nil {
+		f.Close()
+		internalError(w, r, "failed to stat file", err)
+		return nil, nil, false
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		writeError(w, r, ErrorCodeNotAFile, "Path is not a file", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	return f, info, true
+}
+
+// writeOpenError replies with the error for a file that couldn't be opened
+func writeOpenError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case os.IsNotExist(err):
+		writeError(w, r, ErrorCodeNotFound, "File or directory does not exist", http.StatusNotFound)
+	case os.IsPermission(err):
+		writeError(w, r, ErrorCodeForbidden, "Permission denied", http.StatusForbidden)
+	default:
+		internalError(w, r, "failed to open file", err)
+	}
+}
+
+// setDownloadHeaders makes browsers save the response as name rather than
+// display it
+func setDownloadHeaders(w http.ResponseWriter, name string) {
+	h := w.Header()
+	h.Set("Content-Type", downloadContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Disposition", contentDisposition(name))
+	// In case the response is opened anyway, don't let it run scripts
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+}
+
+// contentDisposition returns an attachment disposition for name, encoding it
+// as RFC 6266 describes if it isn't plain ASCII
+func contentDisposition(name string) string {
+	name = filepath.Base(name)
+	if v := mime.FormatMediaType("attachment", map[string]string{"filename": name}); v != "" {
+		return v
+	}
+	return "attachment"
+}
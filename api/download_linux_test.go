@@ -0,0 +1,53 @@
+//go:build linux
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenRegularFileFIFO(t *testing.T) {
+	root := t.TempDir()
+	if err := syscall.Mkfifo(filepath.Join(root, "pipe"), 0644); err != nil {
+		t.Fatalf("failed to create FIFO: %v", err)
+	}
+	s := &Server{rootDir: root}
+
+	tests := []struct {
+		name    string
+		urlPath string
+		handler http.HandlerFunc
+	}{
+		{"download", "/api/download/pipe", s.downloadFile},
+		{"preview", "/api/preview/pipe", s.previewFile},
+		{"thumbnail", "/api/thumbnail/pipe", s.getThumbnail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tt.handler(w, httptest.NewRequest(http.MethodGet, tt.urlPath, nil))
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("request for a FIFO is still waiting for a writer")
+			}
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			if code := decodeError(t, w).Code; code != ErrorCodeNotAFile {
+				t.Errorf("error code = %v, want %v", code, ErrorCodeNotAFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDownloadFile(t *testing.T) {
+	root := newSymlinkTree(t)
+	s := &Server{rootDir: root}
+
+	download := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, "/api/download"+path, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		s.downloadFile(w, r)
+		return w
+	}
+
+	t.Run("whole file", func(t *testing.T) {
+		w := download(http.MethodGet, "/docs/readme.txt", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+		}
+		if w.Body.String() != "hi" {
+			t.Errorf("body = %q, want %q", w.Body, "hi")
+		}
+
+		wantHeaders := map[string]string{
+			"Content-Type":           "application/octet-stream",
+			"X-Content-Type-Options": "nosniff",
+			"Content-Disposition":    "attachment; filename=readme.txt",
+			"Accept-Ranges":          "bytes",
+			"Content-Length":         "2",
+		}
+		for name, want := range wantHeaders {
+			if got := w.Header().Get(name); got != want {
+				t.Errorf("%s = %q, want %q", name, got, want)
+			}
+		}
+		if w.Header().Get("ETag") == "" {
+			t.Error("ETag is empty")
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		w := download(http.MethodGet, "/docs/readme.txt", http.Header{"Range": {"bytes=1-"}})
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusPartialContent)
+		}
+		if w.Body.String() != "i" {
+			t.Errorf("body = %q, want %q", w.Body, "i")
+		}
+		if got := w.Header().Get("Content-Range"); got != "bytes 1-1/2" {
+			t.Errorf("Content-Range = %q, want %q", got, "bytes 1-1/2")
+		}
+	})
+
+	t.Run("if-range with a stale etag", func(t *testing.T) {
+		w := download(http.MethodGet, "/docs/readme.txt", http.Header{"Range": {"bytes=1-"}, "If-Range": {`"stale"`}})
+		if w.Code != http.StatusOK || w.Body.String() != "hi" {
+			t.Errorf("got %v %q, want the whole file", w.Code, w.Body)
+		}
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		etag := download(http.MethodGet, "/docs/readme.txt", nil).Header().Get("ETag")
+		w := download(http.MethodGet, "/docs/readme.txt", http.Header{"If-None-Match": {etag}})
+		if w.Code != http.StatusNotModified {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusNotModified)
+		}
+	})
+
+	t.Run("file name is encoded", func(t *testing.T) {
+		if got, want := contentDisposition("my report.txt"), `attachment; filename="my report.txt"`; got != want {
+			t.Errorf("contentDisposition() = %q, want %q", got, want)
+		}
+		if got, want := contentDisposition("résumé.pdf"), "attachment; filename*=utf-8''r%C3%A9sum%C3%A9.pdf"; got != want {
+			t.Errorf("contentDisposition() = %q, want %q", got, want)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"directory", http.MethodGet, "/docs", http.StatusBadRequest, ErrorCodeNotAFile},
+		{"root", http.MethodGet, "/", http.StatusBadRequest, ErrorCodeNotAFile},
+		{"missing", http.MethodGet, "/docs/missing.txt", http.StatusNotFound, ErrorCodeNotFound},
+		{"link inside root", http.MethodGet, "/to-readme", http.StatusOK, ""},
+		{"link outside root", http.MethodGet, "/escape", http.StatusForbidden, ErrorCodeSymlinkOutsideRoot},
+		{"traversal stays in the root", http.MethodGet, "/docs/../../etc/passwd", http.StatusNotFound, ErrorCodeNotFound},
+		{"head", http.MethodHead, "/docs/readme.txt", http.StatusOK, ""},
+		{"post", http.MethodPost, "/docs/readme.txt", http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := download(tt.method, tt.path, nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status code = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				if code := decodeError(t, w).Code; code != tt.wantCode {
+					t.Errorf("error code = %v, want %v", code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error codes identify what went wrong. Unlike messages they don't change, so
+// clients should match on them.
+const (
+	ErrorCodeBadRequest         = "bad_request"
+	ErrorCodeInvalidParameter   = "invalid_parameter"
+	ErrorCodeInvalidBody        = "invalid_body"
+	ErrorCodeInvalidContentType = "invalid_content_type"
+	ErrorCodeBodyTooLarge       = "body_too_large"
+	ErrorCodeInvalidPath        = "invalid_path"
+	ErrorCodePathTooLong        = "path_too_long"
+	ErrorCodePathTraversal      = "path_traversal"
+	ErrorCodeSymlinkDenied      = "symlink_denied"
+	ErrorCodeSymlinkOutsideRoot = "symlink_outside_root"
+	ErrorCodeUnauthenticated    = "unauthenticated"
+	ErrorCodeInvalidCredentials = "invalid_credentials"
+	ErrorCodeForbidden          = "forbidden"
+	ErrorCodeNotFound           = "not_found"
+	ErrorCodeNotADirectory      = "not_a_directory"
+	ErrorCodeNotAFile           = "not_a_file"
+	ErrorCodeArchiveTooLarge    = "archive_too_large"
+	ErrorCodeFileChanged        = "file_changed"
+	ErrorCodeBinaryFile         = "binary_file"
+	ErrorCodeUnsupportedImage   = "unsupported_image"
+	ErrorCodeInvalidImage       = "invalid_image"
+	ErrorCodeImageTooLarge      = "image_too_large"
+	ErrorCodeReadOnly           = "read_only"
+	ErrorCodeAlreadyExists      = "already_exists"
+	ErrorCodeOffsetMismatch     = "offset_mismatch"
+	ErrorCodeUploadBusy         = "upload_busy"
+	ErrorCodeTooManyUploads     = "too_many_uploads"
+	ErrorCodeTooManyWatches     = "too_many_watches"
+	ErrorCodeUnavailable        = "unavailable"
+	ErrorCodeMethodNotAllowed   = "method_not_allowed"
+	ErrorCodeInternal           = "internal_error"
+)
+
+// ErrorResponse is the body of every API error response
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID matches the error to the server's logs
+	RequestID string `json:"requestId,omitempty"`
+	// Details holds extra information specific to the code
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// apiError is an error that knows how it's reported to clients
+type apiError struct {
+	code    string
+	message string
+	details map[string]any
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// newErrorBody returns the body for an error with the request's ID
+func newErrorBody(r *http.Request, code, message string, details map[string]any) ErrorBody {
+	return ErrorBody{Code: code, Message: message, RequestID: requestID(r), Details: details}
+}
+
+// writeError replies with a JSON error that includes the request ID so that
+// reports from users can be matched to the logs
+func writeError(w http.ResponseWriter, r *http.Request, code, message string, status int) {
+	writeErrorDetails(w, r, code, message, status, nil)
+}
+
+// writeErrorDetails is writeError with details
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, code, message string, status int, details map[string]any) {
+	h := w.Header()
+	// Drop headers meant for the response that was going to be written
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Del("Cache-Control")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	resp := ErrorResponse{Error: newErrorBody(r, code, message, details)}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		requestLogger(r).Error("failed to write response", "error", err)
+	}
+}
+
+// writeRequestError replies with err, using its code if it's an apiError and
+// fallback otherwise
+func writeRequestError(w http.ResponseWriter, r *http.Request, err error, fallback string, status int) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeErrorDetails(w, r, apiErr.code, apiErr.message, status, apiErr.details)
+		return
+	}
+	writeError(w, r, fallback, err.Error(), status)
+}
+
+// methodNotAllowed replies with 405 and the methods the route accepts
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeErrorDetails(w, r, ErrorCodeMethodNotAllowed, "Method not allowed", http.StatusMethodNotAllowed,
+		map[string]any{"allowed": allowed})
+}
+
+// internalError logs err and replies with a generic internal server error
+func internalError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	requestLogger(r).Error(message, "error", err)
+	writeError(w, r, ErrorCodeInternal, "Internal server error", http.StatusInternalServerError)
+}
+
+// notFound replies to requests for unknown API routes
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, ErrorCodeNotFound, "No such API endpoint", http.StatusNotFound)
+}
+
+// invalidParameter returns an error for a query parameter with a bad value
+func invalidParameter(name, format string, args ...any) *apiError {
+	return &apiError{
+		code:    ErrorCodeInvalidParameter,
+		message: fmt.Sprintf(format, args...),
+		details: map[string]any{"parameter": name},
+	}
+}
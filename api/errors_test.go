@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// decodeError decodes an error response, failing the test if it isn't one
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) ErrorBody {
+	t.Helper()
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var resp ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	return resp.Error
+}
+
+func TestErrorResponses(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	webassets := fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}
+	s, err := NewServer(webassets)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	token, err := s.sessionManager.CreateSession("alice", "password")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		auth       bool
+		wantStatus int
+		wantCode   string
+		wantDetail string
+	}{
+		{"unknown API route", http.MethodGet, "/api/nope", "", false, http.StatusNotFound, ErrorCodeNotFound, ""},
+		{"wrong method", http.MethodGet, "/api/login", "", false, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "allowed"},
+		{"invalid login body", http.MethodPost, "/api/login", "{", false, http.StatusBadRequest, ErrorCodeInvalidBody, ""},
+		{"invalid credentials", http.MethodPost, "/api/login", `{"username":"alice","password":"wrong"}`, false, http.StatusUnauthorized, ErrorCodeInvalidCredentials, ""},
+		{"no session", http.MethodGet, "/api/files/", "", false, http.StatusUnauthorized, ErrorCodeUnauthenticated, ""},
+		{"invalid characters", http.MethodGet, "/api/files/a%20b", "", true, http.StatusBadRequest, ErrorCodeInvalidPath, ""},
+		{"path too long", http.MethodGet, "/api/files/" + strings.Repeat("a", maxPathLength), "", true, http.StatusBadRequest, ErrorCodePathTooLong, "maxLength"},
+		{"invalid parameter", http.MethodGet, "/api/files/?limit=0", "", true, http.StatusBadRequest, ErrorCodeInvalidParameter, "parameter"},
+		{"missing file", http.MethodGet, "/api/files/missing.txt", "", true, http.StatusNotFound, ErrorCodeNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.auth {
+				req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+			}
+			w := httptest.NewRecorder()
+			s.handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status code = %v, want %v", w.Code, tt.wantStatus)
+			}
+			body := decodeError(t, w)
+			if body.Code != tt.wantCode {
+				t.Errorf("code = %v, want %v", body.Code, tt.wantCode)
+			}
+			if body.Message == "" {
+				t.Error("message is empty")
+			}
+			if body.RequestID == "" || body.RequestID != w.Header().Get(requestIDHeader) {
+				t.Errorf("requestId = %q, want %q", body.RequestID, w.Header().Get(requestIDHeader))
+			}
+			if _, ok := body.Details[tt.wantDetail]; tt.wantDetail != "" && !ok {
+				t.Errorf("details = %v, want %s", body.Details, tt.wantDetail)
+			}
+		})
+	}
+
+	t.Run("web app routes still serve index.html", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/some/page", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<html>") {
+			t.Errorf("got %v %q, want index.html", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	w := httptest.NewRecorder()
+	methodNotAllowed(w, httptest.NewRequest(http.MethodDelete, "/healthz", nil), http.MethodGet, http.MethodHead)
+
+	if got := w.Header().Get("Allow"); got != "GET, HEAD" {
+		t.Errorf("Allow = %q, want %q", got, "GET, HEAD")
+	}
+	if body := decodeError(t, w); body.Code != ErrorCodeMethodNotAllowed {
+		t.Errorf("code = %v, want %v", body.Code, ErrorCodeMethodNotAllowed)
+	}
+}
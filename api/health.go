@@ -0,0 +1,143 @@
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// tlsExpiryThreshold is how long before the certificate expires the
+	// server reports that it isn't ready, so it's rotated out in time
+	tlsExpiryThreshold = 7 * 24 * time.Hour
+
+	HealthStatusOK      = "ok"
+	HealthStatusFail    = "fail"
+	HealthStatusSkipped = "skipped"
+)
+
+// HealthCheck is the result of a single readiness check
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthResponse is returned by /healthz and /readyz
+type HealthResponse struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// healthz handles GET requests to /healthz. It only reports that the process
+// is up and serving requests.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	writeHealth(w, r, HealthResponse{Status: HealthStatusOK})
+}
+
+// readyz handles GET requests to /readyz. It returns 503 unless every check
+// passes.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	resp := HealthResponse{Status: HealthStatusOK, Checks: s.readinessChecks(r, time.Now())}
+	for _, check := range resp.Checks {
+		if check.Status == HealthStatusFail {
+			resp.Status = HealthStatusFail
+		}
+	}
+
+	writeHealth(w, r, resp)
+}
+
+// readinessChecks runs every readiness check. Failure details are logged
+// rather than returned because the endpoint is unauthenticated.
+func (s *Server) readinessChecks(r *http.Request, now time.Time) []HealthCheck {
+	var checks []HealthCheck
+
+	if len(s.mounts) == 0 {
+		checks = append(checks, s.checkListable(r, "rootDir", s.rootDir))
+	}
+	for _, m := range s.mounts {
+		checks = append(checks, s.checkListable(r, "mount:"+m.Name, m.Root))
+	}
+
+	checks = append(checks, checkCertificate(s.tlsCert, now))
+
+	check := HealthCheck{Name: "sessions", Status: HealthStatusOK}
+	if err := s.sessionManager.Ready(); err != nil {
+		check.Status, check.Message = HealthStatusFail, err.Error()
+	}
+	checks = append(checks, check)
+
+	return checks
+}
+
+// checkListable checks that dir exists and can be listed
+func (s *Server) checkListable(r *http.Request, name, dir string) HealthCheck {
+	f, err := os.Open(dir)
+	if err == nil {
+		_, err = f.Readdirnames(1)
+		f.Close()
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		requestLogger(r).Warn("readiness check failed", "check", name, "error", err)
+		return HealthCheck{Name: name, Status: HealthStatusFail, Message: "directory is not listable"}
+	}
+
+	return HealthCheck{Name: name, Status: HealthStatusOK}
+}
+
+// checkCertificate checks that the serving certificate isn't about to expire
+func checkCertificate(cert *tls.Certificate, now time.Time) HealthCheck {
+	check := HealthCheck{Name: "tls"}
+	if cert == nil || cert.Leaf == nil {
+		check.Status, check.Message = HealthStatusSkipped, "TLS is not configured"
+		return check
+	}
+
+	notAfter := cert.Leaf.NotAfter
+	switch {
+	case !now.Before(notAfter):
+		check.Status = HealthStatusFail
+		check.Message = fmt.Sprintf("certificate expired at %s", notAfter.UTC().Format(time.RFC3339))
+	case notAfter.Sub(now) < tlsExpiryThreshold:
+		check.Status = HealthStatusFail
+		check.Message = fmt.Sprintf("certificate expires soon, at %s", notAfter.UTC().Format(time.RFC3339))
+	default:
+		check.Status = HealthStatusOK
+		check.Message = fmt.Sprintf("certificate expires at %s", notAfter.UTC().Format(time.RFC3339))
+	}
+
+	return check
+}
+
+// writeHealth writes resp with 200 if it's ok and 503 otherwise
+func writeHealth(w http.ResponseWriter, r *http.Request, resp HealthResponse) {
+	status := http.StatusOK
+	if resp.Status != HealthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		requestLogger(r).Error("failed to write response", "error", err)
+	}
+}
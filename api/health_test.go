@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	s := &Server{rootDir: t.TempDir(), sessionManager: NewSessionManager()}
+
+	w := httptest.NewRecorder()
+	s.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status code = %v, want %v", w.Code, http.StatusOK)
+	}
+	var resp HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Status != HealthStatusOK {
+		t.Errorf("status = %v, want %v", resp.Status, HealthStatusOK)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	validCert, _ := newTestCertificate(t, time.Now().Add(90*24*time.Hour))
+	expiringCert, _ := newTestCertificate(t, time.Now().Add(24*time.Hour))
+
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, s *Server)
+		wantStatus int
+		wantChecks map[string]string
+	}{
+		{
+			name:       "ready without TLS",
+			setup:      func(t *testing.T, s *Server) {},
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]string{"rootDir": HealthStatusOK, "tls": HealthStatusSkipped, "sessions": HealthStatusOK},
+		},
+		{
+			name:       "ready with TLS",
+			setup:      func(t *testing.T, s *Server) { s.tlsCert = &validCert },
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]string{"rootDir": HealthStatusOK, "tls": HealthStatusOK, "sessions": HealthStatusOK},
+		},
+		{
+			name:       "certificate about to expire",
+			setup:      func(t *testing.T, s *Server) { s.tlsCert = &expiringCert },
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"rootDir": HealthStatusOK, "tls": HealthStatusFail, "sessions": HealthStatusOK},
+		},
+		{
+			name: "root directory removed",
+			setup: func(t *testing.T, s *Server) {
+				s.rootDir = filepath.Join(s.rootDir, "gone")
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"rootDir": HealthStatusFail, "tls": HealthStatusSkipped, "sessions": HealthStatusOK},
+		},
+		{
+			name: "mount not listable",
+			setup: func(t *testing.T, s *Server) {
+				file := filepath.Join(s.rootDir, "file.txt")
+				if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+					t.Fatalf("failed to create file: %v", err)
+				}
+				s.mounts = []*Mount{{Name: "docs", Root: s.rootDir}, {Name: "broken", Root: file}}
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"mount:docs": HealthStatusOK, "mount:broken": HealthStatusFail, "tls": HealthStatusSkipped, "sessions": HealthStatusOK},
+		},
+		{
+			name:       "no users loaded",
+			setup:      func(t *testing.T, s *Server) { s.sessionManager.SetUsers(map[string]*User{}) },
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"rootDir": HealthStatusOK, "tls": HealthStatusSkipped, "sessions": HealthStatusFail},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{rootDir: t.TempDir(), sessionManager: NewSessionManager()}
+			tt.setup(t, s)
+
+			w := httptest.NewRecorder()
+			s.readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status code = %v, want %v", w.Code, tt.wantStatus)
+			}
+
+			var resp HealthResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			got := make(map[string]string)
+			for _, check := range resp.Checks {
+				got[check.Name] = check.Status
+			}
+			if len(got) != len(tt.wantChecks) {
+				t.Errorf("checks = %v, want %v", got, tt.wantChecks)
+			}
+			for name, want := range tt.wantChecks {
+				if got[name] != want {
+					t.Errorf("check %s = %v, want %v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDLength = 8 // 64 bits
+)
+
+// requestInfo carries details about a request for the access log. Handlers
+// further down the chain fill in what they learn, such as the user.
+type requestInfo struct {
+	id   string
+	user string
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	b := make([]byte, requestIDLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate request ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestInfoFromContext returns the requestInfo stored by accessLog
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
+
+// requestID returns the ID of the request, or "" outside of accessLog
+func requestID(r *http.Request) string {
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// requestLogger returns a logger that tags records with the request ID
+func requestLogger(r *http.Request) *slog.Logger {
+	if id := requestID(r); id != "" {
+		return slog.With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// accessLog is a middleware that assigns each request an ID, echoes it in the
+// X-Request-ID header and logs the request once it has been handled
+func (s *Server) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{id: newRequestID()}
+		w.Header().Set(requestIDHeader, info.id)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info)))
+
+		args := []any{
+			"request_id", info.id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"user", info.user,
+			"remote_ip", sourceIP(r),
+		}
+		if sp := spanFromContext(r.Context()); sp != nil {
+			args = append(args, "trace_id", sp.traceID)
+		}
+		slog.Info("request", args...)
+	})
+}
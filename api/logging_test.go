@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	s := &Server{
+		rootDir:        t.TempDir(),
+		sessionManager: NewSessionManager(),
+	}
+	token, err := s.sessionManager.CreateSession("alice", "password")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	handler := s.accessLog(http.HandlerFunc(s.requireAuth(s.getFiles)))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/missing", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	id := w.Header().Get(requestIDHeader)
+	if len(id) != 2*requestIDLength {
+		t.Fatalf("%s = %q, want %d hex characters", requestIDHeader, id, 2*requestIDLength)
+	}
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status code = %v, want %v", w.Code, http.StatusNotFound)
+	}
+	if !strings.Contains(w.Body.String(), id) {
+		t.Errorf("body = %q, want it to contain request ID %q", w.Body.String(), id)
+	}
+
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Method    string `json:"method"`
+		Path      string `json:"path"`
+		Status    int    `json:"status"`
+		Bytes     int64  `json:"bytes"`
+		User      string `json:"user"`
+		RemoteIP  string `json:"remote_ip"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse access log %q: %v", buf.String(), err)
+	}
+
+	if entry.Msg != "request" {
+		t.Errorf("msg = %v, want request", entry.Msg)
+	}
+	if entry.RequestID != id {
+		t.Errorf("request_id = %v, want %v", entry.RequestID, id)
+	}
+	if entry.Method != http.MethodGet || entry.Path != "/api/files/missing" {
+		t.Errorf("request = %v %v, want GET /api/files/missing", entry.Method, entry.Path)
+	}
+	if entry.Status != http.StatusNotFound {
+		t.Errorf("status = %v, want %v", entry.Status, http.StatusNotFound)
+	}
+	if entry.Bytes != int64(w.Body.Len()) {
+		t.Errorf("bytes = %v, want %v", entry.Bytes, w.Body.Len())
+	}
+	if entry.User != "alice" {
+		t.Errorf("user = %v, want alice", entry.User)
+	}
+	if entry.RemoteIP != "192.0.2.1" {
+		t.Errorf("remote_ip = %v, want 192.0.2.1", entry.RemoteIP)
+	}
+}
+
+func TestRequestIDsAreUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := newRequestID()
+		if seen[id] {
+			t.Fatalf("newRequestID() returned duplicate %q", id)
+		}
+		seen[id] = true
+	}
+}
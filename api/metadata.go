@@ -0,0 +1,156 @@
+package api
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mimeSniffLength is how much of a file is read to detect its MIME type when
+// the extension doesn't give it away
+const mimeSniffLength = 512
+
+// fileFields is a set of optional FileInfo fields requested with fields=
+type fileFields uint16
+
+const (
+	fieldModTime fileFields = 1 << iota
+	fieldMode
+	fieldOwner
+	fieldGroup
+	fieldLinks
+	fieldHidden
+	fieldMimeType
+)
+
+// fileFieldNames maps the names accepted by fields= to fields. They match the
+// JSON names in FileInfo.
+var fileFieldNames = map[string]fileFields{
+	"modTime":  fieldModTime,
+	"mode":     fieldMode,
+	"owner":    fieldOwner,
+	"group":    fieldGroup,
+	"links":    fieldLinks,
+	"hidden":   fieldHidden,
+	"mimeType": fieldMimeType,
+}
+
+// parseFileFields parses a comma separated list of optional field names
+func parseFileFields(param string) (fileFields, error) {
+	var fields fileFields
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field, ok := fileFieldNames[name]
+		if !ok {
+			return 0, invalidParameter("fields", "unknown field %q", name)
+		}
+		fields |= field
+	}
+	return fields, nil
+}
+
+// has reports whether field was requested
+func (f fileFields) has(field fileFields) bool {
+	return f&field != 0
+}
+
+// ownerNames caches user and group name lookups for one listing
+type ownerNames struct {
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+func newOwnerNames() *ownerNames {
+	return &ownerNames{users: make(map[uint32]string), groups: make(map[uint32]string)}
+}
+
+func (n *ownerNames) user(uid uint32) string {
+	name, ok := n.users[uid]
+	if !ok {
+		name = lookupUserName(uid)
+		n.users[uid] = name
+	}
+	return name
+}
+
+func (n *ownerNames) group(gid uint32) string {
+	name, ok := n.groups[gid]
+	if !ok {
+		name = lookupGroupName(gid)
+		n.groups[gid] = name
+	}
+	return name
+}
+
+// fillMetadata sets the requested optional fields of fi from the file at
+// path. info must come from Lstat for symlinks to be reported as links.
+func fillMetadata(fi *FileInfo, path string, info os.FileInfo, fields fileFields, names *ownerNames) {
+	if fields.has(fieldModTime) {
+		modTime := info.ModTime().UTC()
+		fi.ModTime = &modTime
+	}
+	if fields.has(fieldMode) {
+		fi.Mode = info.Mode().String()
+	}
+	if fields.has(fieldOwner | fieldGroup | fieldLinks) {
+		if uid, gid, links, ok := fileOwnership(info); ok {
+			if fields.has(fieldOwner) {
+				fi.Owner = names.user(uid)
+			}
+			if fields.has(fieldGroup) {
+				fi.Group = names.group(gid)
+			}
+			if fields.has(fieldLinks) {
+				fi.Links = links
+			}
+		}
+	}
+	if fields.has(fieldHidden) {
+		fi.Hidden = strings.HasPrefix(info.Name(), ".")
+	}
+	if fields.has(fieldMimeType) {
+		fi.MimeType = detectMimeType(path, info)
+	}
+}
+
+// detectMimeType returns the MIME type of the file at path from its extension,
+// falling back to sniffing its contents
+func detectMimeType(path string, info os.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "inode/directory"
+	case info.Mode()&os.ModeSymlink != 0:
+		return "inode/symlink"
+	case !info.Mode().IsRegular():
+		return "application/octet-stream"
+	}
+
+	if mimeType := mime.TypeByExtension(filepath.Ext(path)); mimeType != "" {
+		return mimeType
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+
+	buf := make([]byte, mimeSniffLength)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+// formatID renders a numeric user or group ID when it has no name
+func formatID(id uint32) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package api
+
+import "os"
+
+// fileOwnership isn't supported on this platform
+func fileOwnership(info os.FileInfo) (uid, gid uint32, links uint64, ok bool) {
+	return 0, 0, 0, false
+}
+
+func lookupUserName(uid uint32) string {
+	return formatID(uid)
+}
+
+func lookupGroupName(gid uint32) string {
+	return formatID(gid)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseFileFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   string
+		want    fileFields
+		wantErr bool
+	}{
+		{"empty", "", 0, false},
+		{"single", "modTime", fieldModTime, false},
+		{"several", "mode,owner, group", fieldMode | fieldOwner | fieldGroup, false},
+		{"trailing comma", "hidden,", fieldHidden, false},
+		{"unknown", "modTime,inode", 0, true},
+		{"case sensitive", "modtime", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFileFields(tt.param)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFileFields() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseFileFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFileInfoFields(t *testing.T) {
+	tmpDir := t.TempDir()
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	files := map[string][]byte{
+		"notes.txt": []byte("hello"),
+		".hidden":   []byte("secret"),
+		"image":     []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"),
+	}
+	for name, data := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, data, 0640); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("failed to set mtime of %s: %v", name, err)
+		}
+	}
+	if err := os.Symlink("notes.txt", filepath.Join(tmpDir, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	s := &Server{rootDir: tmpDir}
+	info, err := os.Stat(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to stat test dir: %v", err)
+	}
+
+	t.Run("no fields requested", func(t *testing.T) {
+		fileInfo, err := s.readFileInfo(context.Background(), tmpDir, info, listOptions{root: tmpDir})
+		if err != nil {
+			t.Fatalf("readFileInfo() error = %v", err)
+		}
+		for _, entry := range fileInfo.Contents {
+			if entry.ModTime != nil || entry.Mode != "" || entry.Owner != "" || entry.MimeType != "" {
+				t.Errorf("entry %s has optional fields set: %+v", entry.Name, entry)
+			}
+		}
+	})
+
+	t.Run("all fields requested", func(t *testing.T) {
+		all, err := parseFileFields("modTime,mode,owner,group,links,hidden,mimeType")
+		if err != nil {
+			t.Fatalf("parseFileFields() error = %v", err)
+		}
+		fileInfo, err := s.readFileInfo(context.Background(), tmpDir, info, listOptions{root: tmpDir, fields: all})
+		if err != nil {
+			t.Fatalf("readFileInfo() error = %v", err)
+		}
+
+		if fileInfo.ModTime == nil || fileInfo.MimeType != "inode/directory" {
+			t.Errorf("directory modTime = %v, mimeType = %v, want both set", fileInfo.ModTime, fileInfo.MimeType)
+		}
+
+		entries := make(map[string]*FileInfo)
+		for _, entry := range fileInfo.Contents {
+			entries[entry.Name] = entry
+		}
+
+		notes := entries["notes.txt"]
+		if notes.ModTime == nil || !notes.ModTime.Equal(modTime) {
+			t.Errorf("notes.txt modTime = %v, want %v", notes.ModTime, modTime)
+		}
+		if notes.Mode != "-rw-r-----" {
+			t.Errorf("notes.txt mode = %v, want -rw-r-----", notes.Mode)
+		}
+		if notes.MimeType != "text/plain; charset=utf-8" {
+			t.Errorf("notes.txt mimeType = %v, want text/plain; charset=utf-8", notes.MimeType)
+		}
+		if notes.Hidden {
+			t.Error("notes.txt hidden = true, want false")
+		}
+
+		if runtime.GOOS != "windows" {
+			current, err := user.Current()
+			if err != nil {
+				t.Fatalf("failed to look up current user: %v", err)
+			}
+			if notes.Owner != current.Username {
+				t.Errorf("notes.txt owner = %v, want %v", notes.Owner, current.Username)
+			}
+			if notes.Group == "" {
+				t.Error("notes.txt group is empty")
+			}
+			if notes.Links != 1 {
+				t.Errorf("notes.txt links = %v, want 1", notes.Links)
+			}
+		}
+
+		if !entries[".hidden"].Hidden {
+			t.Error(".hidden hidden = false, want true")
+		}
+		if got := entries["image"].MimeType; got != "image/png" {
+			t.Errorf("image mimeType = %v, want image/png", got)
+		}
+
+		link := entries["link"]
+		if link.Link == nil || link.Link.Target != "/notes.txt" {
+			t.Errorf("link link = %+v, want target /notes.txt", link.Link)
+		}
+		if link.MimeType != "inode/symlink" {
+			t.Errorf("link mimeType = %v, want inode/symlink", link.MimeType)
+		}
+	})
+}
+
+func TestGetFilesUnknownField(t *testing.T) {
+	s := &Server{rootDir: t.TempDir()}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/?fields=modTime,bogus", nil)
+	w := httptest.NewRecorder()
+	s.getFiles(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("getFiles() status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package api
+
+import (
+	"os"
+	"os/user"
+	"syscall"
+)
+
+// fileOwnership returns the owner, group and hard link count of a file
+func fileOwnership(info os.FileInfo) (uid, gid uint32, links uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return stat.Uid, stat.Gid, uint64(stat.Nlink), true
+}
+
+// lookupUserName returns the name of the user with uid, or the ID itself if
+// the user is unknown
+func lookupUserName(uid uint32) string {
+	u, err := user.LookupId(formatID(uid))
+	if err != nil {
+		return formatID(uid)
+	}
+	return u.Username
+}
+
+// lookupGroupName returns the name of the group with gid, or the ID itself if
+// the group is unknown
+func lookupGroupName(gid uint32) string {
+	g, err := user.LookupGroupId(formatID(gid))
+	if err != nil {
+		return formatID(gid)
+	}
+	return g.Name
+}
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	// latencyBuckets are upper bounds in seconds for duration histograms
+	latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// listingSizeBuckets are upper bounds for the number of directory entries
+	listingSizeBuckets = []float64{0, 1, 10, 100, 1000, 10000, 100000, 1000000}
+)
+
+// counterVec is a set of counters partitioned by label values
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// inc adds one to the counter with the given label values
+func (c *counterVec) inc(labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// histogramVec is a set of histograms partitioned by label values
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu         sync.Mutex
+	histograms map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, histograms: make(map[string]*histogram)}
+}
+
+// observe records v in the histogram with the given label values
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.histograms[key]
+	if !ok {
+		hist = &histogram{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+		h.histograms[key] = hist
+	}
+	hist.observe(v)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.histograms) {
+		hist := h.histograms[key]
+		for i, bound := range hist.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", formatFloat(bound)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, hist.count)
+	}
+}
+
+// metrics holds the server's Prometheus metrics. A nil *metrics discards
+// observations so handlers can be tested without one.
+type metrics struct {
+	startTime time.Time
+
+	requests        *counterVec
+	requestDuration *histogramVec
+	logins          *counterVec
+	listingEntries  *histogramVec
+	readDuration    *histogramVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		startTime: time.Now(),
+		requests: newCounterVec("fs4_http_requests_total",
+			"HTTP requests by route, method and status code.", "route", "method", "code"),
+		requestDuration: newHistogramVec("fs4_http_request_duration_seconds",
+			"HTTP request latency by route.", latencyBuckets, "route"),
+		logins: newCounterVec("fs4_logins_total",
+			"Login attempts by result.", "result"),
+		listingEntries: newHistogramVec("fs4_directory_listing_entries",
+			"Number of entries in directory listings.", listingSizeBuckets),
+		readDuration: newHistogramVec("fs4_read_file_info_duration_seconds",
+			"Time spent reading file and directory information.", latencyBuckets),
+	}
+}
+
+// observeRequest records a handled request
+func (m *metrics) observeRequest(route, method string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.inc(route, metricsMethod(method), strconv.Itoa(status))
+	m.requestDuration.observe(duration.Seconds(), route)
+}
+
+// metricsMethod returns the method label for a request. Methods outside the
+// standard set are reported as other, so clients can't add a series for every
+// made up method they send.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// observeLogin records a login attempt
+func (m *metrics) observeLogin(result string) {
+	if m == nil {
+		return
+	}
+	m.logins.inc(result)
+}
+
+// observeRead records a readFileInfo call. entries is negative for files.
+func (m *metrics) observeRead(entries int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.readDuration.observe(duration.Seconds())
+	if entries >= 0 {
+		m.listingEntries.observe(float64(entries))
+	}
+}
+
+// write renders all metrics in the Prometheus text format
+func (m *metrics) write(w io.Writer, sessions *SessionManager, cache *listingCache) {
+	if m == nil {
+		return
+	}
+
+	m.requests.write(w)
+	m.requestDuration.write(w)
+	m.logins.write(w)
+	m.listingEntries.write(w)
+	m.readDuration.write(w)
+
+	if sessions != nil {
+		fmt.Fprintf(w, "# HELP fs4_active_sessions Sessions that have not expired.\n# TYPE fs4_active_sessions gauge\n")
+		fmt.Fprintf(w, "fs4_active_sessions %d\n", sessions.ActiveSessions())
+	}
+	if cache != nil {
+		writeListingCacheMetrics(w, cache.stats())
+	}
+
+	writeRuntimeMetrics(w, m.startTime)
+}
+
+// writeListingCacheMetrics renders the directory listing cache statistics
+func writeListingCacheMetrics(w io.Writer, stats listingCacheStats) {
+	hitRatio := 0.0
+	if lookups := stats.hits + stats.misses; lookups > 0 {
+		hitRatio = float64(stats.hits) / float64(lookups)
+	}
+
+	values := []struct {
+		name  string
+		help  string
+		typ   string
+		value float64
+	}{
+		{"fs4_listing_cache_entries", "Directories in the listing cache.", "gauge", float64(stats.entries)},
+		{"fs4_listing_cache_capacity", "Most directories the listing cache holds.", "gauge", float64(stats.capacity)},
+		{"fs4_listing_cache_watched_directories", "Cached directories watched for changes.", "gauge", float64(stats.watched)},
+		{"fs4_listing_cache_hits_total", "Listings served from the cache.", "counter", float64(stats.hits)},
+		{"fs4_listing_cache_misses_total", "Listings read from disk.", "counter", float64(stats.misses)},
+		{"fs4_listing_cache_hit_ratio", "Fraction of listings served from the cache since startup.", "gauge", hitRatio},
+		{"fs4_listing_cache_evictions_total", "Directories evicted to stay within the capacity.", "counter", float64(stats.evictions)},
+		{"fs4_listing_cache_invalidations_total", "Cached listings dropped because their directory changed.", "counter", float64(stats.invalidations)},
+	}
+
+	for _, v := range values {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", v.name, v.help, v.name, v.typ, v.name, formatFloat(v.value))
+	}
+}
+
+// writeRuntimeMetrics renders Go runtime and process statistics
+func writeRuntimeMetrics(w io.Writer, startTime time.Time) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	gauges := []struct {
+		name  string
+		help  string
+		typ   string
+		value float64
+	}{
+		{"go_goroutines", "Number of goroutines that currently exist.", "gauge", float64(runtime.NumGoroutine())},
+		{"go_memstats_alloc_bytes", "Bytes of allocated heap objects.", "gauge", float64(mem.Alloc)},
+		{"go_memstats_alloc_bytes_total", "Cumulative bytes allocated for heap objects.", "counter", float64(mem.TotalAlloc)},
+		{"go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", "gauge", float64(mem.Sys)},
+		{"go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", "gauge", float64(mem.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated heap objects.", "gauge", float64(mem.HeapObjects)},
+		{"go_gc_cycles_total", "Number of completed GC cycles.", "counter", float64(mem.NumGC)},
+		{"go_gc_pause_seconds_total", "Cumulative time spent in GC stop-the-world pauses.", "counter", float64(mem.PauseTotalNs) / 1e9},
+		{"process_start_time_seconds", "Start time of the process since the Unix epoch in seconds.", "gauge", float64(startTime.UnixNano()) / 1e9},
+	}
+
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", g.name, g.help, g.name, g.typ, g.name, formatFloat(g.value))
+	}
+
+	fmt.Fprintf(w, "# HELP go_info Information about the Go environment.\n# TYPE go_info gauge\n")
+	fmt.Fprintf(w, "go_info%s 1\n", formatLabels([]string{"version"}, []string{runtime.Version()}))
+}
+
+// instrument records request counts and latency for a route
+func (s *Server) instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		s.metrics.observeRequest(route, r.Method, rec.status, time.Since(start))
+	})
+}
+
+// serveMetrics handles GET requests to /metrics
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	s.metrics.write(w, s.sessionManager, s.listingCache)
+}
+
+// MetricsHandler serves metrics without authentication, for use on an
+// internal listener
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(s.serveMetrics)
+}
+
+// requireBearerToken is a middleware that requires an Authorization header
+// carrying token
+func requireBearerToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, ErrorCodeUnauthenticated, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// formatLabels renders label pairs as {a="1",b="2"}, or "" without labels
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + escapeLabelValue(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label to an already formatted label set
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + escapeLabelValue(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return strings.TrimSuffix(labels, "}") + "," + pair + "}"
+}
+
+// escapeLabelValue escapes a label value for the text format
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatFloat renders a sample value
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of m in order so output is stable
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
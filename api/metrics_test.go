@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("test content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	t.Chdir(tmpDir)
+
+	webassets := fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}
+	s, err := NewServer(webassets, WithMetricsToken("secret"))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(LoginRequest{Username: "alice", Password: password})
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return serve(req)
+	}
+
+	login("wrongpassword")
+	w := login("password")
+	serve(httptest.NewRequest("BREW", "/api/login", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	if w := serve(req); w.Code != http.StatusOK {
+		t.Fatalf("list status code = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	t.Run("requires bearer token", func(t *testing.T) {
+		for _, header := range []string{"", "Bearer wrong", "secret"} {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+			if w := serve(req); w.Code != http.StatusUnauthorized {
+				t.Errorf("Authorization %q: status code = %v, want %v", header, w.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("exposes metrics", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := serve(req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Content-Type"); got != metricsContentType {
+			t.Errorf("Content-Type = %v, want %v", got, metricsContentType)
+		}
+
+		body := w.Body.String()
+		for _, want := range []string{
+			`fs4_http_requests_total{route="/api/login",method="POST",code="401"} 1`,
+			`fs4_http_requests_total{route="/api/login",method="POST",code="200"} 1`,
+			`fs4_http_requests_total{route="/api/login",method="other",code="405"} 1`,
+			`fs4_http_requests_total{route="/api/files/",method="GET",code="200"} 1`,
+			`fs4_http_request_duration_seconds_count{route="/api/files/"} 1`,
+			`fs4_logins_total{result="failure"} 1`,
+			`fs4_logins_total{result="success"} 1`,
+			`fs4_active_sessions 1`,
+			`fs4_directory_listing_entries_bucket{le="1"} 1`,
+			`fs4_read_file_info_duration_seconds_count 1`,
+			"# TYPE go_goroutines gauge",
+			"go_info{version=",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("metrics missing %q", want)
+			}
+		}
+	})
+
+	t.Run("handler for internal listener", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+	})
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Test.", []float64{1, 5}, "route")
+	for _, v := range []float64{0.5, 2, 10} {
+		h.observe(v, `a"b`)
+	}
+
+	var buf bytes.Buffer
+	h.write(&buf)
+
+	want := `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="a\"b",le="1"} 1
+test_seconds_bucket{route="a\"b",le="5"} 2
+test_seconds_bucket{route="a\"b",le="+Inf"} 3
+test_seconds_sum{route="a\"b"} 12.5
+test_seconds_count{route="a\"b"} 3
+`
+	if buf.String() != want {
+		t.Errorf("write() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteListingCacheMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	writeListingCacheMetrics(&buf, listingCacheStats{entries: 3, capacity: 10, hits: 3, misses: 1})
+
+	for _, want := range []string{
+		"fs4_listing_cache_entries 3\n",
+		"fs4_listing_cache_capacity 10\n",
+		"fs4_listing_cache_hits_total 3\n",
+		"fs4_listing_cache_misses_total 1\n",
+		"fs4_listing_cache_hit_ratio 0.75\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("write() missing %q in\n%s", want, buf.String())
+		}
+	}
+}
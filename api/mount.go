@@ -0,0 +1,240 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// mountNameRegex allows the same characters as paths, without /
+	mountNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.\-@]+$`)
+
+	errMountNotFound = errors.New("mount does not exist")
+)
+
+// Mount is a named directory served under /api/files/<name>/
+type Mount struct {
+	Name     string
+	Root     string
+	ReadOnly bool
+	ACL      *ACL
+}
+
+// ParseMountSpec parses a mount from the form name=dir[,ro][,acl=file]
+func ParseMountSpec(spec string) (*Mount, error) {
+	name, rest, ok := strings.Cut(spec, "=")
+	if !ok {
+		return nil, fmt.Errorf("mount %q must have the form name=dir[,ro][,acl=file]", spec)
+	}
+
+	options := strings.Split(rest, ",")
+	m := &Mount{Name: name, Root: options[0]}
+
+	for _, option := range options[1:] {
+		switch {
+		case option == "ro":
+			m.ReadOnly = true
+		case strings.HasPrefix(option, "acl="):
+			acl, err := LoadACL(strings.TrimPrefix(option, "acl="))
+			if err != nil {
+				return nil, fmt.Errorf("mount %q: %w", name, err)
+			}
+			m.ACL = acl
+		default:
+			return nil, fmt.Errorf("mount %q: unknown option %q", name, option)
+		}
+	}
+
+	return m, nil
+}
+
+// WithMounts serves each mount as a top level directory instead of serving the
+// working directory. Mounts without their own ACL use the server ACL.
+func WithMounts(mounts ...*Mount) Option {
+	return func(s *Server) error {
+		seen := make(map[string]bool, len(mounts))
+		for _, m := range mounts {
+			if !mountNameRegex.MatchString(m.Name) || m.Name == "." || m.Name == ".." {
+				return fmt.Errorf("invalid mount name %q", m.Name)
+			}
+			if seen[m.Name] {
+				return fmt.Errorf("duplicate mount name %q", m.Name)
+			}
+			seen[m.Name] = true
+
+			root, err := filepath.Abs(m.Root)
+			if err != nil {
+				return fmt.Errorf("mount %q: failed to get absolute path: %w", m.Name, err)
+			}
+			info, err := os.Stat(root)
+			if err != nil {
+				return fmt.Errorf("mount %q: %w", m.Name, err)
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("mount %q: %s is not a directory", m.Name, root)
+			}
+			m.Root = root
+		}
+
+		s.mounts = append(s.mounts, mounts...)
+		return nil
+	}
+}
+
+// mountACL returns the access rules that apply to the mount
+func (s *Server) mountACL(m *Mount) *ACL {
+	if m.ACL != nil {
+		return m.ACL
+	}
+	return s.acl
+}
+
+// splitMount finds the mount that urlPath is in and the path inside it. When
+// no mounts are configured the whole path is inside an implicit mount of the
+// root directory. A nil mount means urlPath is the list of mounts.
+func (s *Server) splitMount(urlPath string) (*Mount, string, error) {
+	if len(s.mounts) == 0 {
+		return &Mount{Root: s.rootDir}, urlPath, nil
+	}
+
+	cleanPath := path.Clean("/" + urlPath)
+	if cleanPath == "/" {
+		return nil, cleanPath, nil
+	}
+
+	name, rest, _ := strings.Cut(strings.TrimPrefix(cleanPath, "/"), "/")
+	for _, m := range s.mounts {
+		if m.Name == name {
+			return m, "/" + rest, nil
+		}
+	}
+
+	return nil, "", errMountNotFound
+}
+
+// locate finds the mount that a user's urlPath is in, the directory the path
+// must be resolved in and the path relative to that directory. A nil mount
+// means urlPath is the list of mounts.
+func (s *Server) locate(username, urlPath string) (*Mount, string, string, error) {
+	home, err := s.userHome(username)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if home == "" {
+		m, relPath, err := s.splitMount(urlPath)
+		if err != nil || m == nil {
+			return m, "", relPath, err
+		}
+		return m, m.Root, relPath, nil
+	}
+
+	// Users jailed to a home directory see it as their root
+	m, homePath, err := s.splitMount(home)
+	if err != nil || m == nil {
+		return nil, "", "", fmt.Errorf("home directory is not inside a mount")
+	}
+
+	// The home directory gets the same checks as any requested path
+	homeDir, err := resolvePathIn(m.Root, homePath)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid home directory: %w", err)
+	}
+
+	info, err := os.Stat(homeDir)
+	if err != nil || !info.IsDir() {
+		return nil, "", "", fmt.Errorf("home directory is not available")
+	}
+
+	return m, homeDir, urlPath, nil
+}
+
+// userHome returns the home directory a user is jailed to, or "" if the user
+// can see the whole tree
+func (s *Server) userHome(username string) (string, error) {
+	if !s.userHomes {
+		return "", nil
+	}
+
+	user, exists := s.sessionManager.User(username)
+	if !exists {
+		return "", fmt.Errorf("unknown user")
+	}
+	if user.HasRole(RoleAdmin) {
+		return "", nil
+	}
+	if user.Home == "" {
+		return "", fmt.Errorf("no home directory configured")
+	}
+	if err := s.validatePath(user.Home); err != nil {
+		return "", fmt.Errorf("invalid home directory: %w", err)
+	}
+
+	return user.Home, nil
+}
+
+// listMounts returns the mounts visible to a user as a directory listing
+func (s *Server) listMounts(username string) *FileInfo {
+	fileInfo := &FileInfo{
+		Name:     "/",
+		Type:     "directory",
+		Contents: make([]*FileInfo, 0, len(s.mounts)),
+	}
+
+	for _, m := range s.mounts {
+		if !s.mountACL(m).Allowed(username, "/") {
+			continue
+		}
+		fileInfo.Contents = append(fileInfo.Contents, &FileInfo{
+			Name:     m.Name,
+			Type:     "directory",
+			ReadOnly: m.ReadOnly,
+		})
+	}
+
+	return fileInfo
+}
+
+// relativePath returns fullPath as a slash separated path relative to the
+// mount root. fullPath may be relative to either the root as configured or the
+// root with its own symlinks resolved.
+func (m *Mount) relativePath(fullPath string) string {
+	rootDir, err := filepath.Abs(m.Root)
+	if err != nil {
+		return "/"
+	}
+
+	relPath, err := filepath.Rel(rootDir, fullPath)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		if resolvedRoot, err := filepath.EvalSymlinks(rootDir); err == nil {
+			relPath, err = filepath.Rel(resolvedRoot, fullPath)
+			if err != nil {
+				return "/"
+			}
+		}
+	}
+
+	return path.Clean("/" + filepath.ToSlash(relPath))
+}
+
+// allowed reports whether the user may see both the requested path and the
+// path it resolved to
+func (s *Server) allowed(m *Mount, username, requestedPath, fullPath string) bool {
+	acl := s.mountACL(m)
+	return acl.Allowed(username, m.relativePath(requestedPath)) && acl.Allowed(username, m.relativePath(fullPath))
+}
+
+// visibleEntries returns a function reporting whether the user may see an
+// entry of the directory at fullPath. Files still being uploaded are never
+// visible.
+func (s *Server) visibleEntries(m *Mount, username, fullPath string) func(name string) bool {
+	acl := s.mountACL(m)
+	dirPath := m.relativePath(fullPath)
+	return func(name string) bool {
+		return !strings.HasPrefix(name, uploadTempPrefix) && acl.Allowed(username, path.Join(dirPath, name))
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMountSpec(t *testing.T) {
+	aclFile := writeACLFile(t, t.TempDir(), "/private deny all")
+
+	tests := []struct {
+		name         string
+		spec         string
+		wantErr      bool
+		wantName     string
+		wantRoot     string
+		wantReadOnly bool
+		wantACL      bool
+	}{
+		{
+			name:     "name and directory",
+			spec:     "logs=/var/log/app",
+			wantName: "logs",
+			wantRoot: "/var/log/app",
+		},
+		{
+			name:         "read only",
+			spec:         "builds=/srv/artifacts,ro",
+			wantName:     "builds",
+			wantRoot:     "/srv/artifacts",
+			wantReadOnly: true,
+		},
+		{
+			name:     "with ACL",
+			spec:     "builds=/srv/artifacts,acl=" + aclFile,
+			wantName: "builds",
+			wantRoot: "/srv/artifacts",
+			wantACL:  true,
+		},
+		{
+			name:    "missing directory",
+			spec:    "logs",
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			spec:    "logs=/var/log/app,rw",
+			wantErr: true,
+		},
+		{
+			name:    "missing ACL file",
+			spec:    "logs=/var/log/app,acl=/does/not/exist",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMountSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMountSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if m.Name != tt.wantName || m.Root != tt.wantRoot || m.ReadOnly != tt.wantReadOnly || (m.ACL != nil) != tt.wantACL {
+				t.Errorf("ParseMountSpec() = %+v", m)
+			}
+		})
+	}
+}
+
+func TestWithMounts(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("test content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		mounts  []*Mount
+		wantErr bool
+	}{
+		{
+			name:   "valid mount",
+			mounts: []*Mount{{Name: "logs", Root: tmpDir}},
+		},
+		{
+			name:    "name with slash",
+			mounts:  []*Mount{{Name: "a/b", Root: tmpDir}},
+			wantErr: true,
+		},
+		{
+			name:    "dot dot name",
+			mounts:  []*Mount{{Name: "..", Root: tmpDir}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			mounts:  []*Mount{{Name: "logs", Root: tmpDir}, {Name: "logs", Root: tmpDir}},
+			wantErr: true,
+		},
+		{
+			name:    "root is a file",
+			mounts:  []*Mount{{Name: "logs", Root: testFile}},
+			wantErr: true,
+		},
+		{
+			name:    "root does not exist",
+			mounts:  []*Mount{{Name: "logs", Root: filepath.Join(tmpDir, "missing")}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := WithMounts(tt.mounts...)(&Server{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("WithMounts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetFilesWithMounts(t *testing.T) {
+	tmpDir := t.TempDir()
+	logsDir := filepath.Join(tmpDir, "logs")
+	buildsDir := filepath.Join(tmpDir, "builds")
+
+	for _, dir := range []string{filepath.Join(logsDir, "app"), filepath.Join(buildsDir, "private")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+
+	// Mounts are confined to their own root even when they share a parent
+	if err := os.Symlink(buildsDir, filepath.Join(logsDir, "builds")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	acl, err := LoadACL(writeACLFile(t, tmpDir, "/private deny all except alice"))
+	if err != nil {
+		t.Fatalf("LoadACL() error = %v", err)
+	}
+
+	s := &Server{}
+	err = WithMounts(
+		&Mount{Name: "logs", Root: logsDir},
+		&Mount{Name: "builds", Root: buildsDir, ReadOnly: true, ACL: acl},
+	)(s)
+	if err != nil {
+		t.Fatalf("WithMounts() error = %v", err)
+	}
+
+	getAs := func(user, urlPath string) (*httptest.ResponseRecorder, *FileInfo) {
+		req := httptest.NewRequest(http.MethodGet, urlPath, nil)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		w := httptest.NewRecorder()
+		s.getFiles(w, req)
+
+		var fileInfo FileInfo
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&fileInfo); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+		}
+		return w, &fileInfo
+	}
+
+	t.Run("root lists mounts", func(t *testing.T) {
+		w, fileInfo := getAs("bob", "/api/files/")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+		if len(fileInfo.Contents) != 2 {
+			t.Fatalf("len(fileInfo.Contents) = %v, want %v", len(fileInfo.Contents), 2)
+		}
+		if fileInfo.Contents[0].Name != "logs" || fileInfo.Contents[0].ReadOnly {
+			t.Errorf("first mount = %+v, want writable logs", fileInfo.Contents[0])
+		}
+		if fileInfo.Contents[1].Name != "builds" || !fileInfo.Contents[1].ReadOnly {
+			t.Errorf("second mount = %+v, want read only builds", fileInfo.Contents[1])
+		}
+	})
+
+	t.Run("list inside a mount", func(t *testing.T) {
+		w, fileInfo := getAs("bob", "/api/files/logs")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+		if fileInfo.Name != "logs" || len(fileInfo.Contents) != 2 {
+			t.Errorf("fileInfo = %+v, want logs listing", fileInfo)
+		}
+	})
+
+	t.Run("unknown mount", func(t *testing.T) {
+		if w, _ := getAs("bob", "/api/files/missing"); w.Code != http.StatusNotFound {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("symlink to another mount is refused", func(t *testing.T) {
+		if w, _ := getAs("bob", "/api/files/logs/builds"); w.Code != http.StatusForbidden {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("traversal stays in the virtual root", func(t *testing.T) {
+		if w, _ := getAs("bob", "/api/files/logs/../../builds"); w.Code != http.StatusOK {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("mount ACL hides entries", func(t *testing.T) {
+		_, fileInfo := getAs("bob", "/api/files/builds")
+		if len(fileInfo.Contents) != 0 {
+			t.Errorf("contents = %+v, want none", fileInfo.Contents)
+		}
+
+		if w, _ := getAs("bob", "/api/files/builds/private"); w.Code != http.StatusNotFound {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusNotFound)
+		}
+		if w, _ := getAs("alice", "/api/files/builds/private"); w.Code != http.StatusOK {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+	})
+}
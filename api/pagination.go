@@ -0,0 +1,287 @@
+package api
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 10000
+
+	sortByName  = "name"
+	sortByType  = "type"
+	sortBySize  = "size"
+	sortByMtime = "mtime"
+)
+
+// PageInfo describes which part of a directory a listing contains
+type PageInfo struct {
+	// Total is the number of entries in the directory the user can see
+	Total int `json:"total"`
+	// Matched is the number of those entries that match the filter
+	Matched int `json:"matched"`
+	// Truncated is set if there are more matching entries after this page
+	Truncated bool `json:"truncated"`
+	// NextCursor fetches the next page when passed as cursor=
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// pageQuery selects, orders and limits the entries of a listing
+type pageQuery struct {
+	sort   string
+	desc   bool
+	filter string
+	// limit is the most entries on a page, or 0 for every entry. Listings
+	// are only paginated when the client asks, so that clients that don't
+	// follow cursors still see whole directories.
+	limit int
+	after *listCursor
+}
+
+// listCursor is the position of the last entry on a page. It records the
+// query it belongs to so it can't be reused with a different order.
+type listCursor struct {
+	Sort   string  `json:"s"`
+	Desc   bool    `json:"d,omitempty"`
+	Filter string  `json:"f,omitempty"`
+	Key    sortKey `json:"k"`
+}
+
+// sortKey holds the values an entry is ordered by. Names are unique within a
+// directory so keys give a total order and pages stay stable while the
+// directory changes.
+type sortKey struct {
+	Name    string `json:"n"`
+	Type    string `json:"t,omitempty"`
+	Size    int64  `json:"z,omitempty"`
+	ModTime int64  `json:"m,omitempty"`
+}
+
+// parsePageQuery parses the limit, cursor, sort, order and filter parameters
+func parsePageQuery(params url.Values) (*pageQuery, error) {
+	q := &pageQuery{
+		sort:   sortByName,
+		filter: params.Get("filter"),
+	}
+
+	if sort := params.Get("sort"); sort != "" {
+		switch sort {
+		case sortByName, sortByType, sortBySize, sortByMtime:
+			q.sort = sort
+		default:
+			return nil, invalidParameter("sort", "sort must be one of name, type, size or mtime")
+		}
+	}
+
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return nil, invalidParameter("order", "order must be asc or desc")
+	}
+
+	if isGlob(q.filter) {
+		if _, err := path.Match(q.filter, ""); err != nil {
+			return nil, invalidParameter("filter", "invalid filter pattern %q", q.filter)
+		}
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxListLimit {
+			return nil, invalidParameter("limit", "limit must be between 1 and %d", maxListLimit)
+		}
+		q.limit = n
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := decodeListCursor(cursor)
+		if err != nil {
+			return nil, invalidParameter("cursor", "invalid cursor")
+		}
+		if after.Sort != q.sort || after.Desc != q.desc || after.Filter != q.filter {
+			return nil, invalidParameter("cursor", "cursor belongs to a listing with a different sort, order or filter")
+		}
+		q.after = after
+		if q.limit == 0 {
+			q.limit = defaultListLimit
+		}
+	}
+
+	return q, nil
+}
+
+// apply filters and sorts entries and returns the requested page
+func (q *pageQuery) apply(entries []*FileInfo) ([]*FileInfo, *PageInfo) {
+	page := &PageInfo{Total: len(entries)}
+
+	matched := entries
+	if q.filter != "" {
+		matched = make([]*FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			if q.matches(entry.Name) {
+				matched = append(matched, entry)
+			}
+		}
+	}
+	page.Matched = len(matched)
+
+	slices.SortFunc(matched, func(a, b *FileInfo) int {
+		return q.compare(entrySortKey(a), entrySortKey(b))
+	})
+
+	start := 0
+	if q.after != nil {
+		start, _ = slices.BinarySearchFunc(matched, q.after.Key, func(entry *FileInfo, key sortKey) int {
+			if q.compare(entrySortKey(entry), key) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+
+	end := len(matched)
+	if q.limit > 0 {
+		end = min(start+q.limit, end)
+	}
+	if end < len(matched) {
+		page.Truncated = true
+		page.NextCursor = encodeListCursor(&listCursor{
+			Sort:   q.sort,
+			Desc:   q.desc,
+			Filter: q.filter,
+			Key:    entrySortKey(matched[end-1]),
+		})
+	}
+
+	return matched[start:end], page
+}
+
+// matches reports whether name matches the filter, as a glob if it has glob
+// characters and as a case insensitive substring otherwise
+func (q *pageQuery) matches(name string) bool {
+	if isGlob(q.filter) {
+		ok, _ := path.Match(q.filter, name)
+		return ok
+	}
+	return strings.Contains(strings.ToLower(name), strings.ToLower(q.filter))
+}
+
+// compare orders two keys by the sort field, then by name
+func (q *pageQuery) compare(a, b sortKey) int {
+	var c int
+	switch q.sort {
+	case sortByType:
+		c = cmp.Compare(typeRank(a.Type), typeRank(b.Type))
+	case sortBySize:
+		c = cmp.Compare(a.Size, b.Size)
+	case sortByMtime:
+		c = cmp.Compare(a.ModTime, b.ModTime)
+	}
+	if c == 0 {
+		c = naturalCompare(a.Name, b.Name)
+	}
+	if c == 0 {
+		c = strings.Compare(a.Name, b.Name)
+	}
+
+	if q.desc {
+		return -c
+	}
+	return c
+}
+
+func entrySortKey(entry *FileInfo) sortKey {
+	key := sortKey{Name: entry.Name, Type: entry.Type, Size: entry.Size}
+	if entry.info != nil {
+		key.ModTime = entry.info.ModTime().UnixNano()
+	}
+	return key
+}
+
+// typeRank orders directories before files and files before links
+func typeRank(typ string) int {
+	switch typ {
+	case "directory":
+		return 0
+	case "file":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// naturalCompare compares names case insensitively, treating runs of digits
+// as numbers so file2 comes before file10
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			var numA, numB string
+			numA, a = splitDigits(a)
+			numB, b = splitDigits(b)
+			numA = strings.TrimLeft(numA, "0")
+			numB = strings.TrimLeft(numB, "0")
+			if c := cmp.Compare(len(numA), len(numB)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(numA, numB); c != 0 {
+				return c
+			}
+			continue
+		}
+
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+		if c := cmp.Compare(unicode.ToLower(ra), unicode.ToLower(rb)); c != 0 {
+			return c
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+
+	return cmp.Compare(len(a), len(b))
+}
+
+// splitDigits splits s after its leading run of ASCII digits
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+func encodeListCursor(c *listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNaturalCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"file2", "file10", -1},
+		{"file10", "file2", 1},
+		{"File1", "file2", -1},
+		{"a", "B", -1},
+		{"img007", "img7", 0},
+		{"v1.10", "v1.9", 1},
+		{"abc", "abcd", -1},
+		{"", "a", -1},
+	}
+
+	for _, tt := range tests {
+		if got := naturalCompare(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalCompare(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParsePageQuery(t *testing.T) {
+	cursor := encodeListCursor(&listCursor{Sort: sortBySize, Key: sortKey{Name: "a"}})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantLimit int
+		wantErr   bool
+	}{
+		{"defaults", "", 0, false},
+		{"all parameters", "sort=mtime&order=desc&filter=*.go&limit=10", 10, false},
+		{"unknown sort", "sort=owner", 0, true},
+		{"unknown order", "order=up", 0, true},
+		{"zero limit", "limit=0", 0, true},
+		{"limit too large", "limit=10001", 0, true},
+		{"bad glob", "filter=[", 0, true},
+		{"garbage cursor", "cursor=!!!", 0, true},
+		{"cursor for a different sort", "cursor=" + cursor, 0, true},
+		{"matching cursor", "sort=size&cursor=" + cursor, defaultListLimit, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, _ := url.ParseQuery(tt.query)
+			q, err := parsePageQuery(params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePageQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && q.limit != tt.wantLimit {
+				t.Errorf("parsePageQuery() limit = %v, want %v", q.limit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestGetFilesPagination(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]int{
+		"file1.txt":  30,
+		"file2.txt":  10,
+		"file10.txt": 20,
+		"notes.md":   40,
+		"Readme.md":  5,
+	}
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "zdir"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	s := &Server{rootDir: tmpDir}
+	list := func(t *testing.T, query string) FileInfo {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.getFiles(w, httptest.NewRequest(http.MethodGet, "/api/files/?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+		}
+		var fileInfo FileInfo
+		if err := json.NewDecoder(w.Body).Decode(&fileInfo); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return fileInfo
+	}
+	names := func(fileInfo FileInfo) string {
+		var names []string
+		for _, entry := range fileInfo.Contents {
+			names = append(names, entry.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		want        string
+		wantMatched int
+	}{
+		{"natural name order", "", "file1.txt,file2.txt,file10.txt,notes.md,Readme.md,zdir", 6},
+		{"descending", "order=desc", "zdir,Readme.md,notes.md,file10.txt,file2.txt,file1.txt", 6},
+		{"by size", "sort=size", "zdir,Readme.md,file2.txt,file10.txt,file1.txt,notes.md", 6},
+		{"by type", "sort=type", "zdir,file1.txt,file2.txt,file10.txt,notes.md,Readme.md", 6},
+		{"substring filter is case insensitive", "filter=README", "Readme.md", 1},
+		{"glob filter", "filter=file*.txt&order=desc", "file10.txt,file2.txt,file1.txt", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileInfo := list(t, tt.query)
+			if got := names(fileInfo); got != tt.want {
+				t.Errorf("contents = %v, want %v", got, tt.want)
+			}
+			if fileInfo.Page == nil {
+				t.Fatal("page is nil")
+			}
+			if fileInfo.Page.Total != 6 || fileInfo.Page.Matched != tt.wantMatched || fileInfo.Page.Truncated {
+				t.Errorf("page = %+v, want total 6, matched %d, not truncated", fileInfo.Page, tt.wantMatched)
+			}
+		})
+	}
+
+	t.Run("pages are stable while the directory changes", func(t *testing.T) {
+		first := list(t, "limit=2")
+		if got := names(first); got != "file1.txt,file2.txt" {
+			t.Fatalf("first page = %v", got)
+		}
+		if !first.Page.Truncated || first.Page.NextCursor == "" {
+			t.Fatalf("first page = %+v, want truncated with a cursor", first.Page)
+		}
+
+		// Entries added before and after the cursor position
+		for _, name := range []string{"file0.txt", "file3.txt"} {
+			if err := os.WriteFile(filepath.Join(tmpDir, name), nil, 0644); err != nil {
+				t.Fatalf("failed to create %s: %v", name, err)
+			}
+		}
+
+		second := list(t, "limit=2&cursor="+first.Page.NextCursor)
+		if got := names(second); got != "file3.txt,file10.txt" {
+			t.Errorf("second page = %v, want file3.txt,file10.txt", got)
+		}
+
+		var rest []string
+		cursor := second.Page.NextCursor
+		for cursor != "" {
+			page := list(t, "limit=2&cursor="+cursor)
+			rest = append(rest, names(page))
+			cursor = page.Page.NextCursor
+		}
+		if got := strings.Join(rest, ","); got != "notes.md,Readme.md,zdir" {
+			t.Errorf("remaining pages = %v, want notes.md,Readme.md,zdir", got)
+		}
+	})
+}
+
+func TestPageQueryUnlimited(t *testing.T) {
+	entries := make([]*FileInfo, defaultListLimit+1)
+	for i := range entries {
+		entries[i] = &FileInfo{Name: "file" + strconv.Itoa(i), Type: "file"}
+	}
+
+	q, err := parsePageQuery(url.Values{})
+	if err != nil {
+		t.Fatalf("parsePageQuery() error = %v", err)
+	}
+	got, page := q.apply(entries)
+	if len(got) != len(entries) || page.Truncated {
+		t.Errorf("apply() = %d entries, truncated %v, want all %d", len(got), page.Truncated, len(entries))
+	}
+}
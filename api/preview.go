@@ -0,0 +1,437 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	defaultPreviewBytes = 64 << 10
+	maxPreviewBytes     = 1 << 20
+	defaultPreviewLines = 100
+	maxPreviewLines     = 10000
+
+	// previewSniffLen is how much of a file is read to detect its encoding
+	previewSniffLen = 8 << 10
+	// previewScanLimit is how far into a file lines are counted. Larger
+	// files are previewed without a line count.
+	previewScanLimit = 16 << 20
+	// maxControlRatio is the share of control characters above which a file
+	// that isn't valid UTF-8 is considered binary
+	maxControlRatio = 0.05
+
+	encodingUTF8    = "utf-8"
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+	encodingLatin1  = "iso-8859-1"
+
+	// The second code unit of a UTF-16 surrogate pair is in this range
+	lowSurrogateStart = 0xdc00
+	lowSurrogateEnd   = 0xdfff
+
+	lineEndingLF    = "lf"
+	lineEndingCRLF  = "crlf"
+	lineEndingCR    = "cr"
+	lineEndingMixed = "mixed"
+)
+
+var (
+	// errBinaryFile is returned for files that don't look like text
+	errBinaryFile = &apiError{code: ErrorCodeBinaryFile, message: "file is not text"}
+
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// TextPreview is the start of a text file, or a window of its lines
+type TextPreview struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Encoding is the file's detected encoding. Content is always UTF-8.
+	Encoding string `json:"encoding"`
+	// LineEnding is lf, crlf, cr or mixed, or empty if no line has ended
+	LineEnding string `json:"lineEnding,omitempty"`
+	// LineCount is the number of lines in the file, or nil if the file is too
+	// large to count them
+	LineCount *int `json:"lineCount,omitempty"`
+	// StartLine is the number of the first line in Content, counting from 1
+	StartLine int    `json:"startLine"`
+	Content   string `json:"content"`
+	// Truncated is set if the file continues after Content
+	Truncated bool `json:"truncated"`
+}
+
+// previewQuery selects what part of a file is previewed
+type previewQuery struct {
+	// maxBytes limits the length of the content
+	maxBytes int
+	// start is the first line to include
+	start int
+	// lines is how many lines to include, or 0 for as many as fit
+	lines int
+}
+
+// parsePreviewQuery parses the bytes, start and lines parameters
+func parsePreviewQuery(params url.Values) (previewQuery, error) {
+	q := previewQuery{maxBytes: defaultPreviewBytes, start: 1}
+
+	if s := params.Get("bytes"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPreviewBytes {
+			return q, invalidParameter("bytes", "bytes must be between 1 and %d", maxPreviewBytes)
+		}
+		q.maxBytes = n
+	}
+
+	// A line window is returned if either of its parameters is set
+	if params.Has("start") || params.Has("lines") {
+		q.lines = defaultPreviewLines
+		if !params.Has("bytes") {
+			q.maxBytes = maxPreviewBytes
+		}
+	}
+	if s := params.Get("start"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return q, invalidParameter("start", "start must be a line number from 1")
+		}
+		q.start = n
+	}
+	if s := params.Get("lines"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPreviewLines {
+			return q, invalidParameter("lines", "lines must be between 1 and %d", maxPreviewLines)
+		}
+		q.lines = n
+	}
+
+	return q, nil
+}
+
+// readPreview reads the part of the text file f that q selects. Lines are
+// counted up to previewScanLimit. It returns errBinaryFile if the file doesn't
+// look like text.
+func readPreview(f io.Reader, info os.FileInfo, q previewQuery) (*TextPreview, error) {
+	limited := &io.LimitedReader{R: f, N: previewScanLimit}
+	br := bufio.NewReaderSize(limited, previewSniffLen)
+	sample, err := br.Peek(previewSniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	encoding, bomLen, err := detectEncoding(sample, len(sample) < previewSniffLen)
+	if err != nil {
+		return nil, err
+	}
+	br.Discard(bomLen)
+
+	var text io.Reader = br
+	switch encoding {
+	case encodingUTF16LE:
+		text = &utf16Reader{r: br, order: binary.LittleEndian}
+	case encodingUTF16BE:
+		text = &utf16Reader{r: br, order: binary.BigEndian}
+	case encodingLatin1:
+		text = &latin1Reader{r: br}
+	}
+
+	// Only count every line if the whole file can be read
+	countAll := info.Size() <= previewScanLimit
+	preview, err := scanPreview(text, q, countAll)
+	if err != nil {
+		return nil, err
+	}
+	preview.Name = info.Name()
+	preview.Size = info.Size()
+	preview.Encoding = encoding
+
+	if !countAll {
+		preview.LineCount = nil
+		if limited.N == 0 {
+			// Reading stopped at the limit rather than the end of the file
+			if preview.StartLine > preview.lastLine {
+				return nil, invalidParameter("start", "start is beyond the first %d bytes of the file", previewScanLimit)
+			}
+			preview.Truncated = true
+		}
+	}
+
+	return &preview.TextPreview, nil
+}
+
+// detectEncoding guesses the encoding of text from a sample of its start,
+// returning the length of its byte order mark. complete is set if the sample
+// is the whole file. It returns errBinaryFile for data that isn't text.
+func detectEncoding(sample []byte, complete bool) (string, int, error) {
+	switch {
+	case bytes.HasPrefix(sample, bomUTF8):
+		return encodingUTF8, len(bomUTF8), nil
+	case bytes.HasPrefix(sample, bomUTF16LE):
+		return encodingUTF16LE, len(bomUTF16LE), nil
+	case bytes.HasPrefix(sample, bomUTF16BE):
+		return encodingUTF16BE, len(bomUTF16BE), nil
+	}
+
+	// Text other than UTF-16 never has NUL bytes
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return "", 0, errBinaryFile
+	}
+
+	if !complete {
+		sample = trimPartialRune(sample)
+	}
+	if utf8.Valid(sample) {
+		return encodingUTF8, 0, nil
+	}
+
+	// Anything else is treated as Latin-1, unless it's mostly control
+	// characters
+	controls := 0
+	for _, b := range sample {
+		if (b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\v' && b != 0x1b) || b == 0x7f {
+			controls++
+		}
+	}
+	if float64(controls) > maxControlRatio*float64(len(sample)) {
+		return "", 0, errBinaryFile
+	}
+	return encodingLatin1, 0, nil
+}
+
+// trimPartialRune removes an incomplete UTF-8 sequence from the end of b
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// scannedPreview is a preview with where the scan ended
+type scannedPreview struct {
+	TextPreview
+	// lastLine is the number of the last line read
+	lastLine int
+}
+
+// scanPreview reads the UTF-8 text r and collects the lines q selects. If
+// countAll is set it reads to the end to count every line, otherwise it stops
+// after the selected lines.
+func scanPreview(r io.Reader, q previewQuery, countAll bool) (*scannedPreview, error) {
+	p := &scannedPreview{TextPreview: TextPreview{StartLine: q.start}}
+	var content []byte
+	var lf, crlf, cr int
+
+	// line is the number of the line the next byte belongs to
+	line := 1
+	// atLineStart is set if nothing has been read since the last line ended
+	atLineStart := true
+	// A CR only ends a line by itself if it isn't followed by LF
+	pendingCR := false
+	full := false
+
+	// add appends b to the content if it's selected, and reports whether
+	// scanning should go on
+	add := func(b byte) bool {
+		if line < q.start {
+			return true
+		}
+		if !full && (q.lines == 0 || line < q.start+q.lines) && len(content) < q.maxBytes {
+			content = append(content, b)
+			return true
+		}
+		full = true
+		p.Truncated = true
+		return countAll
+	}
+	finish := func() *scannedPreview {
+		p.lastLine = line
+		p.LineEnding = lineEndingStyle(lf, crlf, cr)
+		p.Content = string(content)
+		if p.Truncated {
+			p.Content = string(trimPartialRune(content))
+		}
+		return p
+	}
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if pendingCR {
+				pendingCR = false
+				if b == '\n' {
+					crlf++
+					if !add(b) {
+						return finish(), nil
+					}
+					line++
+					continue
+				}
+				cr++
+				line++
+			}
+
+			if !add(b) {
+				return finish(), nil
+			}
+			switch b {
+			case '\n':
+				lf++
+				line++
+				atLineStart = true
+			case '\r':
+				pendingCR = true
+				atLineStart = true
+			default:
+				atLineStart = false
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if pendingCR {
+		cr++
+		line++
+	}
+
+	if countAll {
+		lineCount := line
+		if atLineStart {
+			lineCount = line - 1
+		}
+		p.LineCount = &lineCount
+	}
+	return finish(), nil
+}
+
+// lineEndingStyle names the line endings used given how often each was seen
+func lineEndingStyle(lf, crlf, cr int) string {
+	switch {
+	case lf == 0 && crlf == 0 && cr == 0:
+		return ""
+	case crlf == 0 && cr == 0:
+		return lineEndingLF
+	case lf == 0 && cr == 0:
+		return lineEndingCRLF
+	case lf == 0 && crlf == 0:
+		return lineEndingCR
+	default:
+		return lineEndingMixed
+	}
+}
+
+// utf16Reader decodes UTF-16 text to UTF-8
+type utf16Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	// unit holds the bytes of one code unit
+	unit [2]byte
+	// pending is a code unit that was read but not decoded yet
+	pending    rune
+	hasPending bool
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	if len(p) < utf8.UTFMax {
+		return 0, io.ErrShortBuffer
+	}
+
+	n := 0
+	for n+utf8.UTFMax <= len(p) {
+		r, err := u.readRune()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		n += utf8.EncodeRune(p[n:], r)
+	}
+	return n, nil
+}
+
+// readRune decodes the next character, combining surrogate pairs. Unpaired
+// surrogates are decoded as invalid characters.
+func (u *utf16Reader) readRune() (rune, error) {
+	r1, err := u.readUnit()
+	if err != nil {
+		return 0, err
+	}
+	if !utf16.IsSurrogate(r1) {
+		return r1, nil
+	}
+	if r1 >= lowSurrogateStart {
+		return utf8.RuneError, nil
+	}
+	r2, err := u.readUnit()
+	if err == io.EOF {
+		return utf8.RuneError, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if r2 < lowSurrogateStart || r2 > lowSurrogateEnd {
+		// r2 starts the next character
+		u.pending, u.hasPending = r2, true
+		return utf8.RuneError, nil
+	}
+	return utf16.DecodeRune(r1, r2), nil
+}
+
+// readUnit reads one code unit. A trailing odd byte is decoded as an invalid
+// character.
+func (u *utf16Reader) readUnit() (rune, error) {
+	if u.hasPending {
+		u.hasPending = false
+		return u.pending, nil
+	}
+	n, err := io.ReadFull(u.r, u.unit[:])
+	if errors.Is(err, io.ErrUnexpectedEOF) && n == 1 {
+		return utf8.RuneError, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return rune(u.order.Uint16(u.unit[:])), nil
+}
+
+// latin1Reader decodes ISO 8859-1 text to UTF-8
+type latin1Reader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	// Each byte becomes at most two
+	if len(p) < 2 {
+		return 0, io.ErrShortBuffer
+	}
+	if cap(l.buf) < len(p)/2 {
+		l.buf = make([]byte, len(p)/2)
+	}
+
+	n, err := l.r.Read(l.buf[:len(p)/2])
+	out := 0
+	for _, b := range l.buf[:n] {
+		out += utf8.EncodeRune(p[out:], rune(b))
+	}
+	return out, err
+}
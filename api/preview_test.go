@@ -0,0 +1,310 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadPreview(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           string
+		query          string
+		wantContent    string
+		wantEncoding   string
+		wantLineEnding string
+		wantLineCount  int
+		wantTruncated  bool
+	}{
+		{
+			name:           "whole file",
+			data:           "a\nb\nc\n",
+			wantContent:    "a\nb\nc\n",
+			wantEncoding:   encodingUTF8,
+			wantLineEnding: lineEndingLF,
+			wantLineCount:  3,
+		},
+		{
+			name:          "empty",
+			data:          "",
+			wantContent:   "",
+			wantEncoding:  encodingUTF8,
+			wantLineCount: 0,
+		},
+		{
+			name:           "no final line ending",
+			data:           "a\nb",
+			wantContent:    "a\nb",
+			wantEncoding:   encodingUTF8,
+			wantLineEnding: lineEndingLF,
+			wantLineCount:  2,
+		},
+		{
+			name:           "first bytes",
+			data:           "hello\nworld\n",
+			query:          "bytes=3",
+			wantContent:    "hel",
+			wantEncoding:   encodingUTF8,
+			wantLineEnding: lineEndingLF,
+			wantLineCount:  2,
+			wantTruncated:  true,
+		},
+		{
+			name:          "cut between characters",
+			data:          "héllo",
+			query:         "bytes=2",
+			wantContent:   "h",
+			wantEncoding:  encodingUTF8,
+			wantLineCount: 1,
+			wantTruncated: true,
+		},
+		{
+			name:           "line window",
+			data:           "1\r\n2\r\n3\r\n4\r\n",
+			query:          "start=2&lines=2",
+			wantContent:    "2\r\n3\r\n",
+			wantEncoding:   encodingUTF8,
+			wantLineEnding: lineEndingCRLF,
+			wantLineCount:  4,
+			wantTruncated:  true,
+		},
+		{
+			name:           "window at the end",
+			data:           "1\r2\r3",
+			query:          "start=3",
+			wantContent:    "3",
+			wantEncoding:   encodingUTF8,
+			wantLineEnding: lineEndingCR,
+			wantLineCount:  3,
+		},
+		{
+			name:           "window past the end",
+			data:           "1\n2\n",
+			query:          "start=5",
+			wantContent:    "",
+			wantEncoding:   encodingUTF8,
+			wantLineEnding: lineEndingLF,
+			wantLineCount:  2,
+		},
+		{
+			name:           "mixed line endings",
+			data:           "1\r\n2\n",
+			wantContent:    "1\r\n2\n",
+			wantEncoding:   encodingUTF8,
+			wantLineEnding: lineEndingMixed,
+			wantLineCount:  2,
+		},
+		{
+			name:           "utf-8 byte order mark",
+			data:           "\xef\xbb\xbfname=value\n",
+			wantContent:    "name=value\n",
+			wantEncoding:   encodingUTF8,
+			wantLineEnding: lineEndingLF,
+			wantLineCount:  1,
+		},
+		{
+			name:           "utf-16le",
+			data:           "\xff\xfeh\x00\xe9\x00\n\x00=\xd8\x00\xde",
+			wantContent:    "hé\n😀",
+			wantEncoding:   encodingUTF16LE,
+			wantLineEnding: lineEndingLF,
+			wantLineCount:  2,
+		},
+		{
+			name:           "utf-16 unpaired surrogates",
+			data:           "\xff\xfe=\xd8a\x00\x00\xdeb\x00=\xd8=\xd8\x00\xde\n\x00",
+			wantContent:    "\ufffda\ufffdb\ufffd😀\n",
+			wantEncoding:   encodingUTF16LE,
+			wantLineEnding: lineEndingLF,
+			wantLineCount:  1,
+		},
+		{
+			name:           "utf-16be",
+			data:           "\xfe\xff\x00h\x00i\x00\r\x00\n",
+			wantContent:    "hi\r\n",
+			wantEncoding:   encodingUTF16BE,
+			wantLineEnding: lineEndingCRLF,
+			wantLineCount:  1,
+		},
+		{
+			name:           "latin-1",
+			data:           "caf\xe9\n",
+			wantContent:    "café\n",
+			wantEncoding:   encodingLatin1,
+			wantLineEnding: lineEndingLF,
+			wantLineCount:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("failed to open file: %v", err)
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatalf("failed to stat file: %v", err)
+			}
+
+			params, _ := url.ParseQuery(tt.query)
+			q, err := parsePreviewQuery(params)
+			if err != nil {
+				t.Fatalf("parsePreviewQuery() error = %v", err)
+			}
+			preview, err := readPreview(f, info, q)
+			if err != nil {
+				t.Fatalf("readPreview() error = %v", err)
+			}
+
+			if preview.Content != tt.wantContent {
+				t.Errorf("Content = %q, want %q", preview.Content, tt.wantContent)
+			}
+			if preview.Encoding != tt.wantEncoding {
+				t.Errorf("Encoding = %v, want %v", preview.Encoding, tt.wantEncoding)
+			}
+			if preview.LineEnding != tt.wantLineEnding {
+				t.Errorf("LineEnding = %q, want %q", preview.LineEnding, tt.wantLineEnding)
+			}
+			if preview.LineCount == nil || *preview.LineCount != tt.wantLineCount {
+				t.Errorf("LineCount = %v, want %v", preview.LineCount, tt.wantLineCount)
+			}
+			if preview.Truncated != tt.wantTruncated {
+				t.Errorf("Truncated = %v, want %v", preview.Truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestDetectEncodingBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"NUL bytes", "ELF\x02\x01\x01\x00\x00\x00"},
+		{"control characters", "\x01\x02\x03\x04\xff\xfe\x05\x06"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := detectEncoding([]byte(tt.data), true); err != errBinaryFile {
+				t.Errorf("detectEncoding() error = %v, want %v", err, errBinaryFile)
+			}
+		})
+	}
+}
+
+func TestParsePreviewQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    previewQuery
+		wantErr bool
+	}{
+		{"", previewQuery{maxBytes: defaultPreviewBytes, start: 1}, false},
+		{"bytes=10", previewQuery{maxBytes: 10, start: 1}, false},
+		{"start=5", previewQuery{maxBytes: maxPreviewBytes, start: 5, lines: defaultPreviewLines}, false},
+		{"lines=3&bytes=100", previewQuery{maxBytes: 100, start: 1, lines: 3}, false},
+		{"bytes=0", previewQuery{}, true},
+		{"bytes=2000000", previewQuery{}, true},
+		{"start=0", previewQuery{}, true},
+		{"lines=abc", previewQuery{}, true},
+		{"lines=10001", previewQuery{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			params, _ := url.ParseQuery(tt.query)
+			got, err := parsePreviewQuery(params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePreviewQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parsePreviewQuery() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewFile(t *testing.T) {
+	root := newSymlinkTree(t)
+	if err := os.WriteFile(filepath.Join(root, "image.png"), []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	s := &Server{rootDir: root}
+
+	tests := []struct {
+		name       string
+		urlPath    string
+		wantStatus int
+		wantCode   string
+	}{
+		{"text", "/api/preview/docs/readme.txt", http.StatusOK, ""},
+		{"through a link", "/api/preview/to-readme", http.StatusOK, ""},
+		{"binary", "/api/preview/image.png", http.StatusUnprocessableEntity, ErrorCodeBinaryFile},
+		{"directory", "/api/preview/docs", http.StatusBadRequest, ErrorCodeNotAFile},
+		{"missing", "/api/preview/missing.txt", http.StatusNotFound, ErrorCodeNotFound},
+		{"link outside root", "/api/preview/escape", http.StatusForbidden, ErrorCodeSymlinkOutsideRoot},
+		{"bad parameter", "/api/preview/docs/readme.txt?lines=0", http.StatusBadRequest, ErrorCodeInvalidParameter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.previewFile(w, httptest.NewRequest(http.MethodGet, tt.urlPath, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status code = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if tt.wantCode != "" {
+				if code := decodeError(t, w).Code; code != tt.wantCode {
+					t.Errorf("error code = %v, want %v", code, tt.wantCode)
+				}
+				return
+			}
+			var preview TextPreview
+			if err := json.NewDecoder(w.Body).Decode(&preview); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if preview.Content != "hi" || preview.Name != "readme.txt" {
+				t.Errorf("preview = %+v, want readme.txt", preview)
+			}
+		})
+	}
+
+	t.Run("large file", func(t *testing.T) {
+		line := strings.Repeat("x", 99) + "\n"
+		data := strings.Repeat(line, previewScanLimit/len(line)+1000)
+		if err := os.WriteFile(filepath.Join(root, "big.log"), []byte(data), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		s.previewFile(w, httptest.NewRequest(http.MethodGet, "/api/preview/big.log?start=2&lines=1", nil))
+		var preview TextPreview
+		if err := json.NewDecoder(w.Body).Decode(&preview); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if preview.Content != line || !preview.Truncated || preview.LineCount != nil {
+			t.Errorf("preview = %q truncated %v, line count %v, want one line, truncated and no count",
+				preview.Content, preview.Truncated, preview.LineCount)
+		}
+
+		// Lines past the scan limit can't be reached
+		w = httptest.NewRecorder()
+		s.previewFile(w, httptest.NewRequest(http.MethodGet, "/api/preview/big.log?start=200000", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+}
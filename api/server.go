@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 30 * time.Second
+	idleTimeout       = 2 * time.Minute
+	maxHeaderBytes    = 64 * 1024 // 64 KB
+	maxLoginBodySize  = 4 * 1024  // 4 KB
+)
+
+// NewHTTPServer returns an http.Server for handler with timeouts that stop
+// slow clients from holding connections open. There is no write timeout
+// because it would cut off large responses on slow links.
+func NewHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+}
+
+// ListenAndServe listens on addr and serves requests until Shutdown is called
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves requests on l until Shutdown is called. It serves HTTPS if a
+// certificate is configured.
+func (s *Server) Serve(l net.Listener) error {
+	srv := NewHTTPServer(l.Addr().String(), s.handler)
+	if s.tlsCert != nil {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{*s.tlsCert}}
+	}
+	// Watch streams never finish on their own, end them so shutdown doesn't
+	// wait for them
+	srv.RegisterOnShutdown(func() { s.watches.Close() })
+
+	s.mu.Lock()
+	if s.httpServer != nil {
+		s.mu.Unlock()
+		return errors.New("server is already serving")
+	}
+	s.httpServer = srv
+	s.mu.Unlock()
+
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(l, "", "")
+	}
+	return srv.Serve(l)
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests to
+// finish until ctx is done. It then closes the server's resources with Close.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.httpServer
+	s.mu.Unlock()
+
+	var errs []error
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to drain requests: %w", err))
+		}
+	}
+	errs = append(errs, s.Close())
+
+	return errors.Join(errs...)
+}
+
+// Close closes the audit log, stops the audit forwarder, exports the remaining
+// spans and deletes unfinished uploads. Audit events the collector hasn't
+// received stay in the spool and are delivered after a restart.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		var errs []error
+		if err := s.audit.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close audit log: %w", err))
+		}
+		if err := s.auditForwarder.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close audit forwarder: %w", err))
+		}
+		if err := s.tracer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close tracer: %w", err))
+		}
+		if err := s.watches.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close directory watches: %w", err))
+		}
+		if err := s.listingCache.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close listing cache: %w", err))
+		}
+		if err := s.uploads.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close uploads: %w", err))
+		}
+		s.closeErr = errors.Join(errs...)
+	})
+
+	return s.closeErr
+}
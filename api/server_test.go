@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestNewHTTPServer(t *testing.T) {
+	srv := NewHTTPServer("localhost:0", http.NotFoundHandler())
+
+	if srv.ReadHeaderTimeout == 0 {
+		t.Error("ReadHeaderTimeout is not set")
+	}
+	if srv.ReadTimeout == 0 {
+		t.Error("ReadTimeout is not set")
+	}
+	if srv.IdleTimeout == 0 {
+		t.Error("IdleTimeout is not set")
+	}
+	if srv.MaxHeaderBytes == 0 {
+		t.Error("MaxHeaderBytes is not set")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	audit, _ := openTestAuditLog(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{
+		sessionManager: NewSessionManager(),
+		audit:          audit,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.Write([]byte("done"))
+		}),
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- s.Serve(l) }()
+
+	// Start a request and shut down while it's in flight
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- s.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown() returned %v before the in-flight request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if res := <-results; res.err != nil || res.body != "done" {
+		t.Errorf("in-flight request = %q, %v, want done", res.body, res.err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Serve() error = %v, want %v", err, http.ErrServerClosed)
+	}
+
+	if err := audit.Record(AuditEvent{Action: AuditActionLogin}); err == nil {
+		t.Error("Record() after Shutdown() error = nil, want the audit log to be closed")
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	s := &Server{
+		sessionManager: NewSessionManager(),
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}),
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go s.Serve(l)
+	go http.Get("http://" + l.Addr().String() + "/")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestNewServerOptionError(t *testing.T) {
+	errBadOption := errors.New("bad option")
+	var failed *Server
+	badOption := func(s *Server) error {
+		failed = s
+		return errBadOption
+	}
+
+	webassets := fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	if _, err := NewServer(webassets, WithAuditLog(logPath, testAuditKey), badOption); !errors.Is(err, errBadOption) {
+		t.Fatalf("NewServer() error = %v, want %v", err, errBadOption)
+	}
+
+	// The audit log opened by the earlier option is closed
+	failed.audit.mu.Lock()
+	defer failed.audit.mu.Unlock()
+	if failed.audit.file != nil {
+		t.Error("NewServer() left the audit log open")
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+const (
+	defaultStatConcurrency = 16
+	defaultStatBudget      = 10 * time.Second
+
+	EntryErrorPermissionDenied = "permission_denied"
+	EntryErrorNotFound         = "not_found"
+	EntryErrorTimeout          = "timeout"
+	EntryErrorIO               = "io_error"
+)
+
+var (
+	// errStatTimeout is recorded for entries not read within the budget
+	errStatTimeout = errors.New("timed out reading file information")
+)
+
+// EntryError explains why a directory entry's information is missing
+type EntryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// WithStatLimits bounds how many directory entries are read in parallel and
+// how long a request may spend reading them. Entries that aren't read in time
+// are listed with a timeout error.
+func WithStatLimits(concurrency int, budget time.Duration) Option {
+	return func(s *Server) error {
+		if concurrency < 1 || budget <= 0 {
+			return errors.New("stat concurrency and budget must be positive")
+		}
+		s.statConcurrency = concurrency
+		s.statBudget = budget
+		return nil
+	}
+}
+
+// statResult is the outcome of reading one entry's information
+type statResult struct {
+	info os.FileInfo
+	err  error
+}
+
+// withStatBudget returns a context that expires when the request's time for
+// reading entries runs out
+func (s *Server) withStatBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	budget := s.statBudget
+	if budget <= 0 {
+		budget = defaultStatBudget
+	}
+	return context.WithTimeout(ctx, budget)
+}
+
+// statEntries reads the information of entries in parallel. Entries that
+// aren't read before ctx is done get errStatTimeout. A stat that is stuck in
+// the filesystem finishes in the background without holding up the request.
+func (s *Server) statEntries(ctx context.Context, entries []os.DirEntry) []statResult {
+	results := make([]statResult, len(entries))
+	if len(entries) == 0 {
+		return results
+	}
+
+	concurrency := s.statConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultStatConcurrency
+	}
+
+	type indexedResult struct {
+		index int
+		statResult
+	}
+	jobs := make(chan int)
+	// Buffered so workers never block on a request that has given up
+	done := make(chan indexedResult, len(entries))
+
+	for range min(concurrency, len(entries)) {
+		go func() {
+			for i := range jobs {
+				info, err := entries[i].Info()
+				done <- indexedResult{index: i, statResult: statResult{info: info, err: err}}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range entries {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	received := make([]bool, len(entries))
+	for pending := len(entries); pending > 0; pending-- {
+		select {
+		case r := <-done:
+			results[r.index] = r.statResult
+			received[r.index] = true
+		case <-ctx.Done():
+			for i := range results {
+				if !received[i] {
+					results[i].err = errStatTimeout
+				}
+			}
+			return results
+		}
+	}
+
+	return results
+}
+
+// newEntryError describes a failure to read an entry without revealing paths
+func newEntryError(err error) *EntryError {
+	switch {
+	case errors.Is(err, errStatTimeout):
+		return &EntryError{Code: EntryErrorTimeout, Message: "timed out reading file information"}
+	case errors.Is(err, fs.ErrPermission):
+		return &EntryError{Code: EntryErrorPermissionDenied, Message: "permission denied"}
+	case errors.Is(err, fs.ErrNotExist):
+		return &EntryError{Code: EntryErrorNotFound, Message: "file no longer exists"}
+	default:
+		return &EntryError{Code: EntryErrorIO, Message: "failed to read file information"}
+	}
+}
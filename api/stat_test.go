@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockingEntry is a directory entry whose Info blocks until release is closed,
+// like a stat on an unresponsive network mount
+type blockingEntry struct {
+	name    string
+	release chan struct{}
+}
+
+func (e blockingEntry) Name() string      { return e.name }
+func (e blockingEntry) IsDir() bool       { return false }
+func (e blockingEntry) Type() fs.FileMode { return 0 }
+func (e blockingEntry) Info() (fs.FileInfo, error) {
+	<-e.release
+	return nil, fs.ErrNotExist
+}
+
+func TestStatEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := range 50 {
+		if err := os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i)), []byte("x"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	// Removed between reading the directory and stat'ing its entries
+	if err := os.Remove(filepath.Join(tmpDir, "file7.txt")); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+
+	s := &Server{statConcurrency: 4}
+	results := s.statEntries(context.Background(), entries)
+
+	for i, result := range results {
+		if entries[i].Name() == "file7.txt" {
+			if !errors.Is(result.err, fs.ErrNotExist) {
+				t.Errorf("statEntries() %s error = %v, want %v", entries[i].Name(), result.err, fs.ErrNotExist)
+			}
+			continue
+		}
+		if result.err != nil || result.info.Name() != entries[i].Name() {
+			t.Errorf("statEntries() %s = %v, %v, want its info", entries[i].Name(), result.info, result.err)
+		}
+	}
+}
+
+func TestStatEntriesTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	entries := []os.DirEntry{blockingEntry{"slow1", release}, blockingEntry{"slow2", release}}
+	s := &Server{statConcurrency: 1, statBudget: 10 * time.Millisecond}
+
+	ctx, cancel := s.withStatBudget(context.Background())
+	defer cancel()
+	results := s.statEntries(ctx, entries)
+
+	for i, result := range results {
+		if result.err != errStatTimeout {
+			t.Errorf("statEntries() %s error = %v, want %v", entries[i].Name(), result.err, errStatTimeout)
+		}
+	}
+
+	entry := s.newEntry(entries[0], results[0])
+	if entry.Type != "file" || entry.Error == nil || entry.Error.Code != EntryErrorTimeout {
+		t.Errorf("newEntry() = %+v, want a file with a timeout error", entry)
+	}
+}
+
+func TestReadFileInfoReportsEntryErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("test content"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if err := os.Symlink("missing", filepath.Join(tmpDir, "broken")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	info, err := os.Stat(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to stat dir: %v", err)
+	}
+
+	// Simulate a stat that never returns by giving the request no time at all
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := &Server{rootDir: tmpDir}
+	fileInfo, err := s.readFileInfo(ctx, tmpDir, info, listOptions{root: tmpDir, fields: fieldModTime})
+	if err != nil {
+		t.Fatalf("readFileInfo() error = %v", err)
+	}
+	if len(fileInfo.Contents) != 2 {
+		t.Fatalf("len(fileInfo.Contents) = %v, want %v", len(fileInfo.Contents), 2)
+	}
+
+	wantTypes := map[string]string{"test.txt": "file", "broken": "symlink"}
+	for _, entry := range fileInfo.Contents {
+		if entry.Type != wantTypes[entry.Name] {
+			t.Errorf("%s Type = %v, want %v", entry.Name, entry.Type, wantTypes[entry.Name])
+		}
+		// Entries are either read before the context is noticed or timed out
+		if entry.Error != nil && entry.Error.Code != EntryErrorTimeout {
+			t.Errorf("%s Error = %+v, want a timeout", entry.Name, entry.Error)
+		}
+		if entry.Error != nil && (entry.ModTime != nil || entry.Link != nil) {
+			t.Errorf("%s has metadata despite error %+v", entry.Name, entry.Error)
+		}
+	}
+}
+
+func TestNewEntryError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"timeout", errStatTimeout, EntryErrorTimeout},
+		{"permission denied", &fs.PathError{Op: "lstat", Path: "/secret", Err: fs.ErrPermission}, EntryErrorPermissionDenied},
+		{"not found", &fs.PathError{Op: "lstat", Path: "/gone", Err: fs.ErrNotExist}, EntryErrorNotFound},
+		{"other", errors.New("input/output error"), EntryErrorIO},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newEntryError(tt.err)
+			if got.Code != tt.want {
+				t.Errorf("newEntryError() code = %v, want %v", got.Code, tt.want)
+			}
+			if got.Message == "" {
+				t.Error("newEntryError() message is empty")
+			}
+		})
+	}
+}
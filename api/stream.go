@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+
+	// streamChunkSize is how many directory entries are read and written
+	// between flushes
+	streamChunkSize = 256
+)
+
+// wantsNDJSON reports whether the client asked for a streamed listing
+func wantsNDJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(mediaRange)
+			if err == nil && mediaType == ndjsonContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// streamFileInfo writes information about a file or directory as newline
+// delimited JSON. The first line describes path itself and each following
+// line is one directory entry, in directory order.
+//
+// The directory is read in chunks so memory use doesn't grow with its size,
+// and reading stops as soon as the client goes away. Entries are filtered by
+// opts.page but not sorted or paginated.
+func (s *Server) streamFileInfo(w http.ResponseWriter, r *http.Request, path string, info os.FileInfo, opts listOptions) {
+	start := time.Now()
+	listed := -1
+	defer func() {
+		s.metrics.observeRead(listed, time.Since(start))
+	}()
+
+	names := newOwnerNames()
+	fileInfo := &FileInfo{
+		Name: info.Name(),
+		Size: info.Size(),
+		Type: "file",
+	}
+	fillMetadata(fileInfo, path, info, opts.fields, names)
+
+	var dir *os.File
+	if info.IsDir() {
+		fileInfo.Type = "directory"
+		fileInfo.Size = 0
+
+		var err error
+		if dir, err = os.Open(path); err != nil {
+			internalError(w, r, "failed to open directory", err)
+			return
+		}
+		defer dir.Close()
+	}
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(fileInfo); err != nil || dir == nil {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	listed = 0
+	for {
+		if r.Context().Err() != nil {
+			// The client went away
+			return
+		}
+
+		entries, err := dir.ReadDir(streamChunkSize)
+		entries = visibleDirEntries(entries, opts)
+		// Each chunk gets its own budget, so time spent waiting on a slow
+		// client doesn't count against reading later chunks
+		ctx, cancel := s.withStatBudget(r.Context())
+		results := s.statEntries(ctx, entries)
+		cancel()
+		for i, entry := range entries {
+			entryFileInfo := s.newEntry(entry, results[i])
+			if entryFileInfo == nil {
+				continue
+			}
+			listed++
+			if opts.page != nil && opts.page.filter != "" && !opts.page.matches(entryFileInfo.Name) {
+				continue
+			}
+
+			describeEntry(entryFileInfo, path, opts, names)
+			if err := enc.Encode(entryFileInfo); err != nil {
+				return
+			}
+		}
+
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("failed to read directory", "error", err)
+			// The status has been sent, so the error goes in the stream
+			enc.Encode(ErrorResponse{Error: newErrorBody(r, ErrorCodeInternal, "failed to read directory", nil)})
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
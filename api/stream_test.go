@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cancelOnFlush cancels a request's context the first time it's flushed, as
+// if the client disconnected after the first chunk
+type cancelOnFlush struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnFlush) Flush() {
+	c.ResponseRecorder.Flush()
+	c.cancel()
+}
+
+// slowFlush takes delay to flush, as if the client were reading slowly
+type slowFlush struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+}
+
+func (s *slowFlush) Flush() {
+	time.Sleep(s.delay)
+	s.ResponseRecorder.Flush()
+}
+
+func TestWantsNDJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"application/x-ndjson", true},
+		{"text/html, application/x-ndjson;q=0.9", true},
+		{"application/x-ndjson-foo", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/files/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if got := wantsNDJSON(req); got != tt.want {
+			t.Errorf("wantsNDJSON(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestGetFilesStreaming(t *testing.T) {
+	tmpDir := t.TempDir()
+	const numFiles = 2*streamChunkSize + 10
+	for i := 0; i < numFiles; i++ {
+		if err := os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%04d.txt", i)), nil, 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "notes.md"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	s := &Server{rootDir: tmpDir}
+	stream := func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Accept", ndjsonContentType)
+		s.getFiles(w, r)
+	}
+	decodeLines := func(t *testing.T, body string) []FileInfo {
+		t.Helper()
+		var lines []FileInfo
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			var fileInfo FileInfo
+			if err := json.Unmarshal(scanner.Bytes(), &fileInfo); err != nil {
+				t.Fatalf("failed to decode line %q: %v", scanner.Text(), err)
+			}
+			lines = append(lines, fileInfo)
+		}
+		return lines
+	}
+
+	t.Run("streams every entry", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		stream(w, httptest.NewRequest(http.MethodGet, "/api/files/", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Content-Type"); got != ndjsonContentType {
+			t.Errorf("Content-Type = %v, want %v", got, ndjsonContentType)
+		}
+
+		lines := decodeLines(t, w.Body.String())
+		if len(lines) != numFiles+2 {
+			t.Fatalf("got %d lines, want %d", len(lines), numFiles+2)
+		}
+		if lines[0].Type != "directory" || lines[0].Contents != nil {
+			t.Errorf("first line = %+v, want the directory without contents", lines[0])
+		}
+		seen := make(map[string]bool)
+		for _, line := range lines[1:] {
+			seen[line.Name] = true
+		}
+		if len(seen) != numFiles+1 {
+			t.Errorf("got %d distinct entries, want %d", len(seen), numFiles+1)
+		}
+	})
+
+	t.Run("filter and fields", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		stream(w, httptest.NewRequest(http.MethodGet, "/api/files/?filter=*.md&fields=modTime", nil))
+
+		lines := decodeLines(t, w.Body.String())
+		if len(lines) != 2 || lines[1].Name != "notes.md" {
+			t.Fatalf("lines = %+v, want the directory and notes.md", lines)
+		}
+		if lines[1].ModTime == nil {
+			t.Error("notes.md modTime is not set")
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		stream(w, httptest.NewRequest(http.MethodGet, "/api/files/notes.md", nil))
+
+		lines := decodeLines(t, w.Body.String())
+		if len(lines) != 1 || lines[0].Type != "file" || lines[0].Size != 5 {
+			t.Errorf("lines = %+v, want just the file", lines)
+		}
+	})
+
+	t.Run("stops when the client disconnects", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		w := &cancelOnFlush{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+		stream(w, httptest.NewRequest(http.MethodGet, "/api/files/", nil).WithContext(ctx))
+
+		lines := decodeLines(t, w.Body.String())
+		if len(lines) != streamChunkSize+1 {
+			t.Errorf("got %d lines, want the directory and one chunk of %d entries", len(lines), streamChunkSize)
+		}
+	})
+
+	t.Run("slow client", func(t *testing.T) {
+		slow := &Server{rootDir: tmpDir, statBudget: 50 * time.Millisecond}
+		w := &slowFlush{ResponseRecorder: httptest.NewRecorder(), delay: 100 * time.Millisecond}
+		r := httptest.NewRequest(http.MethodGet, "/api/files/", nil)
+		r.Header.Set("Accept", ndjsonContentType)
+		slow.getFiles(w, r)
+
+		lines := decodeLines(t, w.Body.String())
+		if len(lines) != numFiles+2 {
+			t.Fatalf("got %d lines, want %d", len(lines), numFiles+2)
+		}
+		for _, line := range lines[1:] {
+			if line.Error != nil {
+				t.Fatalf("%s error = %+v, want the stat budget to cover each chunk", line.Name, line.Error)
+			}
+		}
+	})
+
+	t.Run("pagination parameters are rejected", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		stream(w, httptest.NewRequest(http.MethodGet, "/api/files/?sort=size", nil))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy controls how symbolic links under the served root are treated
+type SymlinkPolicy string
+
+const (
+	// SymlinkFollow lists links and follows those that resolve inside the root
+	SymlinkFollow SymlinkPolicy = "follow"
+	// SymlinkDeny lists links but refuses requests through any of them
+	SymlinkDeny SymlinkPolicy = "deny"
+	// SymlinkHide leaves links out of listings and reports them as missing
+	SymlinkHide SymlinkPolicy = "hide"
+
+	// Link target types
+	linkTargetFile      = "file"
+	linkTargetDirectory = "directory"
+	linkTargetOther     = "other"
+	linkTargetMissing   = "missing"
+)
+
+var (
+	// errSymlinkOutsideRoot is returned for paths through a link that
+	// resolves outside the root
+	errSymlinkOutsideRoot = &apiError{code: ErrorCodeSymlinkOutsideRoot, message: "symbolic link points outside the served directory"}
+	// errSymlinkDenied is returned for paths through a link under SymlinkDeny
+	errSymlinkDenied = &apiError{code: ErrorCodeSymlinkDenied, message: "symbolic links are not followed on this server"}
+	// errSymlinkHidden is returned for paths through a link under SymlinkHide
+	errSymlinkHidden = errors.New("symbolic links are hidden on this server")
+)
+
+// ParseSymlinkPolicy parses a policy name
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	switch policy := SymlinkPolicy(name); policy {
+	case SymlinkFollow, SymlinkDeny, SymlinkHide:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown symlink policy %q: must be follow, deny or hide", name)
+	}
+}
+
+// WithSymlinkPolicy sets how symbolic links are listed and followed. The
+// default is SymlinkFollow.
+func WithSymlinkPolicy(policy SymlinkPolicy) Option {
+	return func(s *Server) error {
+		if _, err := ParseSymlinkPolicy(string(policy)); err != nil {
+			return err
+		}
+		s.symlinkPolicy = policy
+		return nil
+	}
+}
+
+// LinkInfo describes where a symbolic link points
+type LinkInfo struct {
+	// Target is the path the link resolves to, relative to the user's root.
+	// It's only set for links inside the root, so that nothing about the
+	// host's other paths is revealed.
+	Target string `json:"target,omitempty"`
+	// InsideRoot reports whether the link resolves to an existing path that
+	// can be opened through the API
+	InsideRoot bool `json:"insideRoot"`
+	// TargetType is file, directory or other for links inside the root and
+	// missing for broken links. It's empty for links outside the root.
+	TargetType string `json:"targetType,omitempty"`
+}
+
+// resolveRequestPath resolves relPath under root like resolvePathIn, and
+// refuses paths through symbolic links unless the policy follows them
+func (s *Server) resolveRequestPath(root, relPath string) (string, error) {
+	if s.symlinkPolicy == SymlinkDeny || s.symlinkPolicy == SymlinkHide {
+		throughLink, err := traversesSymlink(root, relPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to check for symbolic links: %w", err)
+		}
+		if throughLink && s.symlinkPolicy == SymlinkDeny {
+			return "", errSymlinkDenied
+		}
+		if throughLink {
+			return "", errSymlinkHidden
+		}
+	}
+
+	return resolvePathIn(root, relPath)
+}
+
+// describeLink describes the symbolic link at linkPath under root
+func describeLink(root, linkPath string) *LinkInfo {
+	link := &LinkInfo{}
+	resolved, err := filepath.EvalSymlinks(linkPath)
+	if err != nil {
+		link.TargetType = linkTargetMissing
+		return link
+	}
+
+	rootAbs, err := filepath.Abs(root)
+	if err != nil || !withinDir(rootAbs, resolved) {
+		// Don't reveal anything about paths outside the root
+		return link
+	}
+	link.InsideRoot = true
+	if rel, err := filepath.Rel(rootAbs, resolved); err == nil {
+		link.Target = path.Clean("/" + filepath.ToSlash(rel))
+	}
+
+	info, err := os.Stat(resolved)
+	switch {
+	case err != nil:
+		link.TargetType = linkTargetMissing
+	case info.IsDir():
+		link.TargetType = linkTargetDirectory
+	case info.Mode().IsRegular():
+		link.TargetType = linkTargetFile
+	default:
+		link.TargetType = linkTargetOther
+	}
+
+	return link
+}
+
+// traversesSymlink reports whether relPath under root passes through a
+// symbolic link, including being one itself
+func traversesSymlink(root, relPath string) (bool, error) {
+	current, err := filepath.Abs(root)
+	if err != nil {
+		return false, fmt.Errorf("failed to get absolute root directory: %w", err)
+	}
+
+	for _, segment := range strings.Split(filepath.Clean("/"+relPath), string(filepath.Separator)) {
+		if segment == "" {
+			continue
+		}
+		current = filepath.Join(current, segment)
+
+		info, err := os.Lstat(current)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// withinDir reports whether path is dir or inside it. Both must be absolute.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// rootContaining returns the one of roots that dir is inside once symbolic
+// links are resolved, or "" if it's outside all of them
+func rootContaining(roots []string, dir string) (string, error) {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	for _, root := range roots {
+		rootDir, err := filepath.Abs(root)
+		if err != nil {
+			return "", fmt.Errorf("failed to get absolute root directory: %w", err)
+		}
+		if resolved, err := filepath.EvalSymlinks(rootDir); err == nil {
+			rootDir = resolved
+		}
+		if withinDir(rootDir, dir) {
+			return root, nil
+		}
+	}
+	return "", nil
+}
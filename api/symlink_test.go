@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newSymlinkTree creates a root with links to a file and a directory inside
+// it, a link out of it and a broken link
+func newSymlinkTree(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "readme.txt"), []byte("hi"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	links := map[string]string{
+		"to-docs":   "docs",
+		"to-readme": "docs/readme.txt",
+		"escape":    outside,
+		"broken":    "missing",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+	}
+
+	return root
+}
+
+func TestListingSymlinks(t *testing.T) {
+	root := newSymlinkTree(t)
+	s := &Server{rootDir: root}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		t.Fatalf("failed to stat root: %v", err)
+	}
+	fileInfo, err := s.readFileInfo(context.Background(), root, info, listOptions{root: root})
+	if err != nil {
+		t.Fatalf("readFileInfo() error = %v", err)
+	}
+
+	entries := make(map[string]*FileInfo)
+	for _, entry := range fileInfo.Contents {
+		entries[entry.Name] = entry
+	}
+
+	tests := []struct {
+		name           string
+		wantTarget     string
+		wantInsideRoot bool
+		wantTargetType string
+	}{
+		{"to-docs", "/docs", true, linkTargetDirectory},
+		{"to-readme", "/docs/readme.txt", true, linkTargetFile},
+		{"escape", "", false, ""},
+		{"broken", "", false, linkTargetMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := entries[tt.name]
+			if entry == nil {
+				t.Fatalf("%s is not listed", tt.name)
+			}
+			if entry.Type != "symlink" {
+				t.Errorf("Type = %v, want symlink", entry.Type)
+			}
+			if entry.Link == nil {
+				t.Fatal("Link is nil")
+			}
+			if entry.Link.Target != tt.wantTarget {
+				t.Errorf("Link.Target = %q, want %q", entry.Link.Target, tt.wantTarget)
+			}
+			if entry.Link.InsideRoot != tt.wantInsideRoot {
+				t.Errorf("Link.InsideRoot = %v, want %v", entry.Link.InsideRoot, tt.wantInsideRoot)
+			}
+			if entry.Link.TargetType != tt.wantTargetType {
+				t.Errorf("Link.TargetType = %v, want %v", entry.Link.TargetType, tt.wantTargetType)
+			}
+		})
+	}
+
+	if entries["docs"].Type != "directory" || entries["docs"].Link != nil {
+		t.Errorf("docs = %+v, want a plain directory", entries["docs"])
+	}
+}
+
+func TestSymlinkPolicy(t *testing.T) {
+	root := newSymlinkTree(t)
+
+	tests := []struct {
+		policy      SymlinkPolicy
+		path        string
+		wantStatus  int
+		wantListing []string
+	}{
+		{SymlinkFollow, "/", http.StatusOK, []string{"broken", "docs", "escape", "to-docs", "to-readme"}},
+		{SymlinkFollow, "/to-docs", http.StatusOK, nil},
+		{SymlinkFollow, "/to-docs/readme.txt", http.StatusOK, nil},
+		{SymlinkFollow, "/escape", http.StatusForbidden, nil},
+		{SymlinkDeny, "/", http.StatusOK, []string{"broken", "docs", "escape", "to-docs", "to-readme"}},
+		{SymlinkDeny, "/docs/readme.txt", http.StatusOK, nil},
+		{SymlinkDeny, "/to-docs", http.StatusForbidden, nil},
+		{SymlinkDeny, "/to-docs/readme.txt", http.StatusForbidden, nil},
+		{SymlinkDeny, "/escape", http.StatusForbidden, nil},
+		{SymlinkHide, "/", http.StatusOK, []string{"docs"}},
+		{SymlinkHide, "/docs/readme.txt", http.StatusOK, nil},
+		{SymlinkHide, "/to-readme", http.StatusNotFound, nil},
+		{SymlinkHide, "/escape", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy)+" "+tt.path, func(t *testing.T) {
+			s := &Server{rootDir: root}
+			if err := WithSymlinkPolicy(tt.policy)(s); err != nil {
+				t.Fatalf("WithSymlinkPolicy() error = %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			s.getFiles(w, httptest.NewRequest(http.MethodGet, "/api/files"+tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status code = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if tt.wantListing == nil {
+				return
+			}
+			var fileInfo FileInfo
+			if err := json.NewDecoder(w.Body).Decode(&fileInfo); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			var names []string
+			for _, entry := range fileInfo.Contents {
+				names = append(names, entry.Name)
+			}
+			if len(names) != len(tt.wantListing) {
+				t.Fatalf("listing = %v, want %v", names, tt.wantListing)
+			}
+			for i := range names {
+				if names[i] != tt.wantListing[i] {
+					t.Errorf("listing = %v, want %v", names, tt.wantListing)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	for _, name := range []string{"follow", "deny", "hide"} {
+		if _, err := ParseSymlinkPolicy(name); err != nil {
+			t.Errorf("ParseSymlinkPolicy(%q) error = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "allow", "Follow"} {
+		if _, err := ParseSymlinkPolicy(name); err == nil {
+			t.Errorf("ParseSymlinkPolicy(%q) error = nil, want error", name)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultThumbnailSize        = 256
+	maxThumbnailSize            = 1024
+	defaultThumbnailMaxPixels   = 50_000_000
+	defaultThumbnailConcurrency = 4
+	defaultThumbnailCacheSize   = 256 << 20
+	thumbnailJPEGQuality        = 85
+)
+
+// thumbnailSizes are the bounds thumbnails are generated at. Requested sizes
+// are rounded up to one of them so that clients can't fill the cache with
+// every size between 1 and maxThumbnailSize.
+var thumbnailSizes = []int{32, 64, 128, 256, 512, maxThumbnailSize}
+
+// defaultThumbnailSlots limits concurrency for servers without their own
+// thumbnailer
+var defaultThumbnailSlots = make(chan struct{}, defaultThumbnailConcurrency)
+
+var (
+	errUnsupportedImage = &apiError{code: ErrorCodeUnsupportedImage, message: "file is not a PNG, JPEG or GIF image"}
+	errInvalidImage     = &apiError{code: ErrorCodeInvalidImage, message: "image could not be decoded"}
+)
+
+// ThumbnailConfig configures image thumbnails
+type ThumbnailConfig struct {
+	// CacheDir is where generated thumbnails are kept. It must be outside
+	// every served directory. Thumbnails aren't cached if it's empty.
+	CacheDir string
+	// MaxPixels is the largest image, in pixels, that will be decoded
+	MaxPixels int64
+	// Concurrency is how many thumbnails may be generated at once
+	Concurrency int
+	// MaxCacheSize is how many bytes of thumbnails are kept. The least
+	// recently used ones are removed once it's exceeded.
+	MaxCacheSize int64
+}
+
+// WithThumbnails sets the limits for generating thumbnails and where they're
+// cached. Without it thumbnails are generated with the default limits every
+// time they're requested.
+func WithThumbnails(cfg ThumbnailConfig) Option {
+	return func(s *Server) error {
+		t, err := newThumbnailer(cfg)
+		if err != nil {
+			return err
+		}
+		s.thumbnails = t
+		return nil
+	}
+}
+
+// thumbnailer generates thumbnails and caches them on disk. A nil
+// *thumbnailer uses the default pixel and concurrency limits and caches
+// nothing.
+type thumbnailer struct {
+	cacheDir     string
+	maxPixels    int64
+	maxCacheSize int64
+	// slots holds a token for each thumbnail being generated
+	slots chan struct{}
+
+	mu sync.Mutex
+	// cacheSize is roughly how many bytes are cached. It's recounted
+	// whenever the cache is trimmed.
+	cacheSize int64
+}
+
+func newThumbnailer(cfg ThumbnailConfig) (*thumbnailer, error) {
+	if cfg.MaxPixels < 0 || cfg.Concurrency < 0 || cfg.MaxCacheSize < 0 {
+		return nil, errors.New("thumbnail pixel, concurrency and cache size limits must not be negative")
+	}
+	if cfg.MaxPixels == 0 {
+		cfg.MaxPixels = defaultThumbnailMaxPixels
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultThumbnailConcurrency
+	}
+	if cfg.MaxCacheSize == 0 {
+		cfg.MaxCacheSize = defaultThumbnailCacheSize
+	}
+
+	t := &thumbnailer{
+		maxPixels:    cfg.MaxPixels,
+		maxCacheSize: cfg.MaxCacheSize,
+		slots:        make(chan struct{}, cfg.Concurrency),
+	}
+	if cfg.CacheDir != "" {
+		dir, err := filepath.Abs(cfg.CacheDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid thumbnail cache directory: %w", err)
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create thumbnail cache directory: %w", err)
+		}
+		t.cacheDir = dir
+		// Trim what earlier runs left, which also counts it
+		t.trim()
+	}
+
+	return t, nil
+}
+
+// checkCacheDir returns an error if the cache directory is inside one of
+// roots, where thumbnails could be listed and would pollute the tree
+func (t *thumbnailer) checkCacheDir(roots []string) error {
+	if t == nil || t.cacheDir == "" {
+		return nil
+	}
+
+	root, err := rootContaining(roots, t.cacheDir)
+	if err != nil {
+		return err
+	}
+	if root != "" {
+		return fmt.Errorf("thumbnail cache directory %s must be outside the served directory %s", t.cacheDir, root)
+	}
+	return nil
+}
+
+// acquire waits for a free slot to generate a thumbnail in, returning a
+// function that frees it
+func (t *thumbnailer) acquire(ctx context.Context) (func(), error) {
+	slots := defaultThumbnailSlots
+	if t != nil {
+		slots = t.slots
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// thumbnailKey identifies a thumbnail of the file at path as it is now
+func thumbnailKey(path string, info os.FileInfo, width, height int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00%dx%d", path, info.ModTime().UnixNano(), info.Size(), width, height)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachePath returns where the thumbnail with key is cached
+func (t *thumbnailer) cachePath(key string) string {
+	return filepath.Join(t.cacheDir, key[:2], key)
+}
+
+// load returns a cached thumbnail, marking it as recently used
+func (t *thumbnailer) load(key string) ([]byte, bool) {
+	if t == nil || t.cacheDir == "" {
+		return nil, false
+	}
+
+	path := t.cachePath(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return data, true
+}
+
+// store caches a thumbnail. The file is written under a temporary name and
+// renamed so that readers never see part of it.
+func (t *thumbnailer) store(key string, data []byte) error {
+	if t == nil || t.cacheDir == "" {
+		return nil
+	}
+
+	path := t.cachePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.cacheSize += int64(len(data))
+	full := t.cacheSize > t.maxCacheSize
+	t.mu.Unlock()
+	if full {
+		t.trim()
+	}
+	return nil
+}
+
+// trim removes the least recently used thumbnails until the cache is down to
+// three quarters of its limit, leaving room for new ones before the next trim
+func (t *thumbnailer) trim() {
+	if !t.mu.TryLock() {
+		// Another request is already trimming
+		return
+	}
+	defer t.mu.Unlock()
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	filepath.WalkDir(t.cacheDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, cached{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+
+	if total > t.maxCacheSize {
+		slices.SortFunc(files, func(a, b cached) int { return a.modTime.Compare(b.modTime) })
+		for _, f := range files {
+			if total <= t.maxCacheSize*3/4 {
+				break
+			}
+			if err := os.Remove(f.path); err == nil || errors.Is(err, os.ErrNotExist) {
+				total -= f.size
+			}
+		}
+	}
+	t.cacheSize = total
+}
+
+// generate decodes the image in r and returns it shrunk to fit within width
+// by height, encoded as JPEG if the image is a JPEG and as PNG otherwise.
+// Images larger than the pixel limit are refused before they're decoded.
+func (t *thumbnailer) generate(r io.ReadSeeker, width, height int) ([]byte, error) {
+	maxPixels := int64(defaultThumbnailMaxPixels)
+	if t != nil {
+		maxPixels = t.maxPixels
+	}
+
+	cfg, format, err := image.DecodeConfig(r)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, errUnsupportedImage
+	}
+	if err != nil {
+		return nil, errInvalidImage
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, &apiError{
+			code:    ErrorCodeImageTooLarge,
+			message: fmt.Sprintf("image has more than %d pixels", maxPixels),
+			details: map[string]any{"maxPixels": maxPixels},
+		}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, errInvalidImage
+	}
+
+	var buf bytes.Buffer
+	thumb := scaleToFit(img, width, height)
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleToFit shrinks src to fit within width by height, keeping its aspect
+// ratio. Each pixel is the average of the source pixels it covers. Images
+// that already fit are returned as they are.
+func scaleToFit(src image.Image, width, height int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= width && sh <= height {
+		return src
+	}
+
+	scale := min(float64(width)/float64(sw), float64(height)/float64(sh))
+	dw := max(1, int(math.Round(float64(sw)*scale)))
+	dh := max(1, int(math.Round(float64(sh)*scale)))
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*sh/dh, b.Min.Y+(y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*sw/dw, b.Min.X+(x+1)*sw/dw
+
+			// Sum the premultiplied colors so transparent pixels don't
+			// darken the edges of opaque ones
+			var r, g, bl, a uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r * 0xffff / a >> 8),
+				G: uint8(g * 0xffff / a >> 8),
+				B: uint8(bl * 0xffff / a >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// parseThumbnailSize parses the width and height parameters, rounding each up
+// to the nearest of thumbnailSizes
+func parseThumbnailSize(params url.Values) (int, int, error) {
+	size := [2]int{defaultThumbnailSize, defaultThumbnailSize}
+	for i, name := range []string{"width", "height"} {
+		s := params.Get(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxThumbnailSize {
+			return 0, 0, invalidParameter(name, "%s must be between 1 and %d", name, maxThumbnailSize)
+		}
+		j, _ := slices.BinarySearch(thumbnailSizes, n)
+		size[i] = thumbnailSizes[j]
+	}
+	return size[0], size[1], nil
+}
+
+// getThumbnail handles GET requests to /api/thumbnail/<path>, shrinking a PNG,
+// JPEG or GIF image to fit within the requested bounds. It's returned as a
+// JPEG for JPEG images and a PNG otherwise.
+func (s *Server) getThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	width, height, err := parseThumbnailSize(r.URL.Query())
+	if err != nil {
+		writeRequestError(w, r, err, ErrorCodeInvalidParameter, http.StatusBadRequest)
+		return
+	}
+
+	target, ok := s.resolveTarget(w, r, strings.TrimPrefix(r.URL.Path, "/api/thumbnail"))
+	if !ok {
+		return
+	}
+	if target.mount == nil {
+		writeError(w, r, ErrorCodeNotAFile, "Only files have thumbnails", http.StatusBadRequest)
+		return
+	}
+
+	f, info, ok := openRegularFile(w, r, target.fullPath)
+	if !ok {
+		return
+	}
+	defer f.Close()
+
+	v := newValidators(info, nil)
+	if checkNotModified(w, r, v.etag(r.URL.Query()), v.modTime) {
+		return
+	}
+
+	key := thumbnailKey(target.fullPath, info, width, height)
+	data, cached := s.thumbnails.load(key)
+	spanFromContext(r.Context()).setAttribute("thumbnail.cached", cached)
+	if !cached {
+		release, err := s.thumbnails.acquire(r.Context())
+		if err != nil {
+			// The client went away while waiting
+			return
+		}
+		_, sp := startSpan(r.Context(), "generateThumbnail")
+		sp.setAttribute("file.path", target.fullPath)
+		data, err = s.thumbnails.generate(f, width, height)
+		sp.finish(err)
+		release()
+		if err != nil {
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				writeRequestError(w, r, err, ErrorCodeInternal, http.StatusUnprocessableEntity)
+				return
+			}
+			internalError(w, r, "failed to generate thumbnail", err)
+			return
+		}
+
+		if err := s.thumbnails.store(key, data); err != nil {
+			requestLogger(r).Warn("failed to cache thumbnail", "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if _, err := w.Write(data); err != nil {
+		requestLogger(r).Error("failed to write response", "error", err)
+	}
+}
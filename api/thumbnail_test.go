@@ -0,0 +1,338 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// newTestImage returns a width by height image filled with c
+func newTestImage(width, height int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// encodeTestPNG returns img encoded as PNG
+func encodeTestPNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestScaleToFit(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		boundsW, boundsH      int
+		wantWidth, wantHeight int
+	}{
+		{"landscape", 100, 50, 10, 10, 10, 5},
+		{"portrait", 50, 100, 10, 10, 5, 10},
+		{"already fits", 5, 5, 10, 10, 5, 5},
+		{"thin", 1000, 1, 10, 10, 10, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scaleToFit(newTestImage(tt.width, tt.height, color.White), tt.boundsW, tt.boundsH).Bounds()
+			if got.Dx() != tt.wantWidth || got.Dy() != tt.wantHeight {
+				t.Errorf("scaleToFit() size = %dx%d, want %dx%d", got.Dx(), got.Dy(), tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+
+	t.Run("pixels are averaged", func(t *testing.T) {
+		src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+		src.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+		src.SetNRGBA(1, 0, color.NRGBA{G: 255, A: 0})
+
+		got := scaleToFit(src, 1, 1).(*image.NRGBA).NRGBAAt(0, 0)
+		// The transparent pixel's color doesn't show
+		want := color.NRGBA{R: 255, A: 127}
+		if got != want {
+			t.Errorf("scaleToFit() pixel = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestGenerateThumbnail(t *testing.T) {
+	img := newTestImage(40, 20, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+
+	var jpegData, gifData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatalf("failed to encode JPEG: %v", err)
+	}
+	if err := gif.Encode(&gifData, img, nil); err != nil {
+		t.Fatalf("failed to encode GIF: %v", err)
+	}
+	pngData := encodeTestPNG(t, img)
+
+	tests := []struct {
+		name            string
+		data            []byte
+		maxPixels       int64
+		wantContentType string
+		wantErr         string
+	}{
+		{"png", pngData, 0, "image/png", ""},
+		{"jpeg", jpegData.Bytes(), 0, "image/jpeg", ""},
+		{"gif", gifData.Bytes(), 0, "image/png", ""},
+		{"too many pixels", pngData, 799, "", ErrorCodeImageTooLarge},
+		{"not an image", []byte("hello"), 0, "", ErrorCodeUnsupportedImage},
+		{"corrupt", pngData[:40], 0, "", ErrorCodeInvalidImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumbnails, err := newThumbnailer(ThumbnailConfig{MaxPixels: tt.maxPixels})
+			if err != nil {
+				t.Fatalf("newThumbnailer() error = %v", err)
+			}
+
+			data, err := thumbnails.generate(bytes.NewReader(tt.data), 10, 10)
+			if tt.wantErr != "" {
+				var apiErr *apiError
+				if !errors.As(err, &apiErr) || apiErr.code != tt.wantErr {
+					t.Errorf("generate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("generate() error = %v", err)
+			}
+
+			if got := http.DetectContentType(data); got != tt.wantContentType {
+				t.Errorf("content type = %v, want %v", got, tt.wantContentType)
+			}
+			thumb, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("failed to decode thumbnail: %v", err)
+			}
+			if b := thumb.Bounds(); b.Dx() != 10 || b.Dy() != 5 {
+				t.Errorf("thumbnail size = %dx%d, want 10x5", b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestGetThumbnail(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "shot.png"), encodeTestPNG(t, newTestImage(300, 150, color.Black)), 0644); err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	cacheDir := t.TempDir()
+	s := &Server{rootDir: root}
+	if err := WithThumbnails(ThumbnailConfig{CacheDir: cacheDir})(s); err != nil {
+		t.Fatalf("WithThumbnails() error = %v", err)
+	}
+
+	get := func(urlPath string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, urlPath, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		s.getThumbnail(w, r)
+		return w
+	}
+
+	w := get("/api/thumbnail/shot.png?width=100", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+	cfg, err := png.DecodeConfig(w.Body)
+	if err != nil {
+		t.Fatalf("failed to decode thumbnail: %v", err)
+	}
+	// 100 is rounded up to 128
+	if cfg.Width != 128 || cfg.Height != 64 {
+		t.Errorf("thumbnail size = %dx%d, want 128x64", cfg.Width, cfg.Height)
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		info, err := os.Stat(filepath.Join(root, "shot.png"))
+		if err != nil {
+			t.Fatalf("failed to stat image: %v", err)
+		}
+		path := s.thumbnails.cachePath(thumbnailKey(filepath.Join(root, "shot.png"), info, 128, defaultThumbnailSize))
+		marker := encodeTestPNG(t, newTestImage(1, 1, color.White))
+		if err := os.WriteFile(path, marker, 0600); err != nil {
+			t.Fatalf("failed to replace cached thumbnail: %v", err)
+		}
+
+		w := get("/api/thumbnail/shot.png?width=100", nil)
+		if !bytes.Equal(w.Body.Bytes(), marker) {
+			t.Error("thumbnail wasn't served from the cache")
+		}
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		w := get("/api/thumbnail/shot.png?width=100", http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+		if w.Code != http.StatusNotModified {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusNotModified)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		urlPath    string
+		wantStatus int
+		wantCode   string
+	}{
+		{"not an image", "/api/thumbnail/notes.txt", http.StatusUnprocessableEntity, ErrorCodeUnsupportedImage},
+		{"directory", "/api/thumbnail/", http.StatusBadRequest, ErrorCodeNotAFile},
+		{"missing", "/api/thumbnail/missing.png", http.StatusNotFound, ErrorCodeNotFound},
+		{"too wide", "/api/thumbnail/shot.png?width=5000", http.StatusBadRequest, ErrorCodeInvalidParameter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.urlPath, nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status code = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if code := decodeError(t, w).Code; code != tt.wantCode {
+				t.Errorf("error code = %v, want %v", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestThumbnailCacheOutsideRoot(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+
+	webassets := fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}
+	_, err := NewServer(webassets, WithThumbnails(ThumbnailConfig{CacheDir: filepath.Join(root, "cache")}))
+	if err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("NewServer() error = %v, want the cache directory to be refused", err)
+	}
+
+	s, err := NewServer(webassets, WithThumbnails(ThumbnailConfig{CacheDir: t.TempDir()}))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	s.Close()
+}
+
+func TestParseThumbnailSize(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantWidth  int
+		wantHeight int
+		wantErr    bool
+	}{
+		{"", defaultThumbnailSize, defaultThumbnailSize, false},
+		{"width=32&height=32", 32, 32, false},
+		{"width=1&height=100", 32, 128, false},
+		{"width=513", 1024, defaultThumbnailSize, false},
+		{"width=1024", 1024, defaultThumbnailSize, false},
+		{"width=0", 0, 0, true},
+		{"height=1025", 0, 0, true},
+		{"width=big", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("failed to parse query: %v", err)
+			}
+			width, height, err := parseThumbnailSize(params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseThumbnailSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("parseThumbnailSize() = %d, %d, want %d, %d", width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestThumbnailCacheTrim(t *testing.T) {
+	cacheDir := t.TempDir()
+	thumbnails, err := newThumbnailer(ThumbnailConfig{CacheDir: cacheDir, MaxCacheSize: 400})
+	if err != nil {
+		t.Fatalf("newThumbnailer() error = %v", err)
+	}
+
+	// Store four 100 byte thumbnails, each used less recently than the last
+	data := bytes.Repeat([]byte("x"), 100)
+	keys := []string{"aa01", "aa02", "aa03", "aa04"}
+	for i, key := range keys {
+		if err := thumbnails.store(key, data); err != nil {
+			t.Fatalf("store() error = %v", err)
+		}
+		used := time.Now().Add(-time.Duration(len(keys)-i) * time.Hour)
+		if err := os.Chtimes(thumbnails.cachePath(key), used, used); err != nil {
+			t.Fatalf("failed to set modification time: %v", err)
+		}
+	}
+	// Using the oldest makes it the most recently used
+	if _, ok := thumbnails.load("aa01"); !ok {
+		t.Fatal("load() found nothing")
+	}
+
+	// The fifth goes over the limit, so the cache is trimmed to 300 bytes
+	if err := thumbnails.store("aa05", data); err != nil {
+		t.Fatalf("store() error = %v", err)
+	}
+	for _, key := range []string{"aa02", "aa03"} {
+		if _, ok := thumbnails.load(key); ok {
+			t.Errorf("%s is still cached, want it removed", key)
+		}
+	}
+	for _, key := range []string{"aa01", "aa04", "aa05"} {
+		if _, ok := thumbnails.load(key); !ok {
+			t.Errorf("%s was removed, want it cached", key)
+		}
+	}
+}
+
+func TestNilThumbnailerConcurrency(t *testing.T) {
+	var thumbnails *thumbnailer
+	for i := 0; i < defaultThumbnailConcurrency; i++ {
+		release, err := thumbnails.acquire(context.Background())
+		if err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+		defer release()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := thumbnails.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
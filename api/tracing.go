@@ -0,0 +1,484 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	traceparentHeader = "traceparent"
+
+	defaultTraceServiceName   = "fs4"
+	defaultTraceBatchSize     = 512
+	defaultTraceQueueSize     = 4096
+	defaultTraceFlushInterval = 5 * time.Second
+	traceExportTimeout        = 10 * time.Second
+
+	// traceScopeName identifies this package as the instrumentation scope
+	traceScopeName = "github.com/goteleport-interview/fs4/api"
+
+	// OTLP span kinds and status codes
+	spanKindInternal  = 1
+	spanKindServer    = 2
+	spanStatusError   = 2
+	traceFlagsSampled = 0x01
+)
+
+// TracingConfig configures a Tracer
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP traces URL of the collector, for example
+	// http://localhost:4318/v1/traces
+	Endpoint string
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+	// Client sends the export requests, defaults to a client with a timeout
+	Client *http.Client
+	// BatchSize is the most spans sent in one export request
+	BatchSize int
+	// QueueSize bounds the spans waiting to be exported, more are dropped
+	QueueSize int
+	// FlushInterval is the longest a finished span waits before it's exported
+	FlushInterval time.Duration
+}
+
+// Tracer exports spans to an OpenTelemetry collector using OTLP/HTTP with JSON
+// encoding.
+//
+// Finished spans are queued and exported in batches by a background
+// goroutine, so a slow collector never blocks requests. Spans that don't fit
+// in the queue are dropped.
+type Tracer struct {
+	cfg TracingConfig
+
+	queue chan *span
+	flush chan chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewTracer validates cfg and starts exporting spans
+func NewTracer(cfg TracingConfig) (*Tracer, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid trace endpoint %q: must be an http or https URL", cfg.Endpoint)
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultTraceServiceName
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: traceExportTimeout}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultTraceBatchSize
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultTraceQueueSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultTraceFlushInterval
+	}
+
+	t := &Tracer{
+		cfg:   cfg,
+		queue: make(chan *span, cfg.QueueSize),
+		flush: make(chan chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go t.run()
+
+	return t, nil
+}
+
+// Flush exports all finished spans and waits for the export to complete
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+		<-done
+	case <-t.done:
+	}
+}
+
+// Close exports the remaining spans and stops the tracer
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	t.once.Do(func() { close(t.stop) })
+	<-t.done
+	return nil
+}
+
+// enqueue queues a finished span for export, dropping it if the queue is full
+func (t *Tracer) enqueue(sp *span) {
+	select {
+	case t.queue <- sp:
+	default:
+		slog.Warn("trace export queue is full, dropped span", "name", sp.name, "trace_id", sp.traceID)
+	}
+}
+
+// run batches queued spans and exports them
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	export := func() {
+		if len(batch) > 0 {
+			t.export(batch)
+			batch = nil
+		}
+	}
+	// drain moves every queued span into the batch, exporting full batches
+	drain := func() {
+		for {
+			select {
+			case sp := <-t.queue:
+				batch = append(batch, sp)
+				if len(batch) >= t.cfg.BatchSize {
+					export()
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case sp := <-t.queue:
+			batch = append(batch, sp)
+			if len(batch) >= t.cfg.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-t.flush:
+			drain()
+			export()
+			close(done)
+		case <-t.stop:
+			drain()
+			export()
+			return
+		}
+	}
+}
+
+// export sends one batch of spans to the collector
+func (t *Tracer) export(batch []*span) {
+	body, err := json.Marshal(t.encode(batch))
+	if err != nil {
+		slog.Error("failed to encode spans", "spans", len(batch), "error", err)
+		return
+	}
+
+	resp, err := t.cfg.Client.Post(t.cfg.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("failed to export spans", "endpoint", t.cfg.Endpoint, "spans", len(batch), "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		slog.Warn("collector rejected spans", "endpoint", t.cfg.Endpoint, "spans", len(batch), "status", resp.StatusCode)
+	}
+}
+
+// OTLP/HTTP JSON request body. Trace and span IDs are hex encoded and
+// timestamps are decimal strings, as the OTLP JSON mapping requires.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+// encode converts spans to an OTLP export request
+func (t *Tracer) encode(batch []*span) otlpTraces {
+	spans := make([]otlpSpan, len(batch))
+	for i, sp := range batch {
+		spans[i] = sp.encode()
+	}
+
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			stringAttribute("service.name", t.cfg.ServiceName),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: traceScopeName},
+			Spans: spans,
+		}},
+	}}}
+}
+
+// span is a single timed operation within a trace. A nil *span records
+// nothing so call sites don't need to check whether tracing is enabled.
+type span struct {
+	tracer   *Tracer
+	traceID  string
+	spanID   string
+	parentID string
+	sampled  bool
+	name     string
+	kind     int
+	start    time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes []otlpAttribute
+	status     otlpStatus
+}
+
+type spanContextKey struct{}
+
+// spanFromContext returns the active span, or nil if the request isn't traced
+func spanFromContext(ctx context.Context) *span {
+	sp, _ := ctx.Value(spanContextKey{}).(*span)
+	return sp
+}
+
+// startSpan starts a child of the span in ctx. It returns a nil span if ctx
+// isn't traced.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+
+	sp := &span{
+		tracer:   parent.tracer,
+		traceID:  parent.traceID,
+		spanID:   newSpanID(),
+		parentID: parent.spanID,
+		sampled:  parent.sampled,
+		name:     name,
+		kind:     spanKindInternal,
+		start:    time.Now(),
+	}
+	return context.WithValue(ctx, spanContextKey{}, sp), sp
+}
+
+// setAttribute records a string, integer or boolean attribute on the span
+func (sp *span) setAttribute(key string, value any) {
+	if sp == nil {
+		return
+	}
+
+	var attr otlpAttribute
+	switch v := value.(type) {
+	case string:
+		attr = stringAttribute(key, v)
+	case int:
+		s := strconv.Itoa(v)
+		attr = otlpAttribute{Key: key, Value: otlpAnyValue{IntValue: &s}}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		attr = otlpAttribute{Key: key, Value: otlpAnyValue{IntValue: &s}}
+	case bool:
+		attr = otlpAttribute{Key: key, Value: otlpAnyValue{BoolValue: &v}}
+	default:
+		attr = stringAttribute(key, fmt.Sprint(v))
+	}
+
+	sp.mu.Lock()
+	sp.attributes = append(sp.attributes, attr)
+	sp.mu.Unlock()
+}
+
+// finish ends the span, marking it as failed if err is not nil, and queues
+// it for export
+func (sp *span) finish(err error) {
+	if sp == nil {
+		return
+	}
+
+	sp.mu.Lock()
+	sp.end = time.Now()
+	if err != nil {
+		sp.status = otlpStatus{Code: spanStatusError, Message: err.Error()}
+	}
+	sp.mu.Unlock()
+
+	if sp.sampled && sp.tracer != nil {
+		sp.tracer.enqueue(sp)
+	}
+}
+
+func (sp *span) encode() otlpSpan {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	return otlpSpan{
+		TraceID:           sp.traceID,
+		SpanID:            sp.spanID,
+		ParentSpanID:      sp.parentID,
+		Name:              sp.name,
+		Kind:              sp.kind,
+		StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(sp.end.UnixNano(), 10),
+		Attributes:        sp.attributes,
+		Status:            sp.status,
+	}
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+// traceRequests is a middleware that starts a server span for each request,
+// continuing the trace in an incoming traceparent header if there is one
+func (s *Server) traceRequests(next http.Handler) http.Handler {
+	if s.tracer == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp := &span{
+			tracer:  s.tracer,
+			traceID: newTraceID(),
+			spanID:  newSpanID(),
+			sampled: true,
+			name:    "HTTP " + r.Method,
+			kind:    spanKindServer,
+			start:   time.Now(),
+		}
+		if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+			sp.traceID, sp.parentID, sp.sampled = traceID, parentID, sampled
+		}
+		sp.setAttribute("http.request.method", r.Method)
+		sp.setAttribute("url.path", r.URL.Path)
+		sp.setAttribute("client.address", sourceIP(r))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), spanContextKey{}, sp)))
+
+		sp.setAttribute("http.response.status_code", rec.status)
+		var err error
+		if rec.status >= http.StatusInternalServerError {
+			err = errors.New(http.StatusText(rec.status))
+		}
+		sp.finish(err)
+	})
+}
+
+// parseTraceparent parses a W3C traceparent header
+func parseTraceparent(header string) (traceID, parentID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return "", "", false, false
+	}
+
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return "", "", false, false
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return "", "", false, false
+	}
+	if !isLowerHex(parentID, 16) || parentID == strings.Repeat("0", 16) {
+		return "", "", false, false
+	}
+	if !isLowerHex(flags, 2) {
+		return "", "", false, false
+	}
+
+	b, _ := hex.DecodeString(flags)
+	return traceID, parentID, b[0]&traceFlagsSampled != 0, true
+}
+
+// isLowerHex reports whether s is n lowercase hex digits
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newTraceID() string {
+	return randomHex(16)
+}
+
+func newSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate trace ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestCollector starts an OTLP/HTTP collector stand-in that records the
+// spans it receives
+func newTestCollector(t *testing.T) (string, func() []otlpSpan) {
+	t.Helper()
+
+	var (
+		mu    sync.Mutex
+		spans []otlpSpan
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("collector got %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+
+		var req otlpTraces
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("collector failed to decode request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL + "/v1/traces", func() []otlpSpan {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]otlpSpan(nil), spans...)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("test content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	t.Chdir(tmpDir)
+
+	endpoint, received := newTestCollector(t)
+	webassets := fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}
+	s, err := NewServer(webassets, WithTracing(TracingConfig{Endpoint: endpoint}))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	t.Cleanup(func() { s.tracer.Close() })
+
+	token, err := s.sessionManager.CreateSession("alice", "password")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+	req := httptest.NewRequest(http.MethodGet, "/api/files/", nil)
+	req.Header.Set(traceparentHeader, "00-"+traceID+"-"+parentID+"-01")
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status code = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	s.tracer.Flush()
+
+	byName := make(map[string]otlpSpan)
+	for _, sp := range received() {
+		if sp.TraceID != traceID {
+			t.Errorf("span %s traceId = %v, want %v", sp.Name, sp.TraceID, traceID)
+		}
+		byName[sp.Name] = sp
+	}
+
+	root, ok := byName["HTTP GET"]
+	if !ok {
+		t.Fatalf("no server span in %v", byName)
+	}
+	if root.ParentSpanID != parentID {
+		t.Errorf("server span parentSpanId = %v, want %v", root.ParentSpanID, parentID)
+	}
+	if root.Kind != spanKindServer {
+		t.Errorf("server span kind = %v, want %v", root.Kind, spanKindServer)
+	}
+
+	parents := map[string]string{
+		"requireAuth":     root.SpanID,
+		"ValidateSession": byName["requireAuth"].SpanID,
+		"validatePath":    root.SpanID,
+		"locate":          root.SpanID,
+		"resolvePath":     root.SpanID,
+		"os.Stat":         root.SpanID,
+		"readFileInfo":    root.SpanID,
+	}
+	for name, parent := range parents {
+		sp, ok := byName[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if sp.ParentSpanID != parent {
+			t.Errorf("%s parentSpanId = %v, want %v", name, sp.ParentSpanID, parent)
+		}
+		if sp.Status.Code != 0 {
+			t.Errorf("%s status = %+v, want unset", name, sp.Status)
+		}
+	}
+}
+
+func TestTracingUnsampled(t *testing.T) {
+	endpoint, received := newTestCollector(t)
+	tracer, err := NewTracer(TracingConfig{Endpoint: endpoint})
+	if err != nil {
+		t.Fatalf("NewTracer() error = %v", err)
+	}
+	s := &Server{tracer: tracer}
+
+	handler := s.traceRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, sp := startSpan(r.Context(), "child")
+		if sp == nil {
+			t.Error("startSpan() = nil, want a span that propagates the trace")
+		}
+		sp.finish(nil)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	tracer.Close()
+	if spans := received(); len(spans) != 0 {
+		t.Errorf("exported %d spans for an unsampled trace, want 0", len(spans))
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future version with extra fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"empty", "", false, false},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"version 00 with extra fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero parent ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", false, false},
+		{"short trace ID", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, sampled, ok := parseTraceparent(tt.header)
+			if ok != tt.wantOK {
+				t.Fatalf("parseTraceparent() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if sampled != tt.wantSampled {
+				t.Errorf("parseTraceparent() sampled = %v, want %v", sampled, tt.wantSampled)
+			}
+		})
+	}
+}
+
+func TestNewTracerInvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"", "localhost:4318", "ftp://collector/v1/traces"} {
+		if _, err := NewTracer(TracingConfig{Endpoint: endpoint}); err == nil {
+			t.Errorf("NewTracer(%q) error = nil, want error", endpoint)
+		}
+	}
+}
@@ -0,0 +1,1104 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUploadMaxSize = 1 << 30
+	defaultUploadExpiry  = 24 * time.Hour
+	maxUploadsPerUser    = 16
+	// uploadReapInterval is how often expired uploads are looked for
+	uploadReapInterval = time.Minute
+	// maxRenameAttempts is how many numbered names are tried for a file that
+	// would replace another
+	maxRenameAttempts = 100
+	// uploadRecordSuffix ends the names of upload records in the state
+	// directory
+	uploadRecordSuffix = ".json"
+
+	// uploadTempPrefix starts the names of files still being uploaded. They're
+	// never listed or served.
+	uploadTempPrefix = ".fs4-upload-"
+	// uploadChunkType is the content type of resumable upload chunks
+	uploadChunkType = "application/offset+octet-stream"
+
+	conflictFail      = "fail"
+	conflictOverwrite = "overwrite"
+	conflictRename    = "rename"
+)
+
+var (
+	errUploadsDisabled = &apiError{code: ErrorCodeReadOnly, message: "Uploads are disabled on this server"}
+	errAlreadyExists   = &apiError{code: ErrorCodeAlreadyExists, message: "A file with this name already exists"}
+	errOverwriteDenied = &apiError{code: ErrorCodeForbidden, message: "Uploads may not replace existing files on this server"}
+	errTooManyUploads  = &apiError{
+		code:    ErrorCodeTooManyUploads,
+		message: "Too many uploads are in progress",
+		details: map[string]any{"limit": maxUploadsPerUser},
+	}
+)
+
+// UploadConfig configures file uploads
+type UploadConfig struct {
+	// MaxSize is the largest file that may be uploaded, in bytes
+	MaxSize int64
+	// Expiry is how long a resumable upload is kept after its last chunk
+	Expiry time.Duration
+	// Overwrite lets uploads replace existing files with conflict=overwrite.
+	// The ACL only grants read access, so without it they're refused.
+	Overwrite bool
+	// StateDir is a directory outside the served directories where
+	// unfinished uploads are recorded, so resumable ones can be continued
+	// after a restart and the data of the rest removed. Without it uploads
+	// are only tracked in memory and their data is deleted when the server
+	// stops.
+	StateDir string
+}
+
+// WithUploads lets users upload files into directories they can see. Without
+// it the server is read-only. Mounts marked read-only never accept uploads.
+func WithUploads(cfg UploadConfig) Option {
+	return func(s *Server) error {
+		u, err := newUploadManager(cfg)
+		if err != nil {
+			return err
+		}
+		s.uploads = u
+		return nil
+	}
+}
+
+// UploadedFile is a file that was uploaded
+type UploadedFile struct {
+	// Name is the name the file was saved as, which differs from the one it
+	// was uploaded with if it was renamed to avoid a conflict
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// UploadStatus is the state of a resumable upload
+type UploadStatus struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// uploadDir is a directory files are uploaded into
+type uploadDir struct {
+	mount *Mount
+	user  string
+	// urlPath is the directory as requested, which names are validated in
+	urlPath string
+	// path is the directory with symbolic links resolved
+	path string
+}
+
+// uploadSession is a resumable upload
+type uploadSession struct {
+	id       string
+	user     string
+	dir      *uploadDir
+	name     string
+	conflict string
+	length   int64
+	// tempPath is where data is written until the upload is complete
+	tempPath string
+
+	// mu is held while a chunk is appended
+	mu     sync.Mutex
+	offset int64
+	// done is set once the session is removed
+	done bool
+
+	// expires is guarded by the manager's mutex
+	expires time.Time
+}
+
+// uploadRecord is how an upload's temporary file is recorded in the state
+// directory. Files of multipart uploads, which can't be resumed, are recorded
+// without a name so they're removed if the server stops while receiving them.
+type uploadRecord struct {
+	ID       string    `json:"id"`
+	TempPath string    `json:"tempPath"`
+	User     string    `json:"user,omitempty"`
+	Dir      string    `json:"dir,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Conflict string    `json:"conflict,omitempty"`
+	Length   int64     `json:"length,omitempty"`
+	Expires  time.Time `json:"expires,omitzero"`
+}
+
+// uploadManager tracks resumable uploads. A nil *uploadManager means uploads
+// are disabled.
+type uploadManager struct {
+	maxSize   int64
+	expiry    time.Duration
+	overwrite bool
+	now       func() time.Time
+	// stateDir is where uploads are recorded, or "" to keep them in memory
+	stateDir string
+	// reapInterval is how often expired uploads are removed
+	reapInterval time.Duration
+
+	// stop is closed to end the background sweep for stale and expired
+	// uploads, which closes stopped once it has finished
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+
+	mu       sync.Mutex
+	sessions map[string]*uploadSession
+}
+
+func newUploadManager(cfg UploadConfig) (*uploadManager, error) {
+	if cfg.MaxSize < 0 || cfg.Expiry < 0 {
+		return nil, errors.New("upload size limit and expiry must not be negative")
+	}
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = defaultUploadMaxSize
+	}
+	if cfg.Expiry == 0 {
+		cfg.Expiry = defaultUploadExpiry
+	}
+	if cfg.StateDir != "" {
+		if err := os.MkdirAll(cfg.StateDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create upload state directory: %w", err)
+		}
+	}
+
+	return &uploadManager{
+		maxSize:      cfg.MaxSize,
+		expiry:       cfg.Expiry,
+		overwrite:    cfg.Overwrite,
+		now:          time.Now,
+		stateDir:     cfg.StateDir,
+		reapInterval: uploadReapInterval,
+		stop:         make(chan struct{}),
+		sessions:     make(map[string]*uploadSession),
+	}, nil
+}
+
+// start resumes the uploads recorded in the state directory, using reopen to
+// find the directories they're saved in, then removes expired uploads in the
+// background every reapInterval until the manager is closed. Without it
+// expired uploads are only removed when another upload is created or resumed.
+func (u *uploadManager) start(reopen func(user, urlPath string) (*uploadDir, error)) {
+	if u == nil {
+		return
+	}
+
+	u.load(reopen)
+	u.stopped = make(chan struct{})
+	go func() {
+		defer close(u.stopped)
+		ticker := time.NewTicker(u.reapInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-u.stop:
+				return
+			case <-ticker.C:
+				u.mu.Lock()
+				u.reapLocked()
+				u.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// load reads the state directory, tracking the uploads that can be resumed
+// and deleting the data of the rest
+func (u *uploadManager) load(reopen func(user, urlPath string) (*uploadDir, error)) {
+	if u.stateDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(u.stateDir)
+	if err != nil {
+		slog.Warn("failed to read upload state directory", "path", u.stateDir, "error", err)
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), uploadRecordSuffix)
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(u.stateDir, entry.Name()))
+		if err != nil {
+			slog.Warn("failed to read upload record", "id", id, "error", err)
+			continue
+		}
+		var rec uploadRecord
+		if err := json.Unmarshal(data, &rec); err != nil || rec.ID != id {
+			slog.Warn("removing invalid upload record", "id", id)
+			u.discard(id, "")
+			continue
+		}
+
+		sess, err := u.resume(rec, reopen)
+		if err != nil {
+			slog.Info("removing unfinished upload", "path", rec.TempPath, "reason", err)
+			u.discard(id, rec.TempPath)
+			continue
+		}
+		u.sessions[id] = sess
+	}
+}
+
+// resume returns the session for a recorded upload, or an error if it can't
+// be continued
+func (u *uploadManager) resume(rec uploadRecord, reopen func(user, urlPath string) (*uploadDir, error)) (*uploadSession, error) {
+	switch {
+	case rec.Name == "":
+		return nil, errors.New("multipart uploads can't be resumed")
+	case !u.now().Before(rec.Expires):
+		return nil, errors.New("upload has expired")
+	case rec.Conflict == conflictOverwrite && !u.overwrite:
+		return nil, errors.New("uploads may no longer replace existing files")
+	}
+
+	dir, err := reopen(rec.User, rec.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Dir(rec.TempPath) != dir.path {
+		return nil, errors.New("upload directory has moved")
+	}
+	info, err := os.Stat(rec.TempPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > rec.Length {
+		return nil, errors.New("upload is longer than its length")
+	}
+
+	return &uploadSession{
+		id:       rec.ID,
+		user:     rec.User,
+		dir:      dir,
+		name:     rec.Name,
+		conflict: rec.Conflict,
+		length:   rec.Length,
+		tempPath: rec.TempPath,
+		offset:   info.Size(),
+		expires:  rec.Expires,
+	}, nil
+}
+
+// save records a resumable upload in the state directory. The caller must
+// hold the session's mutex or not have published the session yet.
+func (u *uploadManager) save(sess *uploadSession, expires time.Time) error {
+	return u.writeRecord(uploadRecord{
+		ID:       sess.id,
+		TempPath: sess.tempPath,
+		User:     sess.user,
+		Dir:      sess.dir.urlPath,
+		Name:     sess.name,
+		Conflict: sess.conflict,
+		Length:   sess.length,
+		Expires:  expires,
+	})
+}
+
+// writeRecord atomically replaces an upload's record
+func (u *uploadManager) writeRecord(rec uploadRecord) error {
+	if u.stateDir == "" {
+		return nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload record: %w", err)
+	}
+
+	path := filepath.Join(u.stateDir, rec.ID+uploadRecordSuffix)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write upload record: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write upload record: %w", err)
+	}
+	return nil
+}
+
+// discard deletes an upload's temporary file, if it has one, and then its
+// record
+func (u *uploadManager) discard(id, tempPath string) error {
+	// Records only name temporary files, so nothing else is ever removed
+	if tempPath != "" && strings.HasPrefix(filepath.Base(tempPath), uploadTempPrefix) {
+		if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if u.stateDir == "" {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(u.stateDir, id+uploadRecordSuffix)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// track records the temporary file of a multipart upload, so it's removed
+// after a restart if the server stops while receiving it. The returned
+// function deletes the file and forgets it.
+func (u *uploadManager) track(tempPath string) (func(), error) {
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+	if err := u.writeRecord(uploadRecord{ID: id, TempPath: tempPath}); err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := u.discard(id, tempPath); err != nil {
+			slog.Warn("failed to remove upload", "path", tempPath, "error", err)
+		}
+	}, nil
+}
+
+// newUploadID returns a random ID for an upload
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// create starts tracking a resumable upload
+func (u *uploadManager) create(sess *uploadSession) error {
+	id, err := newUploadID()
+	if err != nil {
+		return err
+	}
+	sess.id = id
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.reapLocked()
+
+	count := 0
+	for _, other := range u.sessions {
+		if other.user == sess.user {
+			count++
+		}
+	}
+	if count >= maxUploadsPerUser {
+		return errTooManyUploads
+	}
+
+	sess.expires = u.now().Add(u.expiry)
+	if err := u.save(sess, sess.expires); err != nil {
+		return err
+	}
+	u.sessions[sess.id] = sess
+	return nil
+}
+
+// get returns the user's upload with id, or nil if there isn't one. Uploads
+// belonging to other users aren't returned so their IDs can't be probed.
+func (u *uploadManager) get(id, user string) *uploadSession {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.reapLocked()
+
+	sess := u.sessions[id]
+	if sess == nil || sess.user != user {
+		return nil
+	}
+	return sess
+}
+
+// touch pushes back the expiry of an upload that made progress. The caller
+// must hold the session's mutex.
+func (u *uploadManager) touch(sess *uploadSession) {
+	u.mu.Lock()
+	sess.expires = u.now().Add(u.expiry)
+	expires := sess.expires
+	u.mu.Unlock()
+
+	if err := u.save(sess, expires); err != nil {
+		slog.Warn("failed to save upload", "id", sess.id, "error", err)
+	}
+}
+
+// remove stops tracking an upload and deletes its data. The caller must hold
+// the session's mutex.
+func (u *uploadManager) remove(sess *uploadSession) {
+	u.mu.Lock()
+	delete(u.sessions, sess.id)
+	u.mu.Unlock()
+
+	sess.done = true
+	if err := u.discard(sess.id, sess.tempPath); err != nil {
+		slog.Warn("failed to remove upload", "path", sess.tempPath, "error", err)
+	}
+}
+
+// reapLocked removes expired uploads that aren't receiving a chunk. The
+// caller must hold u.mu.
+func (u *uploadManager) reapLocked() {
+	now := u.now()
+	for id, sess := range u.sessions {
+		if now.Before(sess.expires) || !sess.mu.TryLock() {
+			continue
+		}
+		delete(u.sessions, id)
+		sess.done = true
+		if err := u.discard(id, sess.tempPath); err != nil {
+			slog.Warn("failed to remove expired upload", "path", sess.tempPath, "error", err)
+		}
+		sess.mu.Unlock()
+	}
+}
+
+// Close stops the background sweep. Unfinished uploads are kept to be resumed
+// after a restart when they're recorded in a state directory, and their data
+// is deleted otherwise.
+func (u *uploadManager) Close() error {
+	if u == nil {
+		return nil
+	}
+
+	u.stopOnce.Do(func() { close(u.stop) })
+	if u.stopped != nil {
+		<-u.stopped
+	}
+	if u.stateDir != "" {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var errs []error
+	for id, sess := range u.sessions {
+		delete(u.sessions, id)
+		if err := os.Remove(sess.tempPath); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isUploadTempName reports whether urlPath has a component that is a file
+// still being uploaded
+func isUploadTempName(urlPath string) bool {
+	return strings.Contains("/"+urlPath, "/"+uploadTempPrefix)
+}
+
+// parseConflictPolicy parses the conflict parameter, which says what happens
+// when an uploaded file has the name of an existing one
+func parseConflictPolicy(params url.Values) (string, error) {
+	switch conflict := params.Get("conflict"); conflict {
+	case "":
+		return conflictFail, nil
+	case conflictFail, conflictOverwrite, conflictRename:
+		return conflict, nil
+	default:
+		return "", invalidParameter("conflict", "conflict must be %s, %s or %s", conflictFail, conflictOverwrite, conflictRename)
+	}
+}
+
+// uploadTooLarge returns the error for a file over the size limit
+func uploadTooLarge(maxSize int64) *apiError {
+	return &apiError{
+		code:    ErrorCodeBodyTooLarge,
+		message: fmt.Sprintf("file exceeds the upload limit of %d bytes", maxSize),
+		details: map[string]any{"maxSize": maxSize},
+	}
+}
+
+// writeUploadError replies with err, choosing the status from its code
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		internalError(w, r, "failed to upload file", err)
+		return
+	}
+
+	status := http.StatusBadRequest
+	switch apiErr.code {
+	case ErrorCodeForbidden, ErrorCodeReadOnly:
+		status = http.StatusForbidden
+	case ErrorCodeNotFound:
+		status = http.StatusNotFound
+	case ErrorCodeAlreadyExists, ErrorCodeOffsetMismatch, ErrorCodeUploadBusy:
+		status = http.StatusConflict
+	case ErrorCodeBodyTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case ErrorCodeInvalidContentType:
+		status = http.StatusUnsupportedMediaType
+	case ErrorCodeTooManyUploads:
+		status = http.StatusTooManyRequests
+	}
+	writeRequestError(w, r, err, apiErr.code, status)
+}
+
+// checkStateDir returns an error if the upload state directory is inside one
+// of roots, where its records could be listed
+func (u *uploadManager) checkStateDir(roots []string) error {
+	if u == nil || u.stateDir == "" {
+		return nil
+	}
+
+	root, err := rootContaining(roots, u.stateDir)
+	if err != nil {
+		return err
+	}
+	if root != "" {
+		return fmt.Errorf("upload state directory %s must be outside the served directory %s", u.stateDir, root)
+	}
+	return nil
+}
+
+// reopenUploadDir resolves the directory of an upload started before the
+// server restarted, returning an error if the user can no longer upload
+// files into it
+func (s *Server) reopenUploadDir(user, urlPath string) (*uploadDir, error) {
+	if err := s.validatePath(urlPath); err != nil {
+		return nil, err
+	}
+	mount, userRoot, relPath, err := s.locate(user, urlPath)
+	if err != nil {
+		return nil, err
+	}
+	if mount == nil || mount.ReadOnly {
+		return nil, errors.New("files can't be uploaded here")
+	}
+
+	fullPath, err := s.resolveRequestPath(userRoot, relPath)
+	if err != nil {
+		return nil, err
+	}
+	requestedPath := filepath.Join(userRoot, filepath.Clean("/"+relPath))
+	if !s.allowed(mount, user, requestedPath, fullPath) {
+		return nil, errors.New("uploading to this directory is not allowed")
+	}
+	return &uploadDir{mount: mount, user: user, urlPath: urlPath, path: fullPath}, nil
+}
+
+// resolveUploadDir resolves the directory at urlPath for an upload, replying
+// with an error and returning false if files can't be uploaded into it
+func (s *Server) resolveUploadDir(w http.ResponseWriter, r *http.Request, urlPath string) (*uploadDir, bool) {
+	target, ok := s.resolveTarget(w, r, urlPath)
+	if !ok {
+		return nil, false
+	}
+	if target.mount == nil || target.mount.ReadOnly {
+		writeError(w, r, ErrorCodeReadOnly, "Files can't be uploaded here", http.StatusForbidden)
+		return nil, false
+	}
+
+	info, err := os.Stat(target.fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, r, ErrorCodeNotFound, "File or directory does not exist", http.StatusNotFound)
+			return nil, false
+		}
+		internalError(w, r, "failed to stat path", err)
+		return nil, false
+	}
+	if !info.IsDir() {
+		writeError(w, r, ErrorCodeNotADirectory, "Files can only be uploaded into a directory", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &uploadDir{mount: target.mount, user: target.user, urlPath: urlPath, path: target.fullPath}, true
+}
+
+// checkUploadName returns where a file called name is saved in dir. The name
+// must be a single path component, pass validatePath and be allowed by the
+// ACL.
+func (s *Server) checkUploadName(dir *uploadDir, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, uploadTempPrefix) {
+		return "", &apiError{code: ErrorCodeInvalidPath, message: "invalid file name"}
+	}
+	if err := s.validatePath(path.Join("/", dir.urlPath, name)); err != nil {
+		return "", err
+	}
+
+	dest := filepath.Join(dir.path, name)
+	if !s.allowed(dir.mount, dir.user, dest, dest) {
+		return "", &apiError{code: ErrorCodeForbidden, message: "Uploading to this path is not allowed"}
+	}
+	return dest, nil
+}
+
+// checkConflict returns an error if saving to dest would replace a file that
+// the conflict policy says to keep. It's checked before the data is received,
+// and again when the file is saved.
+func checkConflict(dest, conflict string) error {
+	info, err := os.Lstat(dest)
+	if err != nil {
+		return nil
+	}
+	if info.IsDir() {
+		return &apiError{code: ErrorCodeAlreadyExists, message: "A directory with this name already exists"}
+	}
+	if conflict == conflictFail {
+		return errAlreadyExists
+	}
+	return nil
+}
+
+// numberedName returns name with n added before its extension, so that
+// report.txt becomes report-1.txt
+func numberedName(name string, n int) string {
+	ext := path.Ext(name)
+	if ext == name {
+		// Dot files like .bashrc have no extension
+		ext = ""
+	}
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// claimName moves the file at tempPath to dest unless dest exists. A hard
+// link claims the name atomically, so the filesystem must support them.
+func claimName(tempPath, dest string) error {
+	if err := os.Link(tempPath, dest); err != nil {
+		if os.IsExist(err) {
+			return errAlreadyExists
+		}
+		return err
+	}
+	return os.Remove(tempPath)
+}
+
+// saveUpload moves the complete upload at tempPath into dir as name,
+// following the conflict policy, and returns the name it was saved as
+func (s *Server) saveUpload(dir *uploadDir, tempPath, name, conflict string) (string, error) {
+	dest, err := s.checkUploadName(dir, name)
+	if err != nil {
+		return "", err
+	}
+	if err := checkConflict(dest, conflict); err != nil {
+		return "", err
+	}
+
+	switch conflict {
+	case conflictOverwrite:
+		// Readers see either the old file or the new one, never part of it
+		return name, os.Rename(tempPath, dest)
+	case conflictRename:
+		for n := 0; n <= maxRenameAttempts; n++ {
+			candidate := name
+			if n > 0 {
+				candidate = numberedName(name, n)
+				if dest, err = s.checkUploadName(dir, candidate); err != nil {
+					return "", err
+				}
+			}
+			err := claimName(tempPath, dest)
+			if errors.Is(err, errAlreadyExists) {
+				continue
+			}
+			return candidate, err
+		}
+		return "", &apiError{code: ErrorCodeAlreadyExists, message: "No free name was found for the file"}
+	default:
+		return name, claimName(tempPath, dest)
+	}
+}
+
+// deadlineReader is a request body that pushes back the connection's read
+// deadline before each read
+type deadlineReader struct {
+	io.ReadCloser
+	rc *http.ResponseController
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	d.rc.SetReadDeadline(time.Now().Add(readTimeout))
+	return d.ReadCloser.Read(p)
+}
+
+// extendReadDeadline lets r's body take longer than the server's read timeout
+// to arrive, which large files do, while a client that stops sending for that
+// long is still cut off
+func extendReadDeadline(w http.ResponseWriter, r *http.Request) {
+	r.Body = &deadlineReader{ReadCloser: r.Body, rc: http.NewResponseController(w)}
+}
+
+// createTempFile creates an empty file in dir to receive an upload
+func createTempFile(dir string) (*os.File, error) {
+	f, err := os.CreateTemp(dir, uploadTempPrefix+"*")
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, &apiError{code: ErrorCodeForbidden, message: "Permission denied"}
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// finishTempFile flushes a complete upload to disk and gives it the
+// permissions of a regular file
+func finishTempFile(f *os.File) error {
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// receiveFile saves the data in r as a file called name in dir. It's written
+// to a temporary file first so that no one sees a partial upload.
+func (s *Server) receiveFile(dir *uploadDir, name string, r io.Reader, conflict string) (*UploadedFile, error) {
+	dest, err := s.checkUploadName(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	// Refuse early rather than after receiving the whole file
+	if err := checkConflict(dest, conflict); err != nil {
+		return nil, err
+	}
+
+	f, err := createTempFile(dir.path)
+	if err != nil {
+		return nil, err
+	}
+	release, err := s.uploads.track(f.Name())
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	defer release()
+	defer f.Close()
+
+	maxSize := s.uploads.maxSize
+	n, err := io.Copy(f, io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive file: %w", err)
+	}
+	if n > maxSize {
+		return nil, uploadTooLarge(maxSize)
+	}
+	if err := finishTempFile(f); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	saved, err := s.saveUpload(dir, f.Name(), name, conflict)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadedFile{Name: saved, Size: n}, nil
+}
+
+// uploadFiles handles POST requests to /api/upload/<path>. A multipart form
+// uploads each of its file fields into the directory at path. A request with
+// an Upload-Length header instead starts a resumable upload of the file at
+// path, which is continued at /api/uploads/<id>.
+func (s *Server) uploadFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if s.uploads == nil {
+		writeUploadError(w, r, errUploadsDisabled)
+		return
+	}
+
+	conflict, err := parseConflictPolicy(r.URL.Query())
+	if err != nil {
+		writeRequestError(w, r, err, ErrorCodeInvalidParameter, http.StatusBadRequest)
+		return
+	}
+	if conflict == conflictOverwrite && !s.uploads.overwrite {
+		writeUploadError(w, r, errOverwriteDenied)
+		return
+	}
+
+	urlPath := strings.TrimPrefix(r.URL.Path, "/api/upload")
+	if r.Header.Get("Upload-Length") != "" {
+		s.createUpload(w, r, urlPath, conflict)
+		return
+	}
+
+	extendReadDeadline(w, r)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeUploadError(w, r, &apiError{code: ErrorCodeInvalidContentType, message: "Content-Type must be multipart/form-data"})
+		return
+	}
+	dir, ok := s.resolveUploadDir(w, r, urlPath)
+	if !ok {
+		return
+	}
+
+	uploaded := []UploadedFile{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			err = &apiError{code: ErrorCodeInvalidBody, message: "Malformed multipart body"}
+		} else if part.FormName() == "file" {
+			_, sp := startSpan(r.Context(), "receiveFile")
+			sp.setAttribute("file.name", part.FileName())
+			var file *UploadedFile
+			file, err = s.receiveFile(dir, part.FileName(), part, conflict)
+			if err == nil {
+				sp.setAttribute("file.size", file.Size)
+				uploaded = append(uploaded, *file)
+			}
+			sp.finish(err)
+		}
+		if err != nil {
+			// Files before the one that failed were saved
+			var apiErr *apiError
+			if errors.As(err, &apiErr) && len(uploaded) > 0 {
+				details := map[string]any{"uploaded": uploaded}
+				maps.Copy(details, apiErr.details)
+				err = &apiError{code: apiErr.code, message: apiErr.message, details: details}
+			}
+			writeUploadError(w, r, err)
+			return
+		}
+	}
+	if len(uploaded) == 0 {
+		writeError(w, r, ErrorCodeInvalidBody, "No files were uploaded", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]any{"files": uploaded}); err != nil {
+		requestLogger(r).Error("failed to write response", "error", err)
+	}
+}
+
+// createUpload starts a resumable upload of the file at urlPath
+func (s *Server) createUpload(w http.ResponseWriter, r *http.Request, urlPath, conflict string) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		writeError(w, r, ErrorCodeBadRequest, "Upload-Length must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	if length > s.uploads.maxSize {
+		writeUploadError(w, r, uploadTooLarge(s.uploads.maxSize))
+		return
+	}
+
+	dirPath, name := path.Split(path.Clean("/" + urlPath))
+	dir, ok := s.resolveUploadDir(w, r, dirPath)
+	if !ok {
+		return
+	}
+	dest, err := s.checkUploadName(dir, name)
+	if err == nil {
+		err = checkConflict(dest, conflict)
+	}
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	f, err := createTempFile(dir.path)
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+	f.Close()
+
+	sess := &uploadSession{
+		user:     dir.user,
+		dir:      dir,
+		name:     name,
+		conflict: conflict,
+		length:   length,
+		tempPath: f.Name(),
+	}
+	if err := s.uploads.create(sess); err != nil {
+		os.Remove(f.Name())
+		writeUploadError(w, r, err)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Location", "/api/uploads/"+sess.id)
+	h.Set("Upload-Offset", "0")
+	h.Set("Upload-Length", strconv.FormatInt(length, 10))
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(UploadStatus{ID: sess.id, Length: length}); err != nil {
+		requestLogger(r).Error("failed to write response", "error", err)
+	}
+}
+
+// resumeUpload handles requests to /api/uploads/<id>. HEAD returns how much
+// of the file has been received, PATCH appends a chunk at Upload-Offset and
+// DELETE cancels the upload.
+func (s *Server) resumeUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodHead && r.Method != http.MethodPatch && r.Method != http.MethodDelete {
+		methodNotAllowed(w, r, http.MethodHead, http.MethodPatch, http.MethodDelete)
+		return
+	}
+	if s.uploads == nil {
+		writeUploadError(w, r, errUploadsDisabled)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/uploads/")
+	sess := s.uploads.get(id, userFromContext(r.Context()))
+	if sess == nil {
+		writeError(w, r, ErrorCodeNotFound, "Upload does not exist", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		s.appendUpload(w, r, sess)
+		return
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.done {
+		writeError(w, r, ErrorCodeNotFound, "Upload does not exist", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		s.uploads.remove(sess)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h := w.Header()
+	h.Set("Upload-Offset", strconv.FormatInt(sess.offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(sess.length, 10))
+	h.Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// appendUpload writes a PATCH request's body to an upload at Upload-Offset.
+// Whatever was received is kept if the request is cut short, so the client
+// can resume from the offset HEAD reports. The file is saved once all of it
+// has arrived.
+func (s *Server) appendUpload(w http.ResponseWriter, r *http.Request, sess *uploadSession) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != uploadChunkType {
+		writeUploadError(w, r, &apiError{code: ErrorCodeInvalidContentType, message: "Content-Type must be " + uploadChunkType})
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, r, ErrorCodeBadRequest, "Upload-Offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	// Chunks are appended one at a time
+	if !sess.mu.TryLock() {
+		writeUploadError(w, r, &apiError{code: ErrorCodeUploadBusy, message: "Another chunk is being uploaded"})
+		return
+	}
+	defer sess.mu.Unlock()
+	if sess.done {
+		writeError(w, r, ErrorCodeNotFound, "Upload does not exist", http.StatusNotFound)
+		return
+	}
+	if offset != sess.offset {
+		writeUploadError(w, r, &apiError{
+			code:    ErrorCodeOffsetMismatch,
+			message: "Upload-Offset doesn't match the data received",
+			details: map[string]any{"offset": sess.offset},
+		})
+		return
+	}
+
+	extendReadDeadline(w, r)
+	f, err := os.OpenFile(sess.tempPath, os.O_WRONLY, 0)
+	if err != nil {
+		internalError(w, r, "failed to open upload", err)
+		return
+	}
+	defer f.Close()
+
+	_, sp := startSpan(r.Context(), "appendUpload")
+	sp.setAttribute("upload.offset", sess.offset)
+	remaining := sess.length - sess.offset
+	var n int64
+	if _, err = f.Seek(sess.offset, io.SeekStart); err == nil {
+		n, err = io.Copy(f, io.LimitReader(r.Body, remaining+1))
+	}
+	sp.finish(err)
+
+	// Keep what was received, up to the length of the upload
+	tooLarge := n > remaining
+	if tooLarge {
+		n = remaining
+		if err == nil {
+			err = f.Truncate(sess.length)
+		}
+	}
+	sess.offset += n
+	s.uploads.touch(sess)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(sess.offset, 10))
+
+	switch {
+	case err != nil:
+		internalError(w, r, "failed to receive upload", err)
+		return
+	case tooLarge:
+		writeUploadError(w, r, &apiError{
+			code:    ErrorCodeBodyTooLarge,
+			message: "Chunk extends past Upload-Length",
+			details: map[string]any{"offset": sess.offset},
+		})
+		return
+	case sess.offset < sess.length:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// The whole file has arrived
+	err = finishTempFile(f)
+	if err == nil {
+		err = f.Close()
+	}
+	var saved string
+	if err == nil {
+		saved, err = s.saveUpload(sess.dir, sess.tempPath, sess.name, sess.conflict)
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.code == ErrorCodeAlreadyExists {
+		// Keep the data when the name was taken while it was uploading. An
+		// empty PATCH at the end saves it once the name is free, or DELETE
+		// cancels it.
+		writeUploadError(w, r, err)
+		return
+	}
+	s.uploads.remove(sess)
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(UploadedFile{Name: saved, Size: sess.length}); err != nil {
+		requestLogger(r).Error("failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,564 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// newUploadServer returns a server for root that accepts uploads of up to
+// maxSize bytes
+func newUploadServer(t *testing.T, root string, maxSize int64) *Server {
+	t.Helper()
+
+	s := &Server{rootDir: root}
+	if err := WithUploads(UploadConfig{MaxSize: maxSize})(s); err != nil {
+		t.Fatalf("WithUploads() error = %v", err)
+	}
+	t.Cleanup(func() { s.uploads.Close() })
+	return s
+}
+
+// multipartBody returns a form with a file field for each name and contents
+// pair in files
+func multipartBody(t *testing.T, files ...string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		fw, err := mw.CreateFormFile("file", files[i])
+		if err != nil {
+			t.Fatalf("failed to create form file: %v", err)
+		}
+		fw.Write([]byte(files[i+1]))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("failed to close form: %v", err)
+	}
+	return &buf, mw.FormDataContentType()
+}
+
+// tempUploads returns the names of unfinished uploads in dir
+func tempUploads(t *testing.T, dir string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, uploadTempPrefix+"*"))
+	if err != nil {
+		t.Fatalf("failed to list uploads: %v", err)
+	}
+	return matches
+}
+
+// withUser returns r as sent by user
+func withUser(r *http.Request, user string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+}
+
+func TestUploadFiles(t *testing.T) {
+	root := newSymlinkTree(t)
+	docs := filepath.Join(root, "docs")
+
+	upload := func(s *Server, urlPath string, files ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, contentType := multipartBody(t, files...)
+		r := httptest.NewRequest(http.MethodPost, urlPath, body)
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		s.uploadFiles(w, r)
+		return w
+	}
+
+	s := newUploadServer(t, root, 16)
+	s.uploads.overwrite = true
+
+	t.Run("new files", func(t *testing.T) {
+		w := upload(s, "/api/upload/docs", "a.txt", "first", "b.txt", "second")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusCreated, w.Body)
+		}
+		var resp struct{ Files []UploadedFile }
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(resp.Files) != 2 || resp.Files[0] != (UploadedFile{Name: "a.txt", Size: 5}) {
+			t.Errorf("files = %+v, want a.txt and b.txt", resp.Files)
+		}
+
+		data, err := os.ReadFile(filepath.Join(docs, "b.txt"))
+		if err != nil || string(data) != "second" {
+			t.Errorf("b.txt = %q, %v, want %q", data, err, "second")
+		}
+		if temps := tempUploads(t, docs); len(temps) != 0 {
+			t.Errorf("temporary files left behind: %v", temps)
+		}
+	})
+
+	conflicts := []struct {
+		query       string
+		content     string
+		wantStatus  int
+		wantName    string
+		wantContent string
+	}{
+		{"", "new", http.StatusConflict, "readme.txt", "hi"},
+		{"?conflict=overwrite", "replaced", http.StatusCreated, "readme.txt", "replaced"},
+		{"?conflict=rename", "renamed", http.StatusCreated, "readme-1.txt", "renamed"},
+	}
+	for _, tt := range conflicts {
+		t.Run("conflict "+tt.query, func(t *testing.T) {
+			w := upload(s, "/api/upload/docs"+tt.query, "readme.txt", tt.content)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status code = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			data, err := os.ReadFile(filepath.Join(docs, tt.wantName))
+			if err != nil || string(data) != tt.wantContent {
+				t.Errorf("%s = %q, %v, want %q", tt.wantName, data, err, tt.wantContent)
+			}
+		})
+	}
+
+	t.Run("earlier files are reported", func(t *testing.T) {
+		w := upload(s, "/api/upload/docs", "c.txt", "ok", "bad name.txt", "no")
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+		body := decodeError(t, w)
+		if body.Code != ErrorCodeInvalidPath || body.Details["uploaded"] == nil {
+			t.Errorf("error = %+v, want %v with the uploaded files", body, ErrorCodeInvalidPath)
+		}
+	})
+
+	readOnly := &Server{}
+	if err := WithMounts(&Mount{Name: "docs", Root: docs, ReadOnly: true})(readOnly); err != nil {
+		t.Fatalf("WithMounts() error = %v", err)
+	}
+	readOnly.uploads = s.uploads
+
+	acl, err := LoadACL(writeACLFile(t, t.TempDir(), "/docs/secret.txt deny all\n"))
+	if err != nil {
+		t.Fatalf("LoadACL() error = %v", err)
+	}
+	restricted := newUploadServer(t, root, 16)
+	restricted.acl = acl
+
+	tests := []struct {
+		name       string
+		s          *Server
+		urlPath    string
+		files      []string
+		wantStatus int
+		wantCode   string
+	}{
+		{"invalid name", s, "/api/upload/docs", []string{"bad name.txt", "x"}, http.StatusBadRequest, ErrorCodeInvalidPath},
+		{"dot dot", s, "/api/upload/docs", []string{"..", "x"}, http.StatusBadRequest, ErrorCodeInvalidPath},
+		{"temporary name", s, "/api/upload/docs", []string{uploadTempPrefix + "x", "x"}, http.StatusBadRequest, ErrorCodeInvalidPath},
+		{"too large", s, "/api/upload/docs", []string{"big.txt", strings.Repeat("x", 17)}, http.StatusRequestEntityTooLarge, ErrorCodeBodyTooLarge},
+		{"into a file", s, "/api/upload/docs/readme.txt", []string{"x.txt", "x"}, http.StatusBadRequest, ErrorCodeNotADirectory},
+		{"missing directory", s, "/api/upload/missing", []string{"x.txt", "x"}, http.StatusNotFound, ErrorCodeNotFound},
+		{"no files", s, "/api/upload/docs", nil, http.StatusBadRequest, ErrorCodeInvalidBody},
+		{"overwrite not allowed", restricted, "/api/upload/docs?conflict=overwrite", []string{"readme.txt", "x"}, http.StatusForbidden, ErrorCodeForbidden},
+		{"bad conflict policy", s, "/api/upload/docs?conflict=merge", []string{"x.txt", "x"}, http.StatusBadRequest, ErrorCodeInvalidParameter},
+		{"denied by the ACL", restricted, "/api/upload/docs", []string{"secret.txt", "x"}, http.StatusForbidden, ErrorCodeForbidden},
+		{"read-only mount", readOnly, "/api/upload/docs", []string{"x.txt", "x"}, http.StatusForbidden, ErrorCodeReadOnly},
+		{"disabled", &Server{rootDir: root}, "/api/upload/docs", []string{"x.txt", "x"}, http.StatusForbidden, ErrorCodeReadOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := upload(tt.s, tt.urlPath, tt.files...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status code = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if code := decodeError(t, w).Code; code != tt.wantCode {
+				t.Errorf("error code = %v, want %v", code, tt.wantCode)
+			}
+			if temps := tempUploads(t, docs); len(temps) != 0 {
+				t.Errorf("temporary files left behind: %v", temps)
+			}
+		})
+	}
+
+	t.Run("not multipart", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.uploadFiles(w, httptest.NewRequest(http.MethodPost, "/api/upload/docs", strings.NewReader("x")))
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusUnsupportedMediaType)
+		}
+	})
+}
+
+func TestNumberedName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.txt", "report-2.txt"},
+		{"archive.tar.gz", "archive.tar-2.gz"},
+		{"Makefile", "Makefile-2"},
+		{".bashrc", ".bashrc-2"},
+	}
+
+	for _, tt := range tests {
+		if got := numberedName(tt.name, 2); got != tt.want {
+			t.Errorf("numberedName(%q, 2) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	root := newSymlinkTree(t)
+	docs := filepath.Join(root, "docs")
+	s := newUploadServer(t, root, 1024)
+
+	create := func(urlPath string, length int) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, urlPath, nil)
+		r.Header.Set("Upload-Length", strconv.Itoa(length))
+		w := httptest.NewRecorder()
+		s.uploadFiles(w, withUser(r, "alice"))
+		return w
+	}
+	send := func(method, location, user string, offset int, data string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, location, strings.NewReader(data))
+		r.Header.Set("Content-Type", uploadChunkType)
+		r.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		w := httptest.NewRecorder()
+		s.resumeUpload(w, withUser(r, user))
+		return w
+	}
+
+	w := create("/api/upload/docs/data.bin", 10)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/api/uploads/") {
+		t.Fatalf("Location = %q, want an upload URL", location)
+	}
+
+	if w := send(http.MethodPatch, location, "alice", 0, "0123"); w.Code != http.StatusNoContent {
+		t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+
+	t.Run("progress", func(t *testing.T) {
+		w := send(http.MethodHead, location, "alice", 0, "")
+		if got := w.Header().Get("Upload-Offset"); got != "4" {
+			t.Errorf("Upload-Offset = %q, want 4", got)
+		}
+		if got := w.Header().Get("Upload-Length"); got != "10" {
+			t.Errorf("Upload-Length = %q, want 10", got)
+		}
+	})
+
+	t.Run("unfinished upload is hidden", func(t *testing.T) {
+		temps := tempUploads(t, docs)
+		if len(temps) != 1 {
+			t.Fatalf("temporary files = %v, want one", temps)
+		}
+
+		w := httptest.NewRecorder()
+		s.getFiles(w, httptest.NewRequest(http.MethodGet, "/api/files/docs", nil))
+		if strings.Contains(w.Body.String(), uploadTempPrefix) {
+			t.Errorf("listing shows the unfinished upload: %s", w.Body)
+		}
+		w = httptest.NewRecorder()
+		s.downloadFile(w, httptest.NewRequest(http.MethodGet, "/api/download/docs/"+filepath.Base(temps[0]), nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("download status code = %v, want %v", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("wrong offset", func(t *testing.T) {
+		w := send(http.MethodPatch, location, "alice", 2, "23")
+		if w.Code != http.StatusConflict {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusConflict)
+		}
+		body := decodeError(t, w)
+		if body.Code != ErrorCodeOffsetMismatch || body.Details["offset"] != float64(4) {
+			t.Errorf("error = %+v, want %v at offset 4", body, ErrorCodeOffsetMismatch)
+		}
+	})
+
+	t.Run("other users", func(t *testing.T) {
+		if w := send(http.MethodHead, location, "bob", 0, ""); w.Code != http.StatusNotFound {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("past the end", func(t *testing.T) {
+		w := send(http.MethodPatch, location, "alice", 4, "456789abc")
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		// The chunk that was too long kept the bytes that fit
+		w := send(http.MethodPatch, location, "alice", 10, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+		}
+		data, err := os.ReadFile(filepath.Join(docs, "data.bin"))
+		if err != nil || string(data) != "0123456789" {
+			t.Errorf("data.bin = %q, %v, want %q", data, err, "0123456789")
+		}
+		if temps := tempUploads(t, docs); len(temps) != 0 {
+			t.Errorf("temporary files left behind: %v", temps)
+		}
+		if w := send(http.MethodHead, location, "alice", 0, ""); w.Code != http.StatusNotFound {
+			t.Errorf("status code after completion = %v, want %v", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("existing file", func(t *testing.T) {
+		w := create("/api/upload/docs/data.bin", 1)
+		if code := decodeError(t, w).Code; code != ErrorCodeAlreadyExists {
+			t.Errorf("error code = %v, want %v", code, ErrorCodeAlreadyExists)
+		}
+	})
+
+	t.Run("name taken before completion", func(t *testing.T) {
+		location := create("/api/upload/docs/late.bin", 2).Header().Get("Location")
+		late := filepath.Join(docs, "late.bin")
+		if err := os.WriteFile(late, []byte("first"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+
+		w := send(http.MethodPatch, location, "alice", 0, "ab")
+		if code := decodeError(t, w).Code; code != ErrorCodeAlreadyExists {
+			t.Fatalf("error code = %v, want %v", code, ErrorCodeAlreadyExists)
+		}
+		if w := send(http.MethodHead, location, "alice", 0, ""); w.Header().Get("Upload-Offset") != "2" {
+			t.Fatalf("status code = %v, Upload-Offset = %q, want the upload kept", w.Code, w.Header().Get("Upload-Offset"))
+		}
+
+		if err := os.Remove(late); err != nil {
+			t.Fatalf("failed to remove file: %v", err)
+		}
+		if w := send(http.MethodPatch, location, "alice", 2, ""); w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+		}
+		data, err := os.ReadFile(late)
+		if err != nil || string(data) != "ab" {
+			t.Errorf("late.bin = %q, %v, want %q", data, err, "ab")
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		location := create("/api/upload/docs/other.bin", 5).Header().Get("Location")
+		if w := send(http.MethodDelete, location, "alice", 0, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("status code = %v, want %v", w.Code, http.StatusNoContent)
+		}
+		if temps := tempUploads(t, docs); len(temps) != 0 {
+			t.Errorf("temporary files left behind: %v", temps)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		location := create("/api/upload/docs/other.bin", 5).Header().Get("Location")
+		s.uploads.now = func() time.Time { return time.Now().Add(defaultUploadExpiry + time.Minute) }
+		defer func() { s.uploads.now = time.Now }()
+
+		if w := send(http.MethodHead, location, "alice", 0, ""); w.Code != http.StatusNotFound {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusNotFound)
+		}
+		if temps := tempUploads(t, docs); len(temps) != 0 {
+			t.Errorf("temporary files left behind: %v", temps)
+		}
+	})
+
+	t.Run("too many", func(t *testing.T) {
+		for i := 0; i < maxUploadsPerUser; i++ {
+			if w := create("/api/upload/docs/many.bin", 1); w.Code != http.StatusCreated {
+				t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusCreated, w.Body)
+			}
+		}
+		if w := create("/api/upload/docs/many.bin", 1); w.Code != http.StatusTooManyRequests {
+			t.Errorf("status code = %v, want %v", w.Code, http.StatusTooManyRequests)
+		}
+		s.uploads.Close()
+		if temps := tempUploads(t, docs); len(temps) != 0 {
+			t.Errorf("Close() left %d temporary files behind", len(temps))
+		}
+	})
+}
+
+func TestExtendReadDeadline(t *testing.T) {
+	// The body takes longer to arrive than the server's read timeout
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		extendReadDeadline(w, r)
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(data)
+	}))
+	srv.Config.ReadTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 6; i++ {
+			time.Sleep(50 * time.Millisecond)
+			pw.Write([]byte("chunk"))
+		}
+		pw.Close()
+	}()
+
+	resp, err := http.Post(srv.URL, "application/octet-stream", pr)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != strings.Repeat("chunk", 6) {
+		t.Errorf("response = %v %q, want the whole body echoed", resp.StatusCode, body)
+	}
+}
+
+func TestResumeUploadAfterRestart(t *testing.T) {
+	root := newSymlinkTree(t)
+	docs := filepath.Join(root, "docs")
+	stateDir := t.TempDir()
+
+	start := func() *Server {
+		t.Helper()
+		s := &Server{rootDir: root}
+		if err := WithUploads(UploadConfig{MaxSize: 1024, StateDir: stateDir})(s); err != nil {
+			t.Fatalf("WithUploads() error = %v", err)
+		}
+		s.uploads.start(s.reopenUploadDir)
+		return s
+	}
+	send := func(s *Server, method, location string, offset int, data string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, location, strings.NewReader(data))
+		r.Header.Set("Content-Type", uploadChunkType)
+		r.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		w := httptest.NewRecorder()
+		s.resumeUpload(w, withUser(r, "alice"))
+		return w
+	}
+
+	s := start()
+	var locations []string
+	for _, length := range []string{"10", "5"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/upload/docs/data-"+length+".bin", nil)
+		r.Header.Set("Upload-Length", length)
+		w := httptest.NewRecorder()
+		s.uploadFiles(w, withUser(r, "alice"))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusCreated, w.Body)
+		}
+		locations = append(locations, w.Header().Get("Location"))
+	}
+	if w := send(s, http.MethodPatch, locations[0], 0, "abc"); w.Code != http.StatusNoContent {
+		t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+
+	// The server stops while a multipart upload is being received and after
+	// the second upload has expired
+	f, err := createTempFile(docs)
+	if err != nil {
+		t.Fatalf("createTempFile() error = %v", err)
+	}
+	f.Close()
+	if _, err := s.uploads.track(f.Name()); err != nil {
+		t.Fatalf("track() error = %v", err)
+	}
+	s.uploads.mu.Lock()
+	expired := s.uploads.sessions[strings.TrimPrefix(locations[1], "/api/uploads/")]
+	expired.expires = time.Now()
+	s.uploads.mu.Unlock()
+	if err := s.uploads.save(expired, expired.expires); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	if err := s.uploads.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if temps := tempUploads(t, docs); len(temps) != 3 {
+		t.Fatalf("Close() left %d temporary files, want 3", len(temps))
+	}
+
+	s = start()
+	defer s.uploads.Close()
+
+	if temps := tempUploads(t, docs); len(temps) != 1 {
+		t.Errorf("restart left %d temporary files, want 1", len(temps))
+	}
+	if w := send(s, http.MethodHead, locations[1], 0, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expired upload status code = %v, want %v", w.Code, http.StatusNotFound)
+	}
+	w := send(s, http.MethodHead, locations[0], 0, "")
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "3" {
+		t.Fatalf("HEAD = %v with offset %q, want %v with offset 3", w.Code, w.Header().Get("Upload-Offset"), http.StatusOK)
+	}
+	if w := send(s, http.MethodPatch, locations[0], 3, "defghij"); w.Code != http.StatusOK {
+		t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if data, err := os.ReadFile(filepath.Join(docs, "data-10.bin")); err != nil || string(data) != "abcdefghij" {
+		t.Errorf("data-10.bin = %q, %v, want %q", data, err, "abcdefghij")
+	}
+	if entries, err := os.ReadDir(stateDir); err != nil || len(entries) != 0 {
+		t.Errorf("state directory has %d entries, %v, want none", len(entries), err)
+	}
+}
+
+func TestReapExpiredUploads(t *testing.T) {
+	root := newSymlinkTree(t)
+	docs := filepath.Join(root, "docs")
+	s := newUploadServer(t, root, 1024)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/upload/docs/data.bin", nil)
+	r.Header.Set("Upload-Length", "10")
+	w := httptest.NewRecorder()
+	s.uploadFiles(w, withUser(r, "alice"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	// No other upload is created or resumed, so only the sweep removes it
+	s.uploads.now = func() time.Time { return time.Now().Add(defaultUploadExpiry + time.Minute) }
+	s.uploads.reapInterval = 10 * time.Millisecond
+	s.uploads.start(nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tempUploads(t, docs)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expired upload was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUploadStateDirOutsideRoot(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+
+	webassets := fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}
+	_, err := NewServer(webassets, WithUploads(UploadConfig{StateDir: filepath.Join(root, "uploads")}))
+	if err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("NewServer() error = %v, want the state directory to be refused", err)
+	}
+
+	s, err := NewServer(webassets, WithUploads(UploadConfig{StateDir: t.TempDir()}))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	s.Close()
+}
@@ -0,0 +1,555 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWatchesPerUser = 8
+	// watchHistorySize is how many events of each directory are kept so
+	// clients can resume after reconnecting
+	watchHistorySize = 256
+	// watchLinger is how long a directory stays watched after its last
+	// client disconnects, so a client that reconnects can resume
+	watchLinger = time.Minute
+	// watchCoalesce is how long modify events for the same entry are
+	// merged, writing a large file causes one for every write
+	watchCoalesce = time.Second
+	// watchBufferSize is how many events a slow client may fall behind
+	// before it's told to reload the listing
+	watchBufferSize = 64
+	// watchRetry is how long browsers wait before reconnecting
+	watchRetry = 3 * time.Second
+
+	defaultWatchHeartbeat = 15 * time.Second
+)
+
+// Event types sent to clients
+const (
+	watchOpCreate = "create"
+	watchOpDelete = "delete"
+	watchOpModify = "modify"
+	watchOpRename = "rename"
+	// watchOpGone is sent when the directory itself is deleted or moved,
+	// the stream ends after it
+	watchOpGone = "gone"
+	// watchOpReset is sent when events may have been missed, the client
+	// should reload the listing
+	watchOpReset = "reset"
+)
+
+var (
+	errWatchLimit       = errors.New("too many watches")
+	errWatchUnavailable = errors.New("directory watching is not available")
+)
+
+// dirWatcher reports changes to the directories it watches
+type dirWatcher interface {
+	add(path string) error
+	remove(path string)
+	Close() error
+}
+
+// dirEvent is a change to a watched directory
+type dirEvent struct {
+	dir     string
+	op      string
+	name    string
+	oldName string
+	// written is set when a file opened for writing was closed, which ends
+	// a burst of modify events
+	written bool
+	// unwatched is set when the watch was removed because the directory is
+	// gone
+	unwatched bool
+}
+
+// WatchEvent is sent to clients watching a directory
+type WatchEvent struct {
+	Type    string `json:"type"`
+	Name    string `json:"name,omitempty"`
+	OldName string `json:"oldName,omitempty"`
+	// Entry describes the entry after a create, modify or rename
+	Entry *FileInfo `json:"entry,omitempty"`
+
+	id   string
+	seq  uint64
+	time time.Time
+}
+
+// WithWatchLimit limits how many directories each user may watch at once
+func WithWatchLimit(perUser int) Option {
+	return func(s *Server) error {
+		if perUser < 1 {
+			return errors.New("watch limit must be positive")
+		}
+		s.watches.perUser = perUser
+		return nil
+	}
+}
+
+// watchHub shares directory watches between clients. A nil *watchHub
+// watches nothing.
+type watchHub struct {
+	perUser   int
+	heartbeat time.Duration
+	// describe returns an entry for events, or nil if it should be hidden
+	describe func(dir, name string) *FileInfo
+	// epoch makes event IDs from before a restart unusable
+	epoch string
+
+	mu      sync.Mutex
+	watcher dirWatcher
+	closed  bool
+	seq     uint64
+	dirs    map[string]*watchedDir
+	users   map[string]int
+}
+
+// watchedDir is a directory with clients watching it
+type watchedDir struct {
+	path        string
+	subscribers map[*watchSubscriber]struct{}
+	// since is the sequence number before the first event that could be
+	// recorded, clients that saw older events can't resume
+	since   uint64
+	history []WatchEvent
+	linger  *time.Timer
+}
+
+// watchSubscriber is a client watching a directory
+type watchSubscriber struct {
+	dir    *watchedDir
+	user   string
+	events chan WatchEvent
+	// lagged is signalled when events were dropped because the client fell
+	// behind
+	lagged chan struct{}
+}
+
+func newWatchHub(describe func(dir, name string) *FileInfo) *watchHub {
+	epoch := make([]byte, 4)
+	rand.Read(epoch)
+
+	return &watchHub{
+		perUser:   defaultWatchesPerUser,
+		heartbeat: defaultWatchHeartbeat,
+		describe:  describe,
+		epoch:     hex.EncodeToString(epoch),
+		dirs:      make(map[string]*watchedDir),
+		users:     make(map[string]int),
+	}
+}
+
+// subscribe starts delivering the events of dir to a new subscriber. If
+// lastEventID is set, events after it are returned to be sent first; reset is
+// set if they're no longer known.
+func (h *watchHub) subscribe(dir, user, lastEventID string) (sub *watchSubscriber, backlog []WatchEvent, reset bool, err error) {
+	if h == nil {
+		return nil, nil, false, errWatchUnavailable
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, nil, false, errWatchUnavailable
+	}
+	if h.users[user] >= h.perUser {
+		return nil, nil, false, errWatchLimit
+	}
+	if h.watcher == nil {
+		watcher, err := newDirWatcher(h.dispatch, h.overflow)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("%w: %w", errWatchUnavailable, err)
+		}
+		h.watcher = watcher
+	}
+
+	d, ok := h.dirs[dir]
+	if !ok {
+		if err := h.watcher.add(dir); err != nil {
+			return nil, nil, false, err
+		}
+		d = &watchedDir{path: dir, subscribers: make(map[*watchSubscriber]struct{}), since: h.seq}
+		h.dirs[dir] = d
+	}
+	if d.linger != nil {
+		d.linger.Stop()
+		d.linger = nil
+	}
+
+	if lastEventID != "" {
+		backlog, reset = d.eventsAfter(h.epoch, lastEventID)
+	}
+
+	sub = &watchSubscriber{
+		dir:    d,
+		user:   user,
+		events: make(chan WatchEvent, watchBufferSize),
+		lagged: make(chan struct{}, 1),
+	}
+	d.subscribers[sub] = struct{}{}
+	h.users[user]++
+
+	return sub, backlog, reset, nil
+}
+
+// unsubscribe stops delivering events to sub. The directory stays watched
+// for a while in case the client reconnects.
+func (h *watchHub) unsubscribe(sub *watchSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d := sub.dir
+	if _, ok := d.subscribers[sub]; !ok {
+		return
+	}
+	delete(d.subscribers, sub)
+	if h.users[sub.user]--; h.users[sub.user] == 0 {
+		delete(h.users, sub.user)
+	}
+
+	if len(d.subscribers) == 0 && h.dirs[d.path] == d && !h.closed {
+		var linger *time.Timer
+		linger = time.AfterFunc(watchLinger, func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if d.linger == linger && len(d.subscribers) == 0 && h.dirs[d.path] == d {
+				h.removeDir(d)
+			}
+		})
+		d.linger = linger
+	}
+}
+
+// dispatch records an event from the watcher and sends it to subscribers
+func (h *watchHub) dispatch(ev dirEvent) {
+	event := WatchEvent{Type: ev.op, Name: ev.name, OldName: ev.oldName}
+	switch ev.op {
+	case watchOpModify:
+		if ev.name == "" {
+			// The directory's own attributes changed
+			return
+		}
+		fallthrough
+	case watchOpCreate, watchOpRename:
+		// Looked up outside the lock as it can be slow
+		event.Entry = h.describe(ev.dir, ev.name)
+		if event.Entry == nil && ev.op != watchOpRename {
+			// Hidden, or gone again already
+			return
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, ok := h.dirs[ev.dir]
+	if !ok {
+		return
+	}
+	if ev.op == watchOpModify && !ev.written && d.recentlyModified(ev.name) {
+		return
+	}
+
+	h.seq++
+	event.seq = h.seq
+	event.id = h.epoch + "-" + strconv.FormatUint(h.seq, 10)
+	event.time = time.Now()
+	d.history = append(d.history, event)
+	if len(d.history) > watchHistorySize {
+		d.history = d.history[len(d.history)-watchHistorySize:]
+	}
+
+	for sub := range d.subscribers {
+		sub.send(event)
+	}
+
+	if ev.op == watchOpGone {
+		if !ev.unwatched {
+			h.watcher.remove(d.path)
+		}
+		delete(h.dirs, d.path)
+		h.closeSubscribers(d)
+	}
+}
+
+// overflow tells every subscriber that events were lost
+func (h *watchHub) overflow() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, d := range h.dirs {
+		// Nobody can resume from before the lost events
+		d.since = h.seq + 1
+		d.history = nil
+		for sub := range d.subscribers {
+			sub.markLagged()
+		}
+	}
+}
+
+// removeDir stops watching d. h.mu must be held.
+func (h *watchHub) removeDir(d *watchedDir) {
+	h.watcher.remove(d.path)
+	delete(h.dirs, d.path)
+}
+
+// closeSubscribers ends the streams of d's subscribers. h.mu must be held.
+func (h *watchHub) closeSubscribers(d *watchedDir) {
+	for sub := range d.subscribers {
+		close(sub.events)
+		delete(d.subscribers, sub)
+		if h.users[sub.user]--; h.users[sub.user] == 0 {
+			delete(h.users, sub.user)
+		}
+	}
+}
+
+// Close ends every stream and stops watching
+func (h *watchHub) Close() error {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	for _, d := range h.dirs {
+		if d.linger != nil {
+			d.linger.Stop()
+		}
+		h.closeSubscribers(d)
+	}
+	h.dirs = make(map[string]*watchedDir)
+
+	if h.watcher == nil {
+		return nil
+	}
+	return h.watcher.Close()
+}
+
+// eventsAfter returns the events after lastEventID, or reset if some of them
+// are no longer known
+func (d *watchedDir) eventsAfter(epoch, lastEventID string) (events []WatchEvent, reset bool) {
+	idEpoch, seqStr, ok := strings.Cut(lastEventID, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !ok || err != nil || idEpoch != epoch || seq < d.since {
+		return nil, true
+	}
+	if len(d.history) > 0 && d.history[0].seq > seq+1 {
+		return nil, true
+	}
+
+	for _, event := range d.history {
+		if event.seq > seq {
+			events = append(events, event)
+		}
+	}
+	return events, false
+}
+
+// recentlyModified reports whether the last event was a recent modify of
+// name, which the next one can be merged into
+func (d *watchedDir) recentlyModified(name string) bool {
+	if len(d.history) == 0 {
+		return false
+	}
+	last := d.history[len(d.history)-1]
+	return last.Type == watchOpModify && last.Name == name && time.Since(last.time) < watchCoalesce
+}
+
+// send queues an event without blocking the watcher. A client that has
+// fallen too far behind is told to reload instead.
+func (sub *watchSubscriber) send(event WatchEvent) {
+	select {
+	case sub.events <- event:
+	default:
+		sub.markLagged()
+	}
+}
+
+func (sub *watchSubscriber) markLagged() {
+	select {
+	case sub.lagged <- struct{}{}:
+	default:
+	}
+}
+
+// describeWatchedEntry returns the entry name in dir for an event, or nil if
+// it no longer exists or is hidden by the symlink policy
+func (s *Server) describeWatchedEntry(dir, name string) *FileInfo {
+	info, err := os.Lstat(filepath.Join(dir, name))
+	if err != nil {
+		return nil
+	}
+	return s.newEntry(fs.FileInfoToDirEntry(info), statResult{info: info})
+}
+
+// watchFiles handles GET requests to /api/watch/<path>, streaming changes to
+// a directory as server-sent events
+func (s *Server) watchFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	target, ok := s.resolveTarget(w, r, strings.TrimPrefix(r.URL.Path, "/api/watch"))
+	if !ok {
+		return
+	}
+	if target.mount == nil {
+		writeError(w, r, ErrorCodeBadRequest, "The list of mounts can't be watched", http.StatusBadRequest)
+		return
+	}
+
+	info, err := os.Stat(target.fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, r, ErrorCodeNotFound, "File or directory does not exist", http.StatusNotFound)
+			return
+		}
+		internalError(w, r, "failed to stat path", err)
+		return
+	}
+	if !info.IsDir() {
+		writeError(w, r, ErrorCodeNotADirectory, "Only directories can be watched", http.StatusBadRequest)
+		return
+	}
+
+	sub, backlog, reset, err := s.watches.subscribe(target.fullPath, target.user, r.Header.Get("Last-Event-ID"))
+	switch {
+	case errors.Is(err, errWatchLimit):
+		writeErrorDetails(w, r, ErrorCodeTooManyWatches, "Too many directories are being watched", http.StatusTooManyRequests,
+			map[string]any{"limit": s.watches.perUser})
+		return
+	case errors.Is(err, errWatchUnavailable):
+		requestLogger(r).Warn("directory watching unavailable", "error", err)
+		writeError(w, r, ErrorCodeUnavailable, "Directory watching is not available", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		internalError(w, r, "failed to watch directory", err)
+		return
+	}
+	defer s.watches.unsubscribe(sub)
+
+	// The stream outlives the server's read and write timeouts
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// Stop proxies from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	visible := s.visibleEntries(target.mount, target.user, target.fullPath)
+	fmt.Fprintf(w, "retry: %d\n\n", watchRetry.Milliseconds())
+	if reset {
+		writeWatchEvent(w, WatchEvent{Type: watchOpReset})
+	}
+	for _, event := range backlog {
+		writeVisibleEvent(w, event, visible)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.watches.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			// The session is only checked when the stream starts, so logging
+			// out or the session expiring ends it here. The reset makes the
+			// client reload the listing, which asks it to log in again.
+			if !s.sessionActive(r, target.user) {
+				writeWatchEvent(w, WatchEvent{Type: watchOpReset})
+				rc.Flush()
+				return
+			}
+			fmt.Fprintf(w, "event: heartbeat\ndata: {}\n\n")
+		case <-sub.lagged:
+			writeWatchEvent(w, WatchEvent{Type: watchOpReset})
+		case event, ok := <-sub.events:
+			if !ok {
+				// The directory is gone or the server is shutting down
+				return
+			}
+			writeVisibleEvent(w, event, visible)
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// sessionActive reports whether the session r was authenticated with still
+// belongs to user
+func (s *Server) sessionActive(r *http.Request, user string) bool {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return false
+	}
+	sessionUser, err := s.sessionManager.SessionUser(cookie.Value)
+	return err == nil && sessionUser == user
+}
+
+// writeVisibleEvent writes event as the user sees it. Entries the ACL or the
+// symlink policy hide are left out, and renames to or from them look like
+// deletes and creates.
+func writeVisibleEvent(w io.Writer, event WatchEvent, visible func(name string) bool) {
+	isVisible := func(name string) bool {
+		return visible == nil || visible(name)
+	}
+
+	if event.Type == watchOpRename {
+		oldVisible := isVisible(event.OldName)
+		newVisible := event.Entry != nil && isVisible(event.Name)
+		switch {
+		case oldVisible && newVisible:
+		case newVisible:
+			event.Type, event.OldName = watchOpCreate, ""
+		case oldVisible:
+			event = WatchEvent{Type: watchOpDelete, Name: event.OldName, id: event.id}
+		default:
+			return
+		}
+	} else if event.Name != "" && !isVisible(event.Name) {
+		return
+	}
+
+	writeWatchEvent(w, event)
+}
+
+// writeWatchEvent writes an event in the server-sent events format
+func writeWatchEvent(w io.Writer, event WatchEvent) {
+	data, _ := json.Marshal(event)
+	if event.id != "" {
+		fmt.Fprintf(w, "id: %s\n", event.id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
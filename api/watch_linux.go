@@ -0,0 +1,192 @@
+//go:build linux
+
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask selects the events that change a directory listing
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+
+// inotifyWatcher watches directories with inotify
+type inotifyWatcher struct {
+	fd int
+	// file wraps fd so reads wait in the runtime poller and are interrupted
+	// by Close
+	file       *os.File
+	onEvent    func(dirEvent)
+	onOverflow func()
+
+	mu     sync.Mutex
+	closed bool
+	paths  map[int32]string
+	wds    map[string]int32
+}
+
+// inotifyEvent is an event as read from the kernel
+type inotifyEvent struct {
+	wd     int32
+	mask   uint32
+	cookie uint32
+	name   string
+}
+
+func newDirWatcher(onEvent func(dirEvent), onOverflow func()) (dirWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	w := &inotifyWatcher{
+		fd:         fd,
+		file:       os.NewFile(uintptr(fd), "inotify"),
+		onEvent:    onEvent,
+		onOverflow: onOverflow,
+		paths:      make(map[int32]string),
+		wds:        make(map[string]int32),
+	}
+	go w.run()
+
+	return w, nil
+}
+
+func (w *inotifyWatcher) add(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("watcher is closed")
+	}
+
+	wd, err := unix.InotifyAddWatch(w.fd, path, inotifyMask)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+	// The same directory reached through another path, e.g. a bind mount,
+	// shares the watch
+	if other, ok := w.paths[int32(wd)]; ok && other != path {
+		return fmt.Errorf("%s is already watched as %s", path, other)
+	}
+
+	w.paths[int32(wd)] = path
+	w.wds[path] = int32(wd)
+	return nil
+}
+
+func (w *inotifyWatcher) remove(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wd, ok := w.wds[path]
+	if !ok || w.closed {
+		return
+	}
+	delete(w.wds, path)
+	delete(w.paths, wd)
+	// Fails if the directory is already gone, which removes the watch too
+	unix.InotifyRmWatch(w.fd, uint32(wd))
+}
+
+func (w *inotifyWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.file.Close()
+}
+
+// run reads events until the watcher is closed
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		// A rename within a directory is a MOVED_FROM immediately followed
+		// by a MOVED_TO with the same cookie. Unpaired halves are moves in or
+		// out of the directory.
+		var movedFrom *inotifyEvent
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			event := &inotifyEvent{
+				wd:     int32(binary.NativeEndian.Uint32(buf[offset:])),
+				mask:   binary.NativeEndian.Uint32(buf[offset+4:]),
+				cookie: binary.NativeEndian.Uint32(buf[offset+8:]),
+				name:   strings.TrimRight(string(buf[offset+unix.SizeofInotifyEvent:offset+unix.SizeofInotifyEvent+nameLen]), "\x00"),
+			}
+			offset += unix.SizeofInotifyEvent + nameLen
+
+			if movedFrom != nil {
+				if event.mask&unix.IN_MOVED_TO != 0 && event.cookie == movedFrom.cookie && event.wd == movedFrom.wd {
+					w.handle(event, dirEvent{op: watchOpRename, name: event.name, oldName: movedFrom.name})
+					movedFrom = nil
+					continue
+				}
+				w.handle(movedFrom, dirEvent{op: watchOpDelete, name: movedFrom.name})
+				movedFrom = nil
+			}
+			if event.mask&unix.IN_MOVED_FROM != 0 {
+				movedFrom = event
+				continue
+			}
+			w.handle(event, translateEvent(event))
+		}
+		if movedFrom != nil {
+			w.handle(movedFrom, dirEvent{op: watchOpDelete, name: movedFrom.name})
+		}
+	}
+}
+
+// translateEvent describes an event other than a move out of the directory
+func translateEvent(event *inotifyEvent) dirEvent {
+	switch {
+	case event.mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		return dirEvent{op: watchOpCreate, name: event.name}
+	case event.mask&unix.IN_DELETE != 0:
+		return dirEvent{op: watchOpDelete, name: event.name}
+	case event.mask&unix.IN_CLOSE_WRITE != 0:
+		return dirEvent{op: watchOpModify, name: event.name, written: true}
+	case event.mask&(unix.IN_MODIFY|unix.IN_ATTRIB) != 0:
+		return dirEvent{op: watchOpModify, name: event.name}
+	default:
+		// The directory itself was deleted or moved, or its watch removed
+		return dirEvent{op: watchOpGone}
+	}
+}
+
+// handle reports an event to the callback
+func (w *inotifyWatcher) handle(event *inotifyEvent, dirEvent dirEvent) {
+	if event.mask&unix.IN_Q_OVERFLOW != 0 {
+		w.onOverflow()
+		return
+	}
+
+	w.mu.Lock()
+	path, ok := w.paths[event.wd]
+	// The kernel removed the watch because the directory is gone
+	dirEvent.unwatched = ok && event.mask&unix.IN_IGNORED != 0
+	if dirEvent.unwatched {
+		delete(w.paths, event.wd)
+		delete(w.wds, path)
+	}
+	w.mu.Unlock()
+
+	if ok {
+		dirEvent.dir = path
+		w.onEvent(dirEvent)
+	}
+}
@@ -0,0 +1,183 @@
+//go:build linux
+
+package api
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestInotifyInvalidatesListing(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("test content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	cache, err := newListingCache(ListingCacheConfig{Size: 10, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("newListingCache() error = %v", err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	if cache.watcher == nil {
+		t.Skip("inotify is not available")
+	}
+
+	info, err := os.Stat(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to stat dir: %v", err)
+	}
+	_, ticket := cache.get(tmpDir, info.ModTime())
+	cache.put(ticket, &cachedListing{modTime: info.ModTime()})
+	if stats := cache.stats(); stats.watched != 1 {
+		t.Fatalf("stats().watched = %v, want 1", stats.watched)
+	}
+
+	// Rewriting a file doesn't change the directory's mtime, only the watch
+	// notices
+	if err := os.WriteFile(testFile, []byte("changed content"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if listing, _ := cache.get(tmpDir, info.ModTime()); listing == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cached listing wasn't invalidated after the directory changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Removing the directory removes the watch
+	if err := os.RemoveAll(tmpDir); err != nil {
+		t.Fatalf("failed to remove dir: %v", err)
+	}
+	for cache.stats().watched != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("watch wasn't dropped after the directory was removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchFilesEndpoint(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	webassets := fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}
+	s, err := NewServer(webassets)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.watches.heartbeat = 50 * time.Millisecond
+
+	token, err := s.sessionManager.CreateSession("alice", "password")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	srv := httptest.NewServer(s.handler)
+	t.Cleanup(srv.Close)
+
+	watch := func(lastEventID string) (*bufio.Reader, func()) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/watch/", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("got %v with Content-Type %q, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+
+	// nextEvent returns the ID, type and data of the next event other than a
+	// heartbeat
+	nextEvent := func(r *bufio.Reader) (id, typ, data string) {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read event: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			case line == "" && typ == "heartbeat":
+				id, typ, data = "", "", ""
+			case line == "" && typ != "":
+				return id, typ, data
+			}
+		}
+	}
+
+	events, stop := watch("")
+	if err := os.WriteFile(filepath.Join(tmpDir, "new.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	id, typ, data := nextEvent(events)
+	if typ != watchOpCreate || !strings.Contains(data, `"name":"new.txt"`) {
+		t.Fatalf("event = %s %s, want create of new.txt", typ, data)
+	}
+	stop()
+
+	// Changes made while disconnected are sent on reconnecting
+	if err := os.Rename(filepath.Join(tmpDir, "new.txt"), filepath.Join(tmpDir, "renamed.txt")); err != nil {
+		t.Fatalf("failed to rename file: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.watches.mu.Lock()
+		history := s.watches.dirs[tmpDir].history
+		s.watches.mu.Unlock()
+		if history[len(history)-1].Type == watchOpRename {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rename wasn't recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events, stop = watch(id)
+	defer stop()
+	for {
+		_, typ, data = nextEvent(events)
+		if typ != watchOpModify {
+			break
+		}
+	}
+	if typ != watchOpRename || !strings.Contains(data, `"oldName":"new.txt"`) {
+		t.Errorf("event = %s %s, want rename of new.txt", typ, data)
+	}
+
+	// Logging out ends the stream at the next heartbeat
+	s.sessionManager.DeleteSession(token)
+	for typ != watchOpReset {
+		_, typ, _ = nextEvent(events)
+	}
+	if _, err := events.ReadString('\n'); err != io.EOF {
+		t.Errorf("read after logout error = %v, want %v", err, io.EOF)
+	}
+}
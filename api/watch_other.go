@@ -0,0 +1,11 @@
+//go:build !linux
+
+package api
+
+import "errors"
+
+// newDirWatcher fails where inotify isn't available, leaving the listing
+// cache to expire entries after its TTL
+func newDirWatcher(onEvent func(dirEvent), onOverflow func()) (dirWatcher, error) {
+	return nil, errors.New("directory watching is not supported on this platform")
+}
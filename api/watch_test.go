@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEventsAfter(t *testing.T) {
+	d := &watchedDir{
+		since:   5,
+		history: []WatchEvent{{seq: 6}, {seq: 7}, {seq: 8}},
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		wantSeqs    []uint64
+		wantReset   bool
+	}{
+		{"resume", "ep-6", []uint64{7, 8}, false},
+		{"up to date", "ep-8", nil, false},
+		{"before the directory was watched", "ep-4", nil, true},
+		{"from before a restart", "other-6", nil, true},
+		{"malformed", "garbage", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, reset := d.eventsAfter("ep", tt.lastEventID)
+			if reset != tt.wantReset {
+				t.Errorf("eventsAfter() reset = %v, want %v", reset, tt.wantReset)
+			}
+			var seqs []uint64
+			for _, event := range events {
+				seqs = append(seqs, event.seq)
+			}
+			if len(seqs) != len(tt.wantSeqs) || (len(seqs) > 0 && seqs[0] != tt.wantSeqs[0]) {
+				t.Errorf("eventsAfter() = %v, want %v", seqs, tt.wantSeqs)
+			}
+		})
+	}
+
+	t.Run("events dropped from the history", func(t *testing.T) {
+		d := &watchedDir{history: []WatchEvent{{seq: 10}}}
+		if _, reset := d.eventsAfter("ep", "ep-7"); !reset {
+			t.Error("eventsAfter() reset = false, want true")
+		}
+	})
+}
+
+func TestWriteVisibleEvent(t *testing.T) {
+	visible := func(name string) bool { return name != "secret" }
+	entry := func(name string) *FileInfo { return &FileInfo{Name: name, Type: "file"} }
+
+	tests := []struct {
+		name  string
+		event WatchEvent
+		want  string
+	}{
+		{
+			name:  "create",
+			event: WatchEvent{Type: watchOpCreate, Name: "a", Entry: entry("a"), id: "ep-1"},
+			want:  "id: ep-1\nevent: create\ndata: {\"type\":\"create\",\"name\":\"a\",\"entry\":{\"name\":\"a\",\"type\":\"file\",\"size\":0}}\n\n",
+		},
+		{
+			name:  "hidden entry",
+			event: WatchEvent{Type: watchOpDelete, Name: "secret", id: "ep-2"},
+			want:  "",
+		},
+		{
+			name:  "renamed from a hidden name",
+			event: WatchEvent{Type: watchOpRename, Name: "a", OldName: "secret", Entry: entry("a"), id: "ep-3"},
+			want:  "id: ep-3\nevent: create\ndata: {\"type\":\"create\",\"name\":\"a\",\"entry\":{\"name\":\"a\",\"type\":\"file\",\"size\":0}}\n\n",
+		},
+		{
+			name:  "renamed to a hidden name",
+			event: WatchEvent{Type: watchOpRename, Name: "secret", OldName: "a", Entry: entry("secret"), id: "ep-4"},
+			want:  "id: ep-4\nevent: delete\ndata: {\"type\":\"delete\",\"name\":\"a\"}\n\n",
+		},
+		{
+			name:  "renamed to a hidden symlink",
+			event: WatchEvent{Type: watchOpRename, Name: "link", OldName: "a", id: "ep-5"},
+			want:  "id: ep-5\nevent: delete\ndata: {\"type\":\"delete\",\"name\":\"a\"}\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeVisibleEvent(&buf, tt.event, visible)
+			if buf.String() != tt.want {
+				t.Errorf("writeVisibleEvent() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchHub(t *testing.T) {
+	newHub := func() *watchHub {
+		h := newWatchHub(func(dir, name string) *FileInfo { return &FileInfo{Name: name, Type: "file"} })
+		h.watcher = &fakeWatcher{}
+		h.perUser = 1
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+
+	t.Run("per user limit", func(t *testing.T) {
+		h := newHub()
+
+		sub, _, _, err := h.subscribe("/dir", "alice", "")
+		if err != nil {
+			t.Fatalf("subscribe() error = %v", err)
+		}
+		if _, _, _, err := h.subscribe("/other", "alice", ""); !errors.Is(err, errWatchLimit) {
+			t.Errorf("second subscribe() error = %v, want %v", err, errWatchLimit)
+		}
+		if _, _, _, err := h.subscribe("/dir", "bob", ""); err != nil {
+			t.Errorf("subscribe() for another user error = %v", err)
+		}
+
+		h.unsubscribe(sub)
+		if _, _, _, err := h.subscribe("/other", "alice", ""); err != nil {
+			t.Errorf("subscribe() after unsubscribe() error = %v", err)
+		}
+	})
+
+	t.Run("resume after reconnecting", func(t *testing.T) {
+		h := newHub()
+
+		sub, _, _, err := h.subscribe("/dir", "alice", "")
+		if err != nil {
+			t.Fatalf("subscribe() error = %v", err)
+		}
+		h.dispatch(dirEvent{dir: "/dir", op: watchOpCreate, name: "a"})
+		first := <-sub.events
+		h.unsubscribe(sub)
+
+		// Missed while disconnected
+		h.dispatch(dirEvent{dir: "/dir", op: watchOpDelete, name: "a"})
+
+		sub, backlog, reset, err := h.subscribe("/dir", "alice", first.id)
+		if err != nil {
+			t.Fatalf("subscribe() error = %v", err)
+		}
+		defer h.unsubscribe(sub)
+		if reset || len(backlog) != 1 || backlog[0].Type != watchOpDelete {
+			t.Errorf("subscribe() backlog = %+v, reset = %v, want the delete", backlog, reset)
+		}
+	})
+
+	t.Run("modify events are merged", func(t *testing.T) {
+		h := newHub()
+
+		sub, _, _, err := h.subscribe("/dir", "alice", "")
+		if err != nil {
+			t.Fatalf("subscribe() error = %v", err)
+		}
+		defer h.unsubscribe(sub)
+
+		for range 3 {
+			h.dispatch(dirEvent{dir: "/dir", op: watchOpModify, name: "a"})
+		}
+		h.dispatch(dirEvent{dir: "/dir", op: watchOpModify, name: "a", written: true})
+
+		if got := len(sub.events); got != 2 {
+			t.Errorf("got %d events, want 2", got)
+		}
+	})
+
+	t.Run("directory gone", func(t *testing.T) {
+		h := newHub()
+
+		sub, _, _, err := h.subscribe("/dir", "alice", "")
+		if err != nil {
+			t.Fatalf("subscribe() error = %v", err)
+		}
+		h.dispatch(dirEvent{dir: "/dir", op: watchOpGone, unwatched: true})
+
+		if event := <-sub.events; event.Type != watchOpGone {
+			t.Errorf("event = %v, want %v", event.Type, watchOpGone)
+		}
+		if _, ok := <-sub.events; ok {
+			t.Error("events channel is open, want closed")
+		}
+		h.unsubscribe(sub)
+		if _, _, _, err := h.subscribe("/other", "alice", ""); err != nil {
+			t.Errorf("subscribe() after the directory was removed error = %v", err)
+		}
+	})
+}
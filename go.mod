@@ -6,4 +6,4 @@ toolchain go1.24.11
 
 require golang.org/x/crypto v0.47.0
 
-require golang.org/x/sys v0.40.0 // indirect
+require golang.org/x/sys v0.40.0
@@ -1,29 +1,269 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"embed"
+	"errors"
+	"flag"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/goteleport-interview/fs4/api"
 )
 
-const listenPort = 8080
+const (
+	listenPort      = 8080
+	shutdownTimeout = 30 * time.Second
+)
 
 //go:embed web/dist
 var assets embed.FS
 
 func main() {
+	aclFile := flag.String("acl", "", "path to an ACL file restricting access to paths under the served directory")
+	usersFile := flag.String("users", "", "path to a JSON users file replacing the built in users")
+	userHomes := flag.Bool("user-homes", false, "jail each non-admin user to their configured home directory")
+	auditLog := flag.String("audit-log", "", "path to an append-only audit log of logins and file access")
+	auditKeyFile := flag.String("audit-key-file", "", "file holding the secret, at least 32 bytes, that keys the audit log's hash chain; keep it away from the log")
+	verifyAudit := flag.String("verify-audit", "", "verify the hash chain of the audit log at this path with -audit-key-file and exit")
+	auditForward := flag.String("audit-forward", "", "host:port of a collector to forward audit events to over TLS")
+	auditForwardFormat := flag.String("audit-forward-format", api.AuditFormatSyslog, "forwarded audit event format: syslog, cef or json")
+	auditForwardCA := flag.String("audit-forward-ca", "", "PEM file of CAs trusted for the audit collector, defaults to the system roots")
+	auditForwardSpool := flag.String("audit-forward-spool", "", "file buffering undelivered audit events, defaults to the audit log path with .spool appended")
+	metricsTokenFile := flag.String("metrics-token-file", "", "file holding a bearer token that grants access to /metrics")
+	metricsAddr := flag.String("metrics-addr", "", "internal address to serve /metrics on without authentication, e.g. localhost:9090")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file to serve HTTPS with, requires -tls-key")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	symlinks := flag.String("symlinks", string(api.SymlinkFollow), "symbolic link policy: follow links inside the served directory, deny requests through links, or hide them")
+	statConcurrency := flag.Int("stat-concurrency", 16, "how many directory entries to stat in parallel, raise it for network mounts")
+	statBudget := flag.Duration("stat-budget", 10*time.Second, "time a listing may spend reading entries before the rest are reported as timed out")
+	listCacheSize := flag.Int("list-cache-size", 1024, "most directory listings to cache, 0 disables the cache")
+	listCacheTTL := flag.Duration("list-cache-ttl", 5*time.Second, "how long cached listings are used for where directories can't be watched for changes")
+	watchLimit := flag.Int("watch-limit", 8, "most directories each user may watch for changes at once")
+	archiveMaxSize := flag.Int64("archive-max-size", 1<<30, "most bytes of files a directory archive download may contain")
+	archiveMaxFiles := flag.Int("archive-max-files", 10000, "most files and directories a directory archive download may contain")
+	thumbnailCacheDir := flag.String("thumbnail-cache-dir", defaultThumbnailCacheDir(), "directory outside the served directories to cache image thumbnails in, empty disables the cache")
+	thumbnailMaxPixels := flag.Int64("thumbnail-max-pixels", 50_000_000, "largest image in pixels that thumbnails are generated for")
+	thumbnailConcurrency := flag.Int("thumbnail-concurrency", 4, "how many thumbnails may be generated at once")
+	thumbnailCacheSize := flag.Int64("thumbnail-cache-size", 256<<20, "most bytes of thumbnails to cache before the least recently used are removed")
+	uploads := flag.Bool("uploads", false, "allow users to upload files into directories they can see, except on read-only mounts")
+	uploadMaxSize := flag.Int64("upload-max-size", 1<<30, "largest file in bytes that may be uploaded")
+	uploadOverwrite := flag.Bool("upload-overwrite", false, "let uploads replace existing files when they ask to with conflict=overwrite")
+	uploadExpiry := flag.Duration("upload-expiry", 24*time.Hour, "how long an unfinished resumable upload is kept after its last chunk")
+	uploadStateDir := flag.String("upload-state-dir", defaultUploadStateDir(), "directory outside the served directories to record unfinished uploads in so they can be resumed after a restart, empty keeps them in memory")
+	traceEndpoint := flag.String("trace-endpoint", "", "OTLP/HTTP traces URL to export request traces to, e.g. http://localhost:4318/v1/traces")
+	var mounts []*api.Mount
+	flag.Func("mount", "serve a named directory as name=dir[,ro][,acl=file] instead of the working directory (repeatable)", func(spec string) error {
+		m, err := api.ParseMountSpec(spec)
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, m)
+		return nil
+	})
+	flag.Parse()
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
+	if *verifyAudit != "" {
+		count, err := api.VerifyAuditLog(*verifyAudit, readAuditKey(*auditKeyFile))
+		if err != nil {
+			fatal("audit log verification failed", err, "records", count)
+		}
+		fmt.Printf("audit log verified: %d records\n", count)
+		return
+	}
+
 	webassets, err := fs.Sub(assets, "web/dist")
 	if err != nil {
-		log.Fatalln("could not embed webassets", err)
+		fatal("could not embed webassets", err)
 	}
 
-	s, err := api.NewServer(webassets)
+	symlinkPolicy, err := api.ParseSymlinkPolicy(*symlinks)
 	if err != nil {
-		log.Fatalln(err)
+		fatal("invalid -symlinks", err)
+	}
+	opts := []api.Option{
+		api.WithSymlinkPolicy(symlinkPolicy),
+		api.WithStatLimits(*statConcurrency, *statBudget),
+		api.WithWatchLimit(*watchLimit),
+		api.WithArchiveLimits(*archiveMaxSize, *archiveMaxFiles),
+		api.WithThumbnails(api.ThumbnailConfig{
+			CacheDir:     *thumbnailCacheDir,
+			MaxPixels:    *thumbnailMaxPixels,
+			Concurrency:  *thumbnailConcurrency,
+			MaxCacheSize: *thumbnailCacheSize,
+		}),
+	}
+	if *listCacheSize > 0 {
+		opts = append(opts, api.WithListingCache(api.ListingCacheConfig{Size: *listCacheSize, TTL: *listCacheTTL}))
+	}
+	if *uploads {
+		opts = append(opts, api.WithUploads(api.UploadConfig{
+			MaxSize:   *uploadMaxSize,
+			Expiry:    *uploadExpiry,
+			Overwrite: *uploadOverwrite,
+			StateDir:  *uploadStateDir,
+		}))
+	}
+	if *aclFile != "" {
+		opts = append(opts, api.WithACLFile(*aclFile))
+	}
+	if *usersFile != "" {
+		opts = append(opts, api.WithUsersFile(*usersFile))
+	}
+	if *userHomes {
+		opts = append(opts, api.WithUserHomes())
+	}
+	if *auditLog != "" {
+		opts = append(opts, api.WithAuditLog(*auditLog, readAuditKey(*auditKeyFile)))
+	}
+	if *auditForward != "" {
+		tlsConfig, err := collectorTLSConfig(*auditForwardCA)
+		if err != nil {
+			fatal("could not configure audit forwarding", err)
+		}
+		spool := *auditForwardSpool
+		if spool == "" {
+			spool = *auditLog + ".spool"
+		}
+		opts = append(opts, api.WithAuditForwarder(api.AuditForwarderConfig{
+			Addr:      *auditForward,
+			Format:    *auditForwardFormat,
+			TLSConfig: tlsConfig,
+			SpoolPath: spool,
+		}))
+	}
+	if *metricsTokenFile != "" {
+		token, err := os.ReadFile(*metricsTokenFile)
+		if err != nil {
+			fatal("could not read metrics token", err)
+		}
+		opts = append(opts, api.WithMetricsToken(strings.TrimSpace(string(token))))
 	}
+	if *tlsCert != "" || *tlsKey != "" {
+		opts = append(opts, api.WithTLSCertificate(*tlsCert, *tlsKey))
+	}
+	if *traceEndpoint != "" {
+		opts = append(opts, api.WithTracing(api.TracingConfig{Endpoint: *traceEndpoint}))
+	}
+	if len(mounts) > 0 {
+		opts = append(opts, api.WithMounts(mounts...))
+	}
+
+	s, err := api.NewServer(webassets, opts...)
+	if err != nil {
+		fatal("could not create server", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", s.MetricsHandler())
+		metricsServer = api.NewHTTPServer(*metricsAddr, metricsMux)
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				fatal("metrics listener failed", err)
+			}
+		}()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.ListenAndServe(fmt.Sprintf("localhost:%d", listenPort))
+	}()
+
+	select {
+	case err := <-serveErr:
+		s.Close()
+		fatal("server failed", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down, draining in-flight requests", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	log.Fatalln(s.ListenAndServe(fmt.Sprintf("localhost:%d", listenPort)))
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		fatal("shutdown failed", err)
+	}
+	slog.Info("shutdown complete")
+}
+
+// fatal logs err with msg and exits
+func fatal(msg string, err error, args ...any) {
+	slog.Error(msg, append([]any{"error", err}, args...)...)
+	os.Exit(1)
+}
+
+// readAuditKey returns the audit log key in path, exiting if there isn't one
+func readAuditKey(path string) []byte {
+	if path == "" {
+		fatal("the audit log requires a key", errors.New("-audit-key-file is not set"))
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		fatal("could not read audit log key", err)
+	}
+	return bytes.TrimSpace(key)
+}
+
+// collectorTLSConfig returns the TLS config for the audit collector, trusting
+// the CAs in caFile if it is set
+func collectorTLSConfig(caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit collector CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	tlsConfig.RootCAs = pool
+
+	return tlsConfig, nil
+}
+
+// defaultThumbnailCacheDir returns the user's cache directory for thumbnails,
+// or "" if there isn't one
+func defaultThumbnailCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "fs4", "thumbnails")
+}
+
+// defaultUploadStateDir returns the directory in the user's cache directory
+// to record unfinished uploads in, or "" if there isn't one
+func defaultUploadStateDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "fs4", "uploads")
 }
@@ -1,4 +1,4 @@
-import { sortAndFilterFileData } from './utils/utils';
+import { applyWatchEvent, sortAndFilterFileData } from './utils/utils';
 import { FileDataTable } from './FileDataTable/FileDataTable';
 import { ToolBar } from './ToolBar/ToolBar';
 import { useSortAndFilterState } from './utils/useSortAndFilterState';
@@ -11,7 +11,7 @@ import { useClient } from './utils/ClientContext';
 export function App() {
   const { sortState, search, handleSearchChange, handleSortChange } =
     useSortAndFilterState();
-  const { getFiles, isLoading, isAuthenticated } = useClient();
+  const { getFiles, watchFiles, isLoading, isAuthenticated } = useClient();
   const [contents, setContents] = useState<FileData[] | null>([]);
   const [filePathArr, setFilePathArr] = useState<string[]>([]);
   const params = useParams();
@@ -29,6 +29,24 @@ export function App() {
     }
   }, [params, getFiles, isAuthenticated]);
 
+  // Show changes to the directory as they happen
+  const isValidPath = contents !== null;
+  useEffect(() => {
+    if (!isAuthenticated || !isValidPath) {
+      return;
+    }
+
+    return watchFiles(filePathArr, event => {
+      if (event.type === 'reset' || event.type === 'gone') {
+        void getFiles(filePathArr).then(files => {
+          setContents(files);
+        });
+        return;
+      }
+      setContents(current => current && applyWatchEvent(current, event));
+    });
+  }, [filePathArr, getFiles, watchFiles, isAuthenticated, isValidPath]);
+
   return (
     <div id="app">
       {!isLoading ? (
@@ -43,6 +61,7 @@ export function App() {
             {contents !== null ? (
               <FileDataTable
                 fileData={sortAndFilterFileData(contents, sortState, search)}
+                filePathArr={filePathArr}
                 sortState={sortState}
                 handleSortChange={handleSortChange}
               />
@@ -12,6 +12,7 @@ describe('FileDataRow', () => {
           type: 'file',
           size: 24557,
         }}
+        filePathArr={[]}
       />
     );
 
@@ -29,6 +30,7 @@ describe('FileDataRow', () => {
             type: 'directory',
             size: 0,
           }}
+          filePathArr={[]}
         />
       </MemoryRouter>
     );
@@ -38,6 +40,43 @@ describe('FileDataRow', () => {
     expect(screen.getByTestId('folderIcon')).toBeInTheDocument();
   });
 
+  it('should link files to their download', () => {
+    render(
+      <FileDataRow
+        fileData={{
+          name: 'my notes.txt',
+          type: 'file',
+          size: 12,
+        }}
+        filePathArr={['home', 'alice']}
+      />
+    );
+
+    expect(screen.getByText('my notes.txt')).toHaveAttribute(
+      'href',
+      '/api/download/home/alice/my%20notes.txt'
+    );
+  });
+
+  it('should show a thumbnail for images', () => {
+    render(
+      <FileDataRow
+        fileData={{
+          name: 'screenshot.PNG',
+          type: 'file',
+          size: 2048,
+        }}
+        filePathArr={['designs']}
+      />
+    );
+
+    expect(screen.getByTestId('thumbnail')).toHaveAttribute(
+      'src',
+      '/api/thumbnail/designs/screenshot.PNG?width=32&height=32'
+    );
+    expect(screen.queryByTestId('fileIcon')).not.toBeInTheDocument();
+  });
+
   it('should output 100.0 GB for the file size text for the file size 99999999999', () => {
     render(
       <FileDataRow
@@ -46,6 +85,7 @@ describe('FileDataRow', () => {
           type: 'file',
           size: 99999999999,
         }}
+        filePathArr={[]}
       />
     );
 
@@ -60,6 +100,7 @@ describe('FileDataRow', () => {
           type: 'file',
           size: 999,
         }}
+        filePathArr={[]}
       />
     );
 
@@ -74,6 +115,7 @@ describe('FileDataRow', () => {
           type: 'file',
           size: 2340,
         }}
+        filePathArr={[]}
       />
     );
 
@@ -88,6 +130,7 @@ describe('FileDataRow', () => {
           type: 'file',
           size: 2350,
         }}
+        filePathArr={[]}
       />
     );
 
@@ -102,6 +145,7 @@ describe('FileDataRow', () => {
           type: 'file',
           size: 34273452345234624,
         }}
+        filePathArr={[]}
       />
     );
 
@@ -10,8 +10,22 @@ export function FileDataRow(props: FileDataRowProps) {
     maximumFractionDigits: 1,
   });
 
+  const filePath = [...props.filePathArr, props.fileData.name]
+    .map(encodeURIComponent)
+    .join('/');
+  const downloadUrl = `/api/download/${filePath}`;
+
   const getIcon = (fileType: string) => {
-    if (fileType === 'file') {
+    if (fileType === 'file' && isImage(props.fileData.name)) {
+      return (
+        <img
+          src={`/api/thumbnail/${filePath}?width=32&height=32`}
+          alt=""
+          loading="lazy"
+          data-testid="thumbnail"
+        />
+      );
+    } else if (fileType === 'file') {
       return <FontAwesomeIcon icon={faFile} data-testid="fileIcon" />;
     } else if (fileType === 'directory') {
       return <FontAwesomeIcon icon={faFolder} data-testid="folderIcon" />;
@@ -43,7 +57,9 @@ export function FileDataRow(props: FileDataRowProps) {
         {props.fileData.type === 'directory' ? (
           <Link to={props.fileData.name}>{props.fileData.name}</Link>
         ) : (
-          props.fileData.name
+          <a href={downloadUrl} download={props.fileData.name}>
+            {props.fileData.name}
+          </a>
         )}
       </td>
       <td className="right-align">
@@ -53,6 +69,12 @@ export function FileDataRow(props: FileDataRowProps) {
   );
 }
 
+// isImage reports whether the server can make a thumbnail of a file
+function isImage(name: string) {
+  return /\.(png|jpe?g|gif)$/i.test(name);
+}
+
 type FileDataRowProps = {
   fileData: FileData;
+  filePathArr: string[];
 };
@@ -92,7 +92,11 @@ export function FileDataTable(props: FileDataTableProps) {
         </thead>
         <tbody>
           {props.fileData.map(d => (
-            <FileDataRow key={d.name} fileData={d} />
+            <FileDataRow
+              key={d.name}
+              fileData={d}
+              filePathArr={props.filePathArr}
+            />
           ))}
         </tbody>
       </table>
@@ -105,6 +109,7 @@ export function FileDataTable(props: FileDataTableProps) {
 
 type FileDataTableProps = {
   fileData: FileData[];
+  filePathArr: string[];
   sortState: SortState;
   handleSortChange: (sortField: keyof FileData) => void;
 };
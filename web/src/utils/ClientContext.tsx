@@ -7,7 +7,7 @@ import {
   useCallback,
   useEffect,
 } from 'react';
-import { FileData } from './types';
+import { FileData, WatchEvent } from './types';
 
 interface ClientContextType {
   isAuthenticated: boolean;
@@ -21,8 +21,21 @@ interface ClientContextType {
   ) => Promise<void>;
   handleLogoff: () => Promise<void>;
   getFiles: (dirs: string[]) => Promise<FileData[] | null>;
+  watchFiles: (
+    dirs: string[],
+    onEvent: (event: WatchEvent) => void
+  ) => () => void;
 }
 
+const watchEventTypes: WatchEvent['type'][] = [
+  'create',
+  'delete',
+  'modify',
+  'rename',
+  'reset',
+  'gone',
+];
+
 const ClientContext = createContext<ClientContextType | null>(null);
 
 export function ClientProvider({
@@ -94,6 +107,24 @@ export function ClientProvider({
     []
   );
 
+  // watchFiles streams changes to a directory until the returned function is
+  // called. The browser reconnects and resumes after dropped connections.
+  const watchFiles = useCallback(
+    (dirs: string[], onEvent: (event: WatchEvent) => void): (() => void) => {
+      const path = dirs.filter(d => d !== '').join('/');
+      const source = new EventSource(`/api/watch/${path}`);
+      const listener = (e: MessageEvent<string>) => {
+        onEvent(JSON.parse(e.data) as WatchEvent);
+      };
+      for (const type of watchEventTypes) {
+        source.addEventListener(type, listener);
+      }
+
+      return () => source.close();
+    },
+    []
+  );
+
   const handleLogin = useCallback(
     async (username: string, password: string, e: FormEvent) => {
       setIsLoading(true);
@@ -159,6 +190,7 @@ export function ClientProvider({
         handleLogin,
         handleLogoff,
         getFiles,
+        watchFiles,
       }}
     >
       {children}
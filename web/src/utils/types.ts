@@ -10,3 +10,10 @@ export type SortState = {
 };
 
 export type SortDirection = '' | 'asc' | 'desc';
+
+export type WatchEvent = {
+  type: 'create' | 'delete' | 'modify' | 'rename' | 'reset' | 'gone';
+  name?: string;
+  oldName?: string;
+  entry?: FileData;
+};
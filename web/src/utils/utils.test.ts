@@ -1,5 +1,5 @@
 import { describe, expect, it } from 'vitest';
-import { sortAndFilterFileData } from './utils';
+import { applyWatchEvent, sortAndFilterFileData } from './utils';
 import { FileData } from './types';
 
 describe('sortAndFilterFileData', () => {
@@ -298,3 +298,48 @@ describe('sortAndFilterFileData', () => {
     });
   });
 });
+
+describe('applyWatchEvent', () => {
+  const fileData: FileData[] = [
+    { name: 'a', size: 1, type: 'file' },
+    { name: 'b', size: 2, type: 'file' },
+  ];
+
+  it('should add created entries', () => {
+    const arr = applyWatchEvent(fileData, {
+      type: 'create',
+      name: 'c',
+      entry: { name: 'c', size: 3, type: 'file' },
+    });
+    expect(arr.map(o => o.name)).toEqual(['a', 'b', 'c']);
+  });
+
+  it('should remove deleted entries', () => {
+    const arr = applyWatchEvent(fileData, { type: 'delete', name: 'a' });
+    expect(arr.map(o => o.name)).toEqual(['b']);
+  });
+
+  it('should replace modified entries', () => {
+    const arr = applyWatchEvent(fileData, {
+      type: 'modify',
+      name: 'b',
+      entry: { name: 'b', size: 20, type: 'file' },
+    });
+    expect(arr.find(o => o.name === 'b')?.size).toBe(20);
+    expect(arr.length).toBe(2);
+  });
+
+  it('should rename entries', () => {
+    const arr = applyWatchEvent(fileData, {
+      type: 'rename',
+      name: 'b',
+      oldName: 'a',
+      entry: { name: 'b', size: 1, type: 'file' },
+    });
+    expect(arr).toEqual([{ name: 'b', size: 1, type: 'file' }]);
+  });
+
+  it('should leave the contents unchanged on reset', () => {
+    expect(applyWatchEvent(fileData, { type: 'reset' })).toBe(fileData);
+  });
+});
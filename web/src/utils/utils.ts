@@ -1,4 +1,4 @@
-import { FileData, SortState } from 'src/utils/types';
+import { FileData, SortState, WatchEvent } from 'src/utils/types';
 
 const sortFileData = (fileData: FileData[], sortState: SortState) => {
   let newContents = [...fileData];
@@ -39,3 +39,24 @@ export const sortAndFilterFileData = (
     return sortFileData(fileData, sortState);
   }
 };
+
+// applyWatchEvent returns the directory contents after a change. Events that
+// require reloading the listing, reset and gone, leave it unchanged.
+export const applyWatchEvent = (
+  fileData: FileData[],
+  event: WatchEvent
+): FileData[] => {
+  if (event.type === 'reset' || event.type === 'gone') {
+    return fileData;
+  }
+
+  const removed = event.type === 'rename' ? event.oldName : event.name;
+  const newContents = fileData.filter(
+    o => o.name !== removed && o.name !== event.entry?.name
+  );
+  if (event.entry && event.type !== 'delete') {
+    newContents.push(event.entry);
+  }
+
+  return newContents;
+};